@@ -20,32 +20,75 @@ import (
 	"context"
 	"cryoutilities/internal"
 	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/cristalhq/acmd"
 )
 
 func main() {
-	// Delete old log file
-	os.Remove(internal.LogFilePath)
-	// Create a log file
-	logFile, err := os.OpenFile(internal.LogFilePath, os.O_RDWR|os.O_CREATE, 0666)
+	// Every command may touch the CPU settings, the helper included.
+	internal.RegisterCPUParams()
+	// The benchmark worker runs alongside the GUI or CLI that started it, so it must leave the log alone.
+	if len(os.Args) > 1 && os.Args[1] == internal.BenchWorkerCommand {
+		os.Exit(runBenchWorker(os.Args[2:]))
+	}
+	// The helper runs as root next to a GUI that owns the log, so it logs to stderr instead.
+	if len(os.Args) > 1 && os.Args[1] == internal.HelperCommand {
+		os.Exit(runHelper(os.Args[2:]))
+	}
+
+	// Logging flags work with every command, so they're taken out before the command is parsed.
+	logOptions, args, err := internal.ParseLogFlags(os.Args[1:])
 	if err != nil {
-		log.Panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	// So does --wait, for when the GUI or another command is busy with the same thing.
+	lockWait, args, err := internal.ParseLockFlags(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	internal.CryoUtils.LockWait = lockWait
+	os.Args = append(os.Args[:1], args...)
+	// The GUI has the log viewer, the CLI shows problems on the terminal.
+	if len(args) > 0 && args[0] != "gui" {
+		logOptions.Stderr = true
 	}
-	defer logFile.Close()
-	log.SetOutput(logFile)
 
-	// Create loggers
-	internal.CryoUtils.InfoLog = log.New(logFile, "INFO\t", log.Ldate|log.Ltime)
-	internal.CryoUtils.ErrorLog = log.New(logFile, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	// Start this run's log, keeping the previous runs'. The watch daemon keeps running while others
+	// start, so it has its own.
+	logPath := internal.LogFilePath
+	if len(args) > 0 && args[0] == "watch" {
+		logPath = internal.WatchLogFilePath
+	}
+	logger, err := internal.NewLogger(logPath, logOptions)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer logger.Close()
+	internal.UseLogger(logger)
 
 	// Print the current version as a test
 	internal.CryoUtils.InfoLog.Println("Current Version:", internal.CurrentVersionNumber)
 
+	// Recorded in the audit log with everything done as root.
+	internal.CryoUtils.Caller = "cli"
+	if len(os.Args) > 1 {
+		internal.CryoUtils.Caller = "cli " + os.Args[1]
+	}
+	if len(os.Args) < 2 || os.Args[1] == "gui" {
+		internal.CryoUtils.Caller = "gui"
+	}
+
 	// Provide a command structure for parsing
 	cmds := []acmd.Command{
 		{
@@ -58,14 +101,14 @@ func main() {
 		},
 		{
 			Name:        "swap",
-			Description: "Change swap file size in increments of 1GB.",
-			ExecFunc: func(_ context.Context, args []string) error {
+			Description: "Change swap file size in increments of 1GB. Ctrl-C turns swap back on instead of leaving it off.",
+			ExecFunc: func(ctx context.Context, args []string) error {
 				internal.CryoUtils.InfoLog.Println("Starting swap file resize...")
 				size, err := strconv.Atoi(args[0])
 				if err != nil {
 					return err
 				}
-				err = internal.ChangeSwapSizeCLI(size, false)
+				err = internal.ChangeSwapSizeCLI(ctx, size, false)
 				if err != nil {
 					return err
 				}
@@ -206,11 +249,297 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name: "try",
+			Description: "Apply settings for N minutes, reverting them unless confirmed.\n\t" +
+				"Accepts 'recommended', 'stock' or param=value pairs, ex: try 5 hugepages=madvise swappiness=60",
+			ExecFunc: func(ctx context.Context, args []string) error {
+				if len(args) < 2 {
+					return errors.New("usage: try <minutes> <recommended|stock|param=value...>")
+				}
+				minutes, err := strconv.Atoi(args[0])
+				if err != nil || minutes <= 0 {
+					return errors.New("invalid number of minutes")
+				}
+				return internal.TrySettingsCLI(ctx, minutes, args[1:])
+			},
+		},
+		{
+			Name:        "profile",
+			Description: "Manage tuning profiles and the games that use them.",
+			Subcommands: []acmd.Command{
+				{
+					Name:        "list",
+					Description: "List all profiles and game assignments.",
+					ExecFunc: func(context.Context, []string) error {
+						return internal.ListProfilesCLI()
+					},
+				},
+				{
+					Name:        "save",
+					Description: "Save a profile, ex: profile save rdr2 swappiness=60 hugepages=madvise",
+					ExecFunc: func(_ context.Context, args []string) error {
+						if len(args) < 2 {
+							return errors.New("usage: profile save <name> <recommended|stock|param=value...>")
+						}
+						return internal.SaveProfileCLI(args[0], args[1:])
+					},
+				},
+				{
+					Name:        "delete",
+					Description: "Delete a profile.",
+					ExecFunc: func(_ context.Context, args []string) error {
+						if len(args) != 1 {
+							return errors.New("usage: profile delete <name>")
+						}
+						return internal.DeleteProfileCLI(args[0])
+					},
+				},
+				{
+					Name:        "game",
+					Description: "Use a profile while a game is running, ex: profile game 1174180 rdr2. Use 'none' to remove.",
+					ExecFunc: func(_ context.Context, args []string) error {
+						if len(args) != 2 {
+							return errors.New("usage: profile game <appid> <name|none>")
+						}
+						appID, err := strconv.Atoi(args[0])
+						if err != nil || appID <= 0 {
+							return errors.New("invalid AppID")
+						}
+						return internal.AssignGameProfileCLI(appID, args[1])
+					},
+				},
+			},
+		},
+		{
+			Name: "watch",
+			Description: "Run the watch daemon, applying game profiles while games are running.\n\t" +
+				"Flags: --interval <seconds>, --deprioritize-shaders to lower shader compiles while a game runs,\n\t" +
+				"--power to switch between the AC and battery profiles, --install or --uninstall the systemd user service.",
+			ExecFunc: func(ctx context.Context, args []string) error {
+				fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+				interval := fs.Int("interval", int(internal.DefaultWatchInterval/time.Second), "seconds between checks")
+				deprioritizeShaders := fs.Bool("deprioritize-shaders", false, "lower the priority of shader compiles while a game runs")
+				power := fs.Bool("power", false, "switch between the AC and battery profiles")
+				install := fs.Bool("install", false, "install and start the systemd user service")
+				uninstall := fs.Bool("uninstall", false, "stop and remove the systemd user service")
+				if err := fs.Parse(args); err != nil {
+					return err
+				}
+				if *uninstall {
+					return internal.UninstallWatchService()
+				}
+				if *install {
+					return internal.InstallWatchService(removeFlag(args, "install"))
+				}
+				if *interval <= 0 {
+					return errors.New("invalid interval")
+				}
+				return internal.Watch(ctx, internal.WatchOptions{
+					Interval:            time.Duration(*interval) * time.Second,
+					DeprioritizeShaders: *deprioritizeShaders,
+					Power:               *power,
+				})
+			},
+		},
+		{
+			Name: "power",
+			Description: "Show the power source and the profile 'watch --power' uses for it.\n\t" +
+				"'power config [key=value ...]' shows or changes the profiles, ex: 'power config battery_profile=stock battery_threshold=30'",
+			ExecFunc: func(ctx context.Context, args []string) error {
+				return internal.PowerCLI(args)
+			},
+		},
+		{
+			Name: "cgroup",
+			Description: "Show or change game memory protection, applied by the watch daemon. Accepts 'on', 'off' or key=value pairs.\n\t" +
+				"Ex: 'cgroup on game_low=6G game_min=1G background_high=2G'",
+			ExecFunc: func(_ context.Context, args []string) error {
+				return internal.CgroupCLI(args)
+			},
+		},
+		{
+			Name: "oomguard",
+			Description: "Kill the process with the highest oom_score before memory runs out and the system freezes.\n\t" +
+				"Flags: --interval <seconds>\n\t" +
+				"'oomguard config [key=value ...]' shows or changes the thresholds and protected AppIDs, ex: 'oomguard config protected=1174180'",
+			ExecFunc: func(ctx context.Context, args []string) error {
+				if len(args) > 0 && args[0] == "config" {
+					return internal.OOMGuardConfigCLI(args[1:])
+				}
+				fs := flag.NewFlagSet("oomguard", flag.ContinueOnError)
+				interval := fs.Int("interval", int(internal.DefaultOOMGuardInterval/time.Second), "seconds between checks")
+				if err := fs.Parse(args); err != nil {
+					return err
+				}
+				if *interval <= 0 {
+					return errors.New("invalid interval")
+				}
+				return internal.OOMGuardCLI(ctx, time.Duration(*interval)*time.Second)
+			},
+		},
+		{
+			Name: "monitor",
+			Description: "Print memory, IO and CPU pressure (PSI) as JSON lines until interrupted.\n\t" +
+				"Flags: --interval <seconds>",
+			ExecFunc: func(ctx context.Context, args []string) error {
+				fs := flag.NewFlagSet("monitor", flag.ContinueOnError)
+				interval := fs.Int("interval", 2, "seconds between samples")
+				if err := fs.Parse(args); err != nil {
+					return err
+				}
+				if *interval <= 0 {
+					return errors.New("invalid interval")
+				}
+				return internal.MonitorCLI(ctx, time.Duration(*interval)*time.Second)
+			},
+		},
+		{
+			Name:        "stats",
+			Description: "Show statistics about how the current settings are performing.",
+			Subcommands: []acmd.Command{
+				{
+					Name: "thp",
+					Description: "THP and compaction counters over a window. Accepts 'start', 'show', 'stop' or a number of seconds.\n\t" +
+						"Ex: 'stats thp start' before playing, 'stats thp stop' after.",
+					ExecFunc: func(ctx context.Context, args []string) error {
+						return internal.ThpStatsCLI(ctx, args)
+					},
+				},
+				{
+					Name: "swap",
+					Description: "GB written to swap this boot and per day, to estimate SSD wear. Accepts 'show' or 'sample'.\n\t" +
+						"Samples are recorded by 'watch' and while the GUI is open, 'sample' records one now.",
+					ExecFunc: func(_ context.Context, args []string) error {
+						return internal.SwapWriteStatsCLI(args)
+					},
+				},
+			},
+		},
+		{
+			Name: "top-swap",
+			Description: "List the processes using the most swap, grouped by game where possible.\n\t" +
+				"Flags: --count <groups to show, 0 for all>",
+			ExecFunc: func(_ context.Context, args []string) error {
+				fs := flag.NewFlagSet("top-swap", flag.ContinueOnError)
+				count := fs.Int("count", 10, "number of groups to show, 0 for all")
+				if err := fs.Parse(args); err != nil {
+					return err
+				}
+				return internal.TopSwapCLI(*count)
+			},
+		},
+		{
+			Name: "ksm",
+			Description: "Show kernel samepage merging savings, or change it. Accepts 'on', 'off' or 'tune'.\n\t" +
+				"Ex: 'ksm tune pages_to_scan=1000 sleep_millisecs=50'",
+			ExecFunc: func(_ context.Context, args []string) error {
+				return internal.KSMCLI(args)
+			},
+		},
+		{
+			Name: "cpu",
+			Description: "Show the CPU frequency settings, or change them on every policy.\n\t" +
+				"Accepts 'recommended', 'stock', 'governor <name>', 'preference <name>', 'min-freq <kHz>', 'max-freq <kHz>' or 'smt <on|off>'.",
+			ExecFunc: func(_ context.Context, args []string) error {
+				return internal.CPUCLI(args)
+			},
+		},
+		{
+			Name: "blockdev",
+			Description: "List block devices and their queue settings, or change one, kept across reboots and card swaps.\n\t" +
+				"Ex: 'blockdev mmcblk0 scheduler=bfq read_ahead_kb=512 nr_requests=64', 'blockdev mmcblk0 reset'",
+			ExecFunc: func(_ context.Context, args []string) error {
+				return internal.BlockDeviceCLI(args)
+			},
+		},
+		{
+			Name: "support-bundle",
+			Description: "Collect logs, settings and the system state into a redacted tar.gz for bug reports.\n\t" +
+				"Saved in the home directory unless a path is given. Ex: 'support-bundle', 'support-bundle /tmp/bundle.tar.gz'",
+			ExecFunc: func(_ context.Context, args []string) error {
+				return internal.SupportBundleCLI(args)
+			},
+		},
+		{
+			Name: "audit",
+			Description: "Show the audit log of everything done as root.\n\t" +
+				"Ex: 'audit show', 'audit show since=24h op=write path=swappiness caller=gui failed limit=20'",
+			ExecFunc: func(_ context.Context, args []string) error {
+				return internal.AuditCLI(args)
+			},
+		},
+		{
+			Name: "auth",
+			Description: "Show how CryoUtilities gets root: password, sudo and the privileged helper.\n\t" +
+				"Accepts 'sudoers install' (run with sudo) to start the helper without a password from then on, or 'sudoers remove'.",
+			ExecFunc: func(_ context.Context, args []string) error {
+				return internal.AuthCLI(args)
+			},
+		},
+		{
+			Name:        "compact",
+			Description: "Compact memory now, showing free huge page sized blocks before and after.",
+			ExecFunc: func(context.Context, []string) error {
+				return internal.CompactMemoryCLI()
+			},
+		},
+		{
+			Name:        "drop-caches",
+			Description: "Drop the page cache now, showing free huge page sized blocks before and after.",
+			ExecFunc: func(context.Context, []string) error {
+				return internal.DropCachesCLI()
+			},
+		},
+		{
+			Name: "prelaunch",
+			Description: "Compact memory, then launch a game. For Steam launch options of heavy titles.\n\t" +
+				"Flags: --drop-caches (also drop the page cache)\n\t" +
+				"Ex: 'cryoutilities prelaunch --drop-caches -- %command%'",
+			ExecFunc: func(_ context.Context, args []string) error {
+				fs := flag.NewFlagSet("prelaunch", flag.ContinueOnError)
+				dropCaches := fs.Bool("drop-caches", false, "also drop the page cache")
+				if err := fs.Parse(args); err != nil {
+					return err
+				}
+				return internal.PrelaunchCLI(*dropCaches, fs.Args())
+			},
+		},
+		{
+			Name:        "bench",
+			Description: "Benchmark how the current settings perform.",
+			Subcommands: []acmd.Command{
+				{
+					Name: "memory",
+					Description: "Touch a working set larger than free RAM and report page fault latency, PSI stalls and swap throughput as JSON.\n\t" +
+						"Flags: --size <GB, 0 for a little more than available>, --passes <count>, --profile <name>, --output <path>",
+					ExecFunc: func(ctx context.Context, args []string) error {
+						fs := flag.NewFlagSet("bench memory", flag.ContinueOnError)
+						size := fs.Int("size", 0, "working set in GB, 0 for a little more than available memory")
+						passes := fs.Int("passes", internal.DefaultBenchPasses, "times to touch the working set")
+						profile := fs.String("profile", "", "profile to apply while benchmarking")
+						output := fs.String("output", "", "where to write the JSON report")
+						if err := fs.Parse(args); err != nil {
+							return err
+						}
+						if *size < 0 || *passes <= 0 {
+							return errors.New("invalid size or passes")
+						}
+						return internal.MemoryBenchCLI(ctx, internal.BenchOptions{
+							WorkingSet: int64(*size) * int64(internal.GigabyteMultiplier),
+							Passes:     *passes,
+							Profile:    *profile,
+							Output:     *output,
+						})
+					},
+				},
+			},
+		},
 		{
 			Name:        "recommended",
 			Description: "Set all values to Cryo's recommendations.",
-			ExecFunc: func(context.Context, []string) error {
-				err := internal.UseRecommendedSettings()
+			ExecFunc: func(ctx context.Context, _ []string) error {
+				err := internal.UseRecommendedSettings(ctx)
 				if err != nil {
 					return err
 				}
@@ -220,8 +549,8 @@ func main() {
 		{
 			Name:        "stock",
 			Description: "Set all values to Valve defaults.",
-			ExecFunc: func(context.Context, []string) error {
-				err := internal.UseStockSettings()
+			ExecFunc: func(ctx context.Context, _ []string) error {
+				err := internal.UseStockSettings(ctx)
 				if err != nil {
 					return err
 				}
@@ -237,15 +566,78 @@ func main() {
 
 	// Basic program metadata
 	r := acmd.RunnerOf(cmds, acmd.Config{
-		AppName:         "cryoutilities",
-		AppDescription:  "CryoByte33's Steam Deck utility script.",
-		PostDescription: "NOTE: You NEED to run this with sudo if not using GUI mode.",
-		Version:         internal.CurrentVersionNumber,
+		AppName:        "cryoutilities",
+		AppDescription: "CryoByte33's Steam Deck utility script.",
+		PostDescription: "NOTE: Changing settings needs root. Run this as root, or it will use the privileged helper if it's running, or else sudo, doas or run0.\n" +
+			"Logging flags work with any command: --log-level <debug|info|warn|error>, --verbose (-v) to also log everything to the terminal, --journal to also log to the systemd journal.\n" +
+			"Only one swap, settings or game data change runs at a time. --wait waits for a running one to finish instead of failing, --wait=<duration> (ex: 5m) only that long.",
+		Version: internal.CurrentVersionNumber,
 	})
 
 	// Run the command parser
 	if err := r.Run(); err != nil {
 		internal.CryoUtils.ErrorLog.Println(err)
+		// What the failed command printed, readable on the terminal and kept in the log.
+		if details := internal.ErrorDetails(err); details != "" {
+			fmt.Fprint(os.Stderr, details)
+			internal.CryoUtils.Log.Info("命令失败的详细信息", "details", details)
+		}
 		os.Exit(1)
 	}
 }
+
+// Remove a boolean flag from an argument list, so the rest can be passed on.
+func removeFlag(args []string, name string) []string {
+	var kept []string
+	for _, arg := range args {
+		if arg != "-"+name && arg != "--"+name {
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
+
+// Run the hidden benchmark worker, reporting errors on stderr for the parent to log.
+func runBenchWorker(args []string) int {
+	fs := flag.NewFlagSet(internal.BenchWorkerCommand, flag.ContinueOnError)
+	size := fs.Int64("size", 0, "working set in bytes")
+	passes := fs.Int("passes", internal.DefaultBenchPasses, "times to touch the working set")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := internal.RunBenchWorker(*size, *passes, os.Stdout); err != nil {
+		log.New(os.Stderr, "", 0).Println(err)
+		return 1
+	}
+	return 0
+}
+
+// Run the privileged helper, normally started by the GUI with sudo. Run it by hand, ex:
+// 'sudo cryoutilities helper &', to let CLI commands skip sudo.
+func runHelper(args []string) int {
+	fs := flag.NewFlagSet(internal.HelperCommand, flag.ContinueOnError)
+	// sudo says who ran it, so running it by hand needs no flags.
+	defaultUID, err := strconv.Atoi(os.Getenv("SUDO_UID"))
+	if err != nil {
+		defaultUID = -1
+	}
+	uid := fs.Int("uid", defaultUID, "the user allowed to connect")
+	parent := fs.Int("parent", 0, "exit when this process does, 0 to run until stopped")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	logger, err := internal.NewLogger("", internal.LogOptions{Level: internal.LevelInfo, Stderr: true, Verbose: true})
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	internal.UseLogger(logger)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := internal.RunHelper(ctx, *uid, *parent); err != nil {
+		internal.CryoUtils.ErrorLog.Println(err)
+		return 1
+	}
+	return 0
+}
@@ -20,6 +20,7 @@ import (
 	"image/color"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 func init() {
@@ -36,8 +37,40 @@ var HomeDirectory, _ = os.UserHomeDir()
 // InstallDirectory Location the program is installed.
 var InstallDirectory = filepath.Join(HomeDirectory, ".cryo_utilities")
 
+// SharedDirectory Where the files the GUI, the CLI under sudo, the privileged helper and the watch daemon all write
+// live. sudo resets HOME to /root, so this is the install directory of the user who ran sudo, and the files root
+// creates there are handed to that user so the others can keep writing them.
+var SharedDirectory = filepath.Join(getUserHomeDirectory(), ".cryo_utilities")
+
 // LogFilePath Location of the log file
-var LogFilePath = filepath.Join(InstallDirectory, "cryoutilities.log")
+var LogFilePath = filepath.Join(SharedDirectory, "cryoutilities.log")
+
+// WatchLogFilePath Location of the watch daemon's log, it runs alongside the GUI and the CLI so it can't share theirs
+var WatchLogFilePath = filepath.Join(SharedDirectory, "watch.log")
+
+// LogMaxSize How big the log file can get before it's rotated, in bytes
+var LogMaxSize int64 = 2 * 1024 * 1024
+
+// LogBackups How many previous logs to keep, as cryoutilities.log.1 (newest) and up. Every run starts a new one.
+var LogBackups = 5
+
+// JournalSocketPath Where the systemd journal accepts native log messages
+var JournalSocketPath = "/run/systemd/journal/socket"
+
+// SupportBundleDirectory Where support bundles are saved when no path is given
+var SupportBundleDirectory = HomeDirectory
+
+// SettingsFilePath Location of the user's saved profiles and other choices
+var SettingsFilePath = filepath.Join(SharedDirectory, "settings.json")
+
+// ProcRoot Where procfs is mounted, only changed for testing
+var ProcRoot = "/proc"
+
+// SysRoot Where sysfs is mounted, only changed for testing
+var SysRoot = "/sys"
+
+// CgroupRoot Where the cgroup v2 hierarchy is mounted, only changed for testing
+var CgroupRoot = "/sys/fs/cgroup"
 
 //////////////////////////
 // Recommended Settings //
@@ -51,6 +84,8 @@ var RecommendedCompactionProactiveness = "0"
 var RecommendedHugePageDefrag = "0"
 var RecommendedPageLockUnfairness = "1"
 var RecommendedShMem = "advise"
+var RecommendedCPUGovernor = "schedutil"
+var RecommendedCPUPreference = "performance"
 var RecommendedVRAM = 4096
 
 //////////////////////
@@ -66,6 +101,12 @@ var DefaultCompactionProactiveness = "20"
 var DefaultHugePageDefrag = "1"
 var DefaultPageLockUnfairness = "5"
 var DefaultShMem = "never"
+var DefaultKSMRun = "0"
+var DefaultKSMPagesToScan = "100"
+var DefaultKSMSleepMillisecs = "20"
+var DefaultCPUGovernor = "schedutil"
+var DefaultCPUPreference = "balance_performance"
+var DefaultSMT = "on"
 
 ////////////////
 // Unit Files //
@@ -82,10 +123,217 @@ var UnitMatrix = map[string]string{
 	"hugepages":                "/sys/kernel/mm/transparent_hugepage/enabled",
 	"shmem_enabled":            "/sys/kernel/mm/transparent_hugepage/shmem_enabled",
 	"defrag":                   "/sys/kernel/mm/transparent_hugepage/khugepaged/defrag",
+	"ksm_run":                  "/sys/kernel/mm/ksm/run",
+	"ksm_pages_to_scan":        "/sys/kernel/mm/ksm/pages_to_scan",
+	"ksm_sleep_millisecs":      "/sys/kernel/mm/ksm/sleep_millisecs",
 }
 
+// CompactMemoryPath Writing 1 compacts all zones now
+var CompactMemoryPath = "/proc/sys/vm/compact_memory"
+
+// DropCachesPath Writing 1 drops the page cache, 2 reclaimable slab objects and 3 both
+var DropCachesPath = "/proc/sys/vm/drop_caches"
+
+// DropCachesValue Only drop the page cache, dentries and inodes are cheap and slow to rebuild
+var DropCachesValue = "1"
+
+// HugePageOrder The buddy allocator order of a 2MB huge page, with 4KB pages
+var HugePageOrder = 9
+
 var OldSwappinessUnitFile = "/etc/sysctl.d/zzz-custom-swappiness.conf"
-var NHPTestingFile = "/proc/sys/vm/nr_hugepages"
+
+///////////////////
+// CPU Frequency //
+///////////////////
+
+// CPUFreqDirectory Where each cpufreq policy lives, relative to SysRoot
+var CPUFreqDirectory = "devices/system/cpu/cpufreq"
+
+// SMTControlPath Turns SMT on or off, relative to SysRoot
+var SMTControlPath = "devices/system/cpu/smt/control"
+
+/////////////////////////
+// Block Device Queues //
+/////////////////////////
+
+// BlockDeviceRulesPath The generated udev rules that reapply queue settings whenever a device appears
+var BlockDeviceRulesPath = "/etc/udev/rules.d/60-cryoutilities-blockdev.rules"
+
+// BlockDeviceSkipPrefixes Virtual devices in /sys/block that have nothing worth tuning
+var BlockDeviceSkipPrefixes = []string{"loop", "ram", "zram", "dm-", "md", "sr", "nbd"}
+
+// AvailableReadAheadKB Read-ahead sizes offered in the UI, in kB
+var AvailableReadAheadKB = []string{"128", "256", "512", "1024", "2048", "4096"}
+
+// AvailableNrRequests Queue depths offered in the UI
+var AvailableNrRequests = []string{"32", "64", "128", "256", "512", "1024"}
+
+////////////////////
+// THP Statistics //
+////////////////////
+
+// ThpSnapshotPath Location of the vmstat snapshot taken when THP statistics recording starts
+var ThpSnapshotPath = filepath.Join(SharedDirectory, "thp_snapshot.json")
+
+// ThpFallbackWarningRatio The share of THP faults or compactions failing before it's worth a warning
+var ThpFallbackWarningRatio = 0.5
+
+// CompactStallWarningPerMinute How many direct compaction stalls per minute before it's worth a warning
+var CompactStallWarningPerMinute = 10.0
+
+// MajorFaultWarningPerMinute How many major page faults per minute before it's worth a warning
+var MajorFaultWarningPerMinute = 1000.0
+
+/////////////////////
+// Process Listing //
+/////////////////////
+
+// ShaderProcessName The name of Steam's shader compiler, as cut to 15 characters by the kernel
+var ShaderProcessName = "fossilize_repla"
+
+/////////////////
+// Swap Writes //
+/////////////////
+
+// SwapWriteHistoryPath Location of the swap write samples, one JSON object per line
+var SwapWriteHistoryPath = filepath.Join(SharedDirectory, "swap_writes.jsonl")
+
+// SwapWriteSampleInterval How often the swap write counters are saved
+var SwapWriteSampleInterval = 10 * time.Minute
+
+// SwapWriteHistoryLength How long swap write samples are kept
+var SwapWriteHistoryLength = 30 * 24 * time.Hour
+
+// SwapWriteMinimumSpan How much history is needed before giving a per-day estimate
+var SwapWriteMinimumSpan = time.Hour
+
+// SwapWriteWarningGBPerDay How many GB written to swap per day before it's worth a warning
+var SwapWriteWarningGBPerDay = 20.0
+
+////////////////////////////
+// Game Memory Protection //
+////////////////////////////
+
+// CgroupSliceName The cgroup created under the user's systemd instance, holding the game and background groups
+var CgroupSliceName = "cryoutilities.slice"
+
+// CgroupGameName The cgroup the running game is moved into
+var CgroupGameName = "game"
+
+// CgroupBackgroundName The cgroup Steam's background processes are moved into while a game runs
+var CgroupBackgroundName = "background"
+
+// CgroupBackgroundProcesses Processes limited while a game runs
+var CgroupBackgroundProcesses = []string{"steam", "steamwebhelper"}
+
+// DefaultCgroupGameLow Memory the game keeps unless nothing else can be reclaimed
+var DefaultCgroupGameLow = "6G"
+
+// DefaultCgroupGameMin Memory the game always keeps
+var DefaultCgroupGameMin = "1G"
+
+// DefaultCgroupBackgroundHigh Memory the background processes are throttled and reclaimed above
+var DefaultCgroupBackgroundHigh = "2G"
+
+///////////////////////////
+// Shader Compile Limits //
+///////////////////////////
+
+// ShaderCgroupName The cgroup shader compiles are moved into while a game is running, under the user's systemd instance
+var ShaderCgroupName = "cryoutilities-shaders"
+
+// ShaderCPUWeight The cpu.weight of shader compiles while a game is running, everything else has the default 100
+var ShaderCPUWeight = 1
+
+// ShaderCPUs How many CPUs shader compiles are confined to while a game is running, taken from the end
+var ShaderCPUs = 1
+
+///////////////////////////
+// Power Source Profiles //
+///////////////////////////
+
+// DefaultPowerACProfile The profile used while plugged in
+var DefaultPowerACProfile = "recommended"
+
+// DefaultPowerBatteryProfile The profile used while running on battery
+var DefaultPowerBatteryProfile = "stock"
+
+// DefaultPowerHysteresis How many percent the battery must charge above the threshold before switching back
+var DefaultPowerHysteresis = 5
+
+// DefaultPowerDelaySeconds How long a new power source must last before switching, so docking doesn't flap
+var DefaultPowerDelaySeconds = 10
+
+///////////////
+// OOM Guard //
+///////////////
+
+// DefaultOOMGuardInterval How often the OOM guard checks memory
+var DefaultOOMGuardInterval = time.Second
+
+// DefaultOOMGuardFullAvg10 Percentage of time every task was stalled on memory, over 10 seconds, that counts as a freeze
+var DefaultOOMGuardFullAvg10 = 40.0
+
+// DefaultOOMGuardMinAvailablePercent MemAvailable below this percentage of RAM counts as running out
+var DefaultOOMGuardMinAvailablePercent = 3.0
+
+// DefaultOOMGuardMinSwapFreePercent SwapFree below this percentage of swap counts as running out
+var DefaultOOMGuardMinSwapFreePercent = 5.0
+
+// DefaultOOMGuardGraceSeconds How long after the warning the victim is killed, if memory is still short
+var DefaultOOMGuardGraceSeconds = 10
+
+// OOMGuardNeverKill Processes the session can't survive without, never chosen as a victim. Names are cut to 15 characters by the kernel
+var OOMGuardNeverKill = []string{"systemd", "gamescope", "gamescope-sessi", "gamescope-wl", "steam", "steamwebhelper",
+	"kwin_wayland", "kwin_x11", "Xwayland", "plasmashell", "pipewire", "wireplumber"}
+
+///////////////
+// Benchmark //
+///////////////
+
+// BenchReportDirectory Where benchmark reports are saved
+var BenchReportDirectory = filepath.Join(SharedDirectory, "bench")
+
+// BenchWorkerCommand The hidden command the benchmark runs itself as, to touch memory in a separate process
+var BenchWorkerCommand = "bench-worker"
+
+// BenchDefaultOverflow How far past available memory the default working set goes, in GB
+var BenchDefaultOverflow = 2
+
+// BenchSafetyMargin Memory and swap left untouched by the benchmark, so it can't trigger the OOM killer
+var BenchSafetyMargin = int64(1 * GigabyteMultiplier)
+
+// DefaultBenchPasses How many times the working set is touched, the first pass only allocates
+var DefaultBenchPasses = 3
+
+// AvailableBenchWorkingSets A list of benchmark working set sizes to choose from, in GB
+var AvailableBenchWorkingSets = []string{"1", "2", "4", "6", "8", "10", "12", "14", "16", "20"}
+
+/////////
+// KSM //
+/////////
+
+// AvailableKSMPagesToScan A list of how many pages KSM scans per wake-up to choose from
+var AvailableKSMPagesToScan = []string{"100", "500", "1000", "2000", "5000"}
+
+// AvailableKSMSleepMillisecs A list of how long KSM sleeps between scans to choose from, in milliseconds
+var AvailableKSMSleepMillisecs = []string{"20", "50", "100", "200", "500"}
+
+////////////////
+// Safe Apply //
+////////////////
+
+// SafeApplyStatePath Location of a pending trial change, used to revert it if CryoUtilities dies before it's confirmed
+var SafeApplyStatePath = filepath.Join(SharedDirectory, "safe_apply.json")
+
+// BootIDPath Changes on every boot, used to tell whether a trial change survived a reboot
+var BootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// DefaultSafeApplyMinutes How long a trial change stays live without confirmation
+var DefaultSafeApplyMinutes = 5
+
+// AvailableSafeApplyMinutes A list of trial lengths to choose from, in minutes
+var AvailableSafeApplyMinutes = []string{"1", "2", "5", "10", "15"}
 
 /////////////////
 // UI Settings //
@@ -109,6 +357,15 @@ var Red = color.RGBA{R: 255, G: 0, B: 0, A: 255}
 // White UI Color
 var White = color.RGBA{R: 255, G: 255, B: 255, A: 255}
 
+// PressureRefreshInterval How often the memory pressure panel updates
+var PressureRefreshInterval = 2 * time.Second
+
+// PressureGraphSamples How many samples the memory pressure graph shows
+var PressureGraphSamples = 90
+
+// MemoryUsageRefreshInterval How often the memory usage breakdown updates
+var MemoryUsageRefreshInterval = 2 * time.Second
+
 //////////////////////////////////
 // Swap and swappiness settings //
 //////////////////////////////////
@@ -159,3 +416,90 @@ var SteamApiUrl = "https://api.steampowered.com/ISteamApps/GetAppList/v0002/"
 // SteamGameMaxInteger Anything over this number is presumed to be a Proton version
 // Prevents accidental removal of Proton files
 var SteamGameMaxInteger = 1000000000
+
+//////////////////
+// Watch Daemon //
+//////////////////
+
+// DefaultWatchInterval How often the watch daemon checks for changes
+var DefaultWatchInterval = 5 * time.Second
+
+// SystemdUserDirectory Where systemd looks for the user's own services
+var SystemdUserDirectory = filepath.Join(HomeDirectory, ".config/systemd/user")
+
+// WatchServiceName The name of the watch daemon's systemd user service
+var WatchServiceName = "cryoutilities-watch.service"
+
+// WatchServiceTemplate The watch daemon's systemd user service, EXEC_START is replaced on install
+var WatchServiceTemplate = "[Unit]\nDescription=CryoUtilities watch daemon\n\n" +
+	"[Service]\nExecStart=EXEC_START\nRestart=on-failure\n\n" +
+	"[Install]\nWantedBy=default.target\n"
+
+///////////////////////
+// Privileged Helper //
+///////////////////////
+
+// HelperCommand The hidden command that runs the privileged helper, started with sudo
+var HelperCommand = "helper"
+
+// HelperSocketPath Where the privileged helper listens, in a root-owned directory
+var HelperSocketPath = "/run/cryoutilities/helper.sock"
+
+// HelperLogPath Where the privileged helper's output goes, it can't share the main log
+var HelperLogPath = filepath.Join(SharedDirectory, "helper.log")
+
+// HelperStartTimeout How long to wait for the privileged helper to start listening
+var HelperStartTimeout = 10 * time.Second
+
+// HelperLegacyFiles Files left by older versions that the helper may remove
+var HelperLegacyFiles = []string{OldSwappinessUnitFile}
+
+// PrivilegeCommands Commands tried, in order, to run privileged operations when not root
+var PrivilegeCommands = []string{"sudo", "doas", "run0"}
+
+// PrivilegeCommandStopDelay How long a cancelled command run through sudo gets to exit before sudo is killed
+var PrivilegeCommandStopDelay = 10 * time.Second
+
+////////////////////
+// Authentication //
+////////////////////
+
+// SudoersDropInPath Lets the user start the privileged helper without a password, sorted last so it wins
+var SudoersDropInPath = "/etc/sudoers.d/zz-cryoutilities"
+
+// HelperInstallPath A root-owned copy of CryoUtilities for the sudoers drop-in to point at, so nothing running as
+// the user can change what it runs. /usr is read-only on SteamOS and replaced by updates, /var is kept.
+var HelperInstallPath = "/var/lib/cryoutilities/cryo_utilities"
+
+// MinPasswordLength The shortest password the set password dialog accepts
+var MinPasswordLength = 4
+
+///////////////
+// Audit Log //
+///////////////
+
+// AuditLogPath Location of the audit log, one JSON object per privileged operation, never cleared on start
+var AuditLogPath = filepath.Join(SharedDirectory, "audit.jsonl")
+
+// AuditLogMaxSize How big the audit log can get before it's rotated, in bytes
+var AuditLogMaxSize int64 = 1024 * 1024
+
+// AuditLogBackups How many rotated audit logs to keep, as audit.jsonl.1 (newest) and up
+var AuditLogBackups = 3
+
+///////////
+// Locks //
+///////////
+
+// LockDirectory Where the per-subsystem lock files live, shared by the GUI, the CLI and the watch daemon
+var LockDirectory = SharedDirectory
+
+// LockPollInterval How often a busy lock is retried while waiting for it
+var LockPollInterval = 500 * time.Millisecond
+
+/////////////////////
+// Error Reporting //
+/////////////////////
+
+// CommandErrorTailLines How many of the last lines a failed command printed are kept with its error
+var CommandErrorTailLines = 20
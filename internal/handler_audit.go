@@ -0,0 +1,379 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// Only one entry is written, and the log rotated, at a time.
+var auditMutex sync.Mutex
+
+// AuditEntry One privileged operation, as written to the audit log.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Caller What asked for it, "gui" or "cli <command>".
+	Caller string `json:"caller"`
+	PID    int    `json:"pid"`
+	// Executor How root was reached, ex: "root", "sudo" or "helper".
+	Executor string   `json:"executor"`
+	Op       string   `json:"op"`
+	Path     string   `json:"path,omitempty"`
+	Args     []string `json:"args,omitempty"`
+	// Before and After SHA-256 of the file around a write or removal, empty when it didn't exist
+	// or couldn't be read.
+	Before   string `json:"before,omitempty"`
+	After    string `json:"after,omitempty"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// AuditExecutor Records everything another executor changes in the audit log. Reads aren't recorded.
+type AuditExecutor struct {
+	Next Executor
+	// Caller and PID Who asked when it wasn't this process, ex: a client of the privileged helper.
+	Caller string
+	PID    int
+}
+
+func (e AuditExecutor) ReadFile(path string) ([]byte, error) {
+	return e.Next.ReadFile(path)
+}
+
+func (e AuditExecutor) WriteFile(path string, data []byte) error {
+	entry := e.newEntry("write")
+	entry.Path = path
+	entry.Before = e.hashTarget(path)
+	err := e.Next.WriteFile(path, data)
+	entry.After = e.hashTarget(path)
+	e.record(entry, err)
+	return err
+}
+
+func (e AuditExecutor) RemoveFile(path string) error {
+	entry := e.newEntry("remove")
+	entry.Path = path
+	entry.Before = e.hashTarget(path)
+	err := e.Next.RemoveFile(path)
+	entry.After = e.hashTarget(path)
+	e.record(entry, err)
+	return err
+}
+
+func (e AuditExecutor) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	entry := e.newEntry("run")
+	entry.Args = append([]string{name}, args...)
+	output, err := e.Next.Run(ctx, name, args...)
+	e.record(entry, err)
+	return output, err
+}
+
+func (e AuditExecutor) newEntry(op string) AuditEntry {
+	entry := AuditEntry{
+		Time:     time.Now(),
+		Caller:   CryoUtils.Caller,
+		PID:      os.Getpid(),
+		Executor: getExecutorName(e.Next),
+		Op:       op,
+	}
+	if e.PID != 0 {
+		entry.Caller, entry.PID, entry.Executor = e.Caller, e.PID, "helper"
+	}
+	return entry
+}
+
+// Get the SHA-256 of a file as the next executor reads it, most of what's audited only root can read.
+// "" if it doesn't exist or can't be read.
+func (e AuditExecutor) hashTarget(path string) string {
+	data, err := e.Next.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return hashData(data)
+}
+
+// Finish an entry with the result and write it. Failing to audit never fails the operation.
+func (e AuditExecutor) record(entry AuditEntry, err error) {
+	entry.ExitCode = getExitCode(err)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	CryoUtils.Log.Debug("特权操作", "op", entry.Op, "path", entry.Path, "args", strings.Join(entry.Args, " "),
+		"executor", entry.Executor, "exit_code", entry.ExitCode)
+	err = writeAuditEntry(entry)
+	if err != nil {
+		CryoUtils.ErrorLog.Println("无法写入审计日志:", err)
+	}
+}
+
+// Get a short name for how an executor reaches root.
+func getExecutorName(e Executor) string {
+	switch e := e.(type) {
+	case RootExecutor:
+		return "root"
+	case CommandExecutor:
+		return filepath.Base(e.Command[0])
+	case HelperExecutor:
+		return "helper"
+	case *FakeExecutor:
+		return "fake"
+	}
+	return fmt.Sprintf("%T", e)
+}
+
+// Get the exit code of a failed command, 0 on success and -1 when there's no exit code to give.
+func getExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	// Also covers commands the privileged helper ran, whose exit code came back without the process.
+	var commandErr *CommandError
+	if errors.As(err, &commandErr) && commandErr.ExitCode != -1 {
+		return commandErr.ExitCode
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// Get the SHA-256 of a file, or "" if it doesn't exist or can't be read.
+func hashFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return hashData(data)
+}
+
+func hashData(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Append an entry to the audit log, rotating it first if it's full.
+func writeAuditEntry(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(AuditLogPath), 0755)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(AuditLogPath)
+	if err == nil && info.Size()+int64(len(data)) >= AuditLogMaxSize {
+		err = rotateLogFiles(AuditLogPath, AuditLogBackups)
+		if err != nil {
+			return err
+		}
+	}
+	file, err := openSharedFile(AuditLogPath, os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// Load every entry in the audit log and its backups, oldest first.
+func loadAuditEntries() ([]AuditEntry, error) {
+	var entries []AuditEntry
+	for i := AuditLogBackups; i >= 0; i-- {
+		path := AuditLogPath
+		if i > 0 {
+			path = AuditLogPath + "." + strconv.Itoa(i)
+		}
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var entry AuditEntry
+			// Skip damaged lines rather than losing the whole log.
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+				entries = append(entries, entry)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// AuditFilter Which audit entries to show, zero values match everything.
+type AuditFilter struct {
+	Since  time.Time
+	Op     string
+	Path   string
+	Caller string
+	Failed bool
+	Limit  int
+}
+
+// Parse 'key=value' filters from the CLI. since takes a duration (24h) or a date (2006-01-02).
+func parseAuditFilter(args []string, now time.Time) (AuditFilter, error) {
+	var filter AuditFilter
+	for _, arg := range args {
+		if arg == "failed" {
+			filter.Failed = true
+			continue
+		}
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			return filter, fmt.Errorf("无效的参数: %s", arg)
+		}
+		switch key {
+		case "since":
+			duration, err := time.ParseDuration(value)
+			if err == nil {
+				filter.Since = now.Add(-duration)
+				break
+			}
+			filter.Since, err = time.ParseInLocation("2006-01-02", value, time.Local)
+			if err != nil {
+				return filter, fmt.Errorf("无效的时间: %s", value)
+			}
+		case "op":
+			filter.Op = value
+		case "path":
+			filter.Path = value
+		case "caller":
+			filter.Caller = value
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				return filter, fmt.Errorf("无效的数量: %s", value)
+			}
+			filter.Limit = limit
+		default:
+			return filter, fmt.Errorf("无效的参数: %s", arg)
+		}
+	}
+	return filter, nil
+}
+
+// Check whether an entry matches the filter. path matches the path or any command argument.
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if f.Op != "" && entry.Op != f.Op {
+		return false
+	}
+	if f.Caller != "" && !strings.HasPrefix(entry.Caller, f.Caller) {
+		return false
+	}
+	if f.Failed && entry.ExitCode == 0 && entry.Error == "" {
+		return false
+	}
+	if f.Path != "" && !strings.Contains(entry.Path, f.Path) &&
+		!strings.Contains(strings.Join(entry.Args, " "), f.Path) {
+		return false
+	}
+	return true
+}
+
+// Get the entries matching the filter, keeping only the newest Limit of them.
+func filterAuditEntries(entries []AuditEntry, filter AuditFilter) []AuditEntry {
+	var matched []AuditEntry
+	for _, entry := range entries {
+		if filter.matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[len(matched)-filter.Limit:]
+	}
+	return matched
+}
+
+// Return a single human-readable line for an entry.
+func (e AuditEntry) String() string {
+	short := func(hash string) string {
+		if len(hash) < 12 {
+			return "-"
+		}
+		return hash[:12]
+	}
+	target := e.Path
+	if e.Op == "run" {
+		target = strings.Join(e.Args, " ")
+	}
+	line := fmt.Sprintf("%s  %-12s %-6s %-6s %s", e.Time.Local().Format("2006-01-02 15:04:05"),
+		e.Caller, e.Executor, e.Op, target)
+	if e.Op != "run" {
+		line += fmt.Sprintf("  %s -> %s", short(e.Before), short(e.After))
+	}
+	if e.ExitCode != 0 || e.Error != "" {
+		line += fmt.Sprintf("  失败 (%d): %s", e.ExitCode, e.Error)
+	}
+	return line
+}
+
+// AuditCLI Show the audit log, filtered by 'since=', 'op=', 'path=', 'caller=', 'limit=' and 'failed'.
+func AuditCLI(args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		return fmt.Errorf("用法: audit show [since=24h] [op=write|remove|run] [path=...] [caller=gui|cli] [failed] [limit=N]")
+	}
+	filter, err := parseAuditFilter(args[1:], time.Now())
+	if err != nil {
+		return err
+	}
+	entries, err := loadAuditEntries()
+	if err != nil {
+		return err
+	}
+	entries = filterAuditEntries(entries, filter)
+	if len(entries) == 0 {
+		fmt.Println("没有匹配的审计记录")
+		return nil
+	}
+	for _, entry := range entries {
+		fmt.Println(entry)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAuditExecutor(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, oldCaller := AuditLogPath, CryoUtils.Caller
+	AuditLogPath = filepath.Join(dir, "audit.jsonl")
+	CryoUtils.Caller = "cli swappiness"
+	t.Cleanup(func() { AuditLogPath, CryoUtils.Caller = oldPath, oldCaller })
+
+	target := filepath.Join(dir, "swappiness.conf")
+	e := AuditExecutor{Next: RootExecutor{}}
+	if err := e.WriteFile(target, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RemoveFile(target); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = e.Run(context.Background(), "false")
+
+	entries, err := loadAuditEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	hash := "6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b"
+	write, remove, run := entries[0], entries[1], entries[2]
+	if write.Op != "write" || write.Path != target || write.Before != "" || write.After != hash ||
+		write.Caller != "cli swappiness" || write.Executor != "root" || write.ExitCode != 0 {
+		t.Errorf("write entry = %+v", write)
+	}
+	if remove.Op != "remove" || remove.Before != hash || remove.After != "" {
+		t.Errorf("remove entry = %+v", remove)
+	}
+	if _, err = exec.LookPath("false"); err == nil &&
+		(!reflect.DeepEqual(run.Args, []string{"false"}) || run.ExitCode != 1 || run.Error == "") {
+		t.Errorf("run entry = %+v", run)
+	}
+}
+
+func TestRotateAuditLog(t *testing.T) {
+	oldPath, oldSize, oldBackups := AuditLogPath, AuditLogMaxSize, AuditLogBackups
+	AuditLogPath = filepath.Join(t.TempDir(), "audit.jsonl")
+	AuditLogMaxSize, AuditLogBackups = 300, 2
+	t.Cleanup(func() { AuditLogPath, AuditLogMaxSize, AuditLogBackups = oldPath, oldSize, oldBackups })
+
+	for i := 0; i < 10; i++ {
+		err := writeAuditEntry(AuditEntry{Time: time.Unix(int64(i), 0), Op: "run", Args: []string{"swapoff", "-a"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(AuditLogPath + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("more backups kept than AuditLogBackups")
+	}
+	entries, err := loadAuditEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || len(entries) == 10 || entries[len(entries)-1].Time.Unix() != 9 {
+		t.Errorf("after rotation got %d entries, want the newest few ending with the last", len(entries))
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Time.Before(entries[i-1].Time) {
+			t.Errorf("entries out of order at %d", i)
+		}
+	}
+}
+
+func TestAuditFilter(t *testing.T) {
+	now := time.Date(2023, 5, 2, 12, 0, 0, 0, time.Local)
+	entries := []AuditEntry{
+		{Time: now.Add(-48 * time.Hour), Caller: "gui", Op: "write", Path: UnitMatrix["swappiness"]},
+		{Time: now.Add(-2 * time.Hour), Caller: "cli swap", Op: "run", Args: []string{"swapon", "/home/swapfile"}},
+		{Time: now.Add(-time.Hour), Caller: "gui", Op: "write", Path: UnitMatrix["hugepages"], ExitCode: 1, Error: "denied"},
+		{Time: now, Caller: "cli stock", Op: "remove", Path: "/etc/tmpfiles.d/swappiness.conf"},
+	}
+	tests := []struct {
+		args []string
+		want []int
+	}{
+		{nil, []int{0, 1, 2, 3}},
+		{[]string{"since=24h"}, []int{1, 2, 3}},
+		{[]string{"since=2023-05-02"}, []int{1, 2, 3}},
+		{[]string{"op=write"}, []int{0, 2}},
+		{[]string{"path=swap"}, []int{0, 1, 3}},
+		{[]string{"caller=cli"}, []int{1, 3}},
+		{[]string{"failed"}, []int{2}},
+		{[]string{"limit=2"}, []int{2, 3}},
+	}
+	for _, tt := range tests {
+		filter, err := parseAuditFilter(tt.args, now)
+		if err != nil {
+			t.Errorf("parseAuditFilter(%v) error = %v", tt.args, err)
+			continue
+		}
+		var want []AuditEntry
+		for _, i := range tt.want {
+			want = append(want, entries[i])
+		}
+		if got := filterAuditEntries(entries, filter); !reflect.DeepEqual(got, want) {
+			t.Errorf("filter %v = %v, want %v", tt.args, got, want)
+		}
+	}
+	for _, args := range [][]string{{"since=yesterday"}, {"limit=-1"}, {"colour=red"}, {"swap"}} {
+		if _, err := parseAuditFilter(args, now); err == nil {
+			t.Errorf("parseAuditFilter(%v) should fail", args)
+		}
+	}
+}
+
+func TestWriteAuditEntryUnderSudo(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("only root hands files over")
+	}
+	oldPath := AuditLogPath
+	AuditLogPath = filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Cleanup(func() { AuditLogPath = oldPath })
+	t.Setenv("SUDO_UID", "65534")
+
+	// Created by root under sudo, it must stay writable for the user who ran sudo.
+	if err := writeAuditEntry(AuditEntry{Time: time.Now(), Op: "run"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(AuditLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := info.Sys().(*syscall.Stat_t).Uid; uid != 65534 {
+		t.Errorf("audit log owned by uid %d, want 65534", uid)
+	}
+}
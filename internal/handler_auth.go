@@ -0,0 +1,276 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// Anything sudoers would read as more than a plain word or path.
+var sudoersSafeRegex = regexp.MustCompile(`^[A-Za-z0-9/._-]+$`)
+
+// AuthState How the current user can get root, so the GUI knows what to ask for.
+type AuthState struct {
+	Root          bool
+	HelperRunning bool
+	// PasswordSet Whether the account has a password, SteamOS ships without one.
+	PasswordSet bool
+	// PasswordLocked Whether the account's password is locked, sudo can't be used until it's unlocked.
+	PasswordLocked bool
+	SudoInstalled  bool
+	// HelperNoPassword Whether sudo will start the helper without a password, ex: from the drop-in.
+	HelperNoPassword bool
+	DropInInstalled  bool
+	// HelperCopyOutdated Whether the root-owned copy the drop-in points at is another version than this one.
+	HelperCopyOutdated bool
+}
+
+// Get how the current user can get root.
+func getAuthState() AuthState {
+	var state AuthState
+	state.Root = os.Geteuid() == 0
+	_, err := callHelper(HelperRequest{Op: "ping"})
+	state.HelperRunning = err == nil
+	state.DropInInstalled = doesFileExist(SudoersDropInPath)
+	state.HelperCopyOutdated = doesFileExist(HelperInstallPath) && !isHelperCopyCurrent()
+
+	current, err := user.Current()
+	var status PasswordStatus
+	if err == nil {
+		status, err = getPasswordStatus(current.Username)
+		state.PasswordSet = status == PasswordUsable
+		state.PasswordLocked = status == PasswordLocked
+	}
+	if err != nil {
+		// Assume there is one, so the usual password prompt is shown.
+		CryoUtils.ErrorLog.Println(err)
+		state.PasswordSet = true
+	}
+
+	_, err = exec.LookPath("sudo")
+	state.SudoInstalled = err == nil
+	if state.SudoInstalled && doesFileExist(HelperInstallPath) {
+		// With -n, sudo fails instead of asking for a password it would need.
+		err = exec.Command("sudo", "-n", "-l", HelperInstallPath, HelperCommand).Run()
+		state.HelperNoPassword = err == nil
+	}
+	return state
+}
+
+// Check whether the root-owned copy of CryoUtilities is the same as the running one.
+func isHelperCopyCurrent() bool {
+	executable, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	running := hashFile(executable)
+	return running != "" && running == hashFile(HelperInstallPath)
+}
+
+// PasswordStatus Whether an account has a password sudo can check.
+type PasswordStatus int
+
+const (
+	PasswordUsable PasswordStatus = iota
+	PasswordNone
+	// PasswordLocked Locked with passwd -l, setting one without the old password isn't possible.
+	PasswordLocked
+)
+
+// Check whether an account has a usable password, using passwd -S like uninstall.sh.
+func getPasswordStatus(username string) (PasswordStatus, error) {
+	output, err := runCommand("passwd", "-S", username)
+	if err != nil {
+		return PasswordUsable, withCommandOp("获取密码状态", err)
+	}
+	return parsePasswordStatus(string(output))
+}
+
+// Parse the output of passwd -S, ex: "deck P 2023-03-01 0 99999 7 -1". P is a usable password,
+// NP is none and L is locked.
+func parsePasswordStatus(output string) (PasswordStatus, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 2 {
+		return PasswordUsable, fmt.Errorf("无法解析密码状态: %q", output)
+	}
+	switch fields[1] {
+	case "P", "PS":
+		return PasswordUsable, nil
+	case "NP":
+		return PasswordNone, nil
+	case "L", "LK":
+		return PasswordLocked, nil
+	}
+	return PasswordUsable, fmt.Errorf("未知的密码状态: %s", fields[1])
+}
+
+// Check a new password is one the set password dialog should accept.
+func validateNewPassword(password string, confirm string) error {
+	if password != confirm {
+		return fmt.Errorf("两次输入的密码不一致")
+	}
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("密码至少需要 %d 个字符", MinPasswordLength)
+	}
+	if strings.ContainsAny(password, "\n\r") {
+		return fmt.Errorf("密码不能包含换行符")
+	}
+	return nil
+}
+
+// SetUserPassword Set the password of an account that has none. passwd doesn't ask for the
+// current password then, so the new one is all it needs.
+func SetUserPassword(password string) error {
+	err := validateNewPassword(password, password)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("passwd")
+	cmd.Stdin = strings.NewReader(password + "\n" + password + "\n")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return withCommandOp("设置密码", newCommandError(cmd.Args, output, err))
+	}
+	CryoUtils.InfoLog.Println("已设置用户密码")
+	return nil
+}
+
+// Get the sudoers drop-in that lets a user start the privileged helper, and nothing else, without a password.
+func getSudoersDropIn(username string, executable string) (string, error) {
+	if !sudoersSafeRegex.MatchString(username) || !sudoersSafeRegex.MatchString(executable) {
+		return "", fmt.Errorf("无法为 %s 和 %s 生成 sudoers 规则", username, executable)
+	}
+	return fmt.Sprintf("# Generated by CryoUtilities, lets %s start its privileged helper without a password.\n"+
+		"%s ALL=(root) NOPASSWD: %s %s *\n", username, username, executable, HelperCommand), nil
+}
+
+// Get the drop-in for the user with a given uid, pointing at the root-owned copy of CryoUtilities.
+func getSudoersDropInForUID(uid int) (string, error) {
+	account, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return "", err
+	}
+	return getSudoersDropIn(account.Username, HelperInstallPath)
+}
+
+// InstallSudoersDropIn Let the current user start the privileged helper without a password from now on.
+// It goes through sudo with the password the user just entered, never through the helper, which anything
+// running as the user can talk to.
+func InstallSudoersDropIn(password string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("sudo", "-S", "-p", "", "--", executable, "auth", "sudoers", "install")
+	cmd.Stdin = strings.NewReader(password + "\n")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return withCommandOp("安装 sudoers 规则", newCommandError(cmd.Args, output, err))
+	}
+	return nil
+}
+
+// Copy this executable to where only root can change it, and point the drop-in for a user at the copy.
+func installSudoersDropIn(uid int) error {
+	contents, err := getSudoersDropInForUID(uid)
+	if err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	CryoUtils.InfoLog.Println("正在安装特权助手副本", HelperInstallPath)
+	_, err = getExecutor().Run(context.Background(), "install", "-D", "-o", "root", "-g", "root", "-m", "0755", executable, HelperInstallPath)
+	if err != nil {
+		return withCommandOp("安装特权助手副本", err)
+	}
+	CryoUtils.InfoLog.Println("正在安装 sudoers 规则", SudoersDropInPath)
+	return writeFile(SudoersDropInPath, contents)
+}
+
+// Go back to asking for a password to start the privileged helper.
+func removeSudoersDropIn() error {
+	for _, path := range []string{SudoersDropInPath, HelperInstallPath} {
+		CryoUtils.InfoLog.Println("正在删除", path)
+		err := getExecutor().RemoveFile(path)
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+			return fmt.Errorf("删除 %s 时出错", path)
+		}
+	}
+	return nil
+}
+
+// Return a human-readable summary of how the current user can get root.
+func (s AuthState) String() string {
+	yesNo := func(b bool) string {
+		if b {
+			return "是"
+		}
+		return "否"
+	}
+	return fmt.Sprintf("以 root 运行: %s\n特权助手运行中: %s\n已设置密码: %s\n密码已锁定: %s\n已安装 sudo: %s\n"+
+		"无需密码启动特权助手: %s\n已安装 sudoers 规则: %s\n特权助手副本已过期: %s",
+		yesNo(s.Root), yesNo(s.HelperRunning), yesNo(s.PasswordSet), yesNo(s.PasswordLocked), yesNo(s.SudoInstalled),
+		yesNo(s.HelperNoPassword), yesNo(s.DropInInstalled), yesNo(s.HelperCopyOutdated))
+}
+
+// AuthCLI Show how the current user can get root, or install or remove the sudoers drop-in.
+func AuthCLI(args []string) error {
+	if len(args) > 0 {
+		if len(args) != 2 || args[0] != "sudoers" {
+			return fmt.Errorf("无效的参数: %s", strings.Join(args, " "))
+		}
+		if args[1] != "install" && args[1] != "remove" {
+			return fmt.Errorf("无效的参数: %s", args[1])
+		}
+		// Only ever from an interactive sudo, the helper refuses to touch sudoers.
+		if os.Geteuid() != 0 {
+			return fmt.Errorf("安装或删除 sudoers 规则需要 root，请用 sudo 运行")
+		}
+		setExecutor(RootExecutor{})
+		var err error
+		if args[1] == "install" {
+			// Run with sudo, the drop-in is for whoever ran sudo.
+			uid, convErr := strconv.Atoi(os.Getenv("SUDO_UID"))
+			if convErr != nil {
+				uid = os.Getuid()
+			}
+			err = installSudoersDropIn(uid)
+		} else {
+			err = removeSudoersDropIn()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println(getAuthState())
+	return nil
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePasswordStatus(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    PasswordStatus
+		wantErr bool
+	}{
+		{"deck P 2023-03-01 0 99999 7 -1\n", PasswordUsable, false},
+		{"deck NP 2023-03-01 0 99999 7 -1\n", PasswordNone, false},
+		{"deck L 2023-03-01 0 99999 7 -1\n", PasswordLocked, false},
+		{"deck LK 2023-03-01 0 99999 7 -1 (Password locked.)\n", PasswordLocked, false},
+		{"deck PS 2023-03-01 0 99999 7 -1 (Password set, SHA512 crypt.)\n", PasswordUsable, false},
+		{"", PasswordUsable, true},
+		{"deck ?? 2023-03-01", PasswordUsable, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePasswordStatus(tt.output)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parsePasswordStatus(%q) = %v, %v, want %v", tt.output, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateNewPassword(t *testing.T) {
+	tests := []struct {
+		password, confirm string
+		wantErr           bool
+	}{
+		{"steamdeck", "steamdeck", false},
+		{"steamdeck", "steamdeek", true},
+		{"abc", "abc", true},
+		{"deck\ndeck", "deck\ndeck", true},
+	}
+	for _, tt := range tests {
+		if err := validateNewPassword(tt.password, tt.confirm); (err != nil) != tt.wantErr {
+			t.Errorf("validateNewPassword(%q, %q) error = %v, wantErr %v", tt.password, tt.confirm, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGetSudoersDropIn(t *testing.T) {
+	got, err := getSudoersDropIn("deck", "/home/deck/.cryo_utilities/cryo_utilities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Generated by CryoUtilities, lets deck start its privileged helper without a password.\n" +
+		"deck ALL=(root) NOPASSWD: /home/deck/.cryo_utilities/cryo_utilities helper *\n"
+	if got != want {
+		t.Errorf("getSudoersDropIn() = %q, want %q", got, want)
+	}
+	for _, executable := range []string{"/home/deck/my utils/cu", "/tmp/cu, ALL", "/tmp/cu\nALL ALL=(ALL) ALL"} {
+		if _, err = getSudoersDropIn("deck", executable); err == nil {
+			t.Errorf("getSudoersDropIn() with %q should fail", executable)
+		}
+	}
+
+	// The drop-in points at the root-owned copy, never at the running executable.
+	contents, err := getSudoersDropInForUID(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(contents, " NOPASSWD: "+HelperInstallPath+" helper *\n") {
+		t.Errorf("getSudoersDropInForUID() = %q, want it to point at %s", contents, HelperInstallPath)
+	}
+
+	// Only an interactive sudo installs or removes it, the helper refuses.
+	helper := &PrivilegedHelper{uid: 0}
+	for _, request := range []HelperRequest{
+		{Op: "write", Path: SudoersDropInPath, Data: contents},
+		{Op: "write", Path: filepath.Join(filepath.Dir(SudoersDropInPath), "other"), Data: contents},
+		{Op: "remove", Path: SudoersDropInPath},
+	} {
+		if err = helper.check(request); err == nil {
+			t.Errorf("check() allowed %s %s", request.Op, request.Path)
+		}
+	}
+}
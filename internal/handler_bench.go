@@ -0,0 +1,396 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// Latency buckets are a quarter of a power of two wide, enough resolution to compare settings.
+const latencyBucketsPerDoubling = 4
+const latencyBucketCount = 64 * latencyBucketsPerDoubling
+
+// LatencyHistogram Counts of page touch latencies, in logarithmic nanosecond buckets.
+type LatencyHistogram struct {
+	Counts []int64 `json:"counts"`
+	Max    int64   `json:"max"`
+}
+
+// LatencyPercentiles A summary of a latency histogram.
+type LatencyPercentiles struct {
+	Count int64         `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	P999  time.Duration `json:"p999"`
+	Max   time.Duration `json:"max"`
+}
+
+// BenchWorkerResult What the worker process reports back to the benchmark.
+type BenchWorkerResult struct {
+	FirstTouch LatencyHistogram `json:"first_touch"`
+	Retouch    LatencyHistogram `json:"retouch"`
+}
+
+// BenchOptions How to run the memory benchmark.
+type BenchOptions struct {
+	// Size of the working set in bytes, 0 picks a little more than available memory.
+	WorkingSet int64
+	Passes     int
+	// Profile to apply while the benchmark runs, empty uses the current settings.
+	Profile string
+	// Where to write the report, empty picks a file in BenchReportDirectory.
+	Output string
+}
+
+// BenchReport The results of one memory benchmark run.
+type BenchReport struct {
+	Time            time.Time          `json:"time"`
+	Profile         string             `json:"profile"`
+	Settings        TunableProfile     `json:"settings"`
+	WorkingSet      int64              `json:"working_set"`
+	Passes          int                `json:"passes"`
+	Duration        time.Duration      `json:"duration"`
+	FirstTouch      LatencyPercentiles `json:"first_touch"`
+	Retouch         LatencyPercentiles `json:"retouch"`
+	MemorySomeStall time.Duration      `json:"memory_some_stall"`
+	MemoryFullStall time.Duration      `json:"memory_full_stall"`
+	SwapIn          int64              `json:"swap_in"`
+	SwapOut         int64              `json:"swap_out"`
+	SwapInRate      float64            `json:"swap_in_rate"`
+	SwapOutRate     float64            `json:"swap_out_rate"`
+	Path            string             `json:"-"`
+}
+
+func newLatencyHistogram() LatencyHistogram {
+	return LatencyHistogram{Counts: make([]int64, latencyBucketCount)}
+}
+
+// Record one latency, in nanoseconds.
+func (h *LatencyHistogram) add(ns int64) {
+	if ns < 1 {
+		ns = 1
+	}
+	bucket := int(math.Log2(float64(ns)) * latencyBucketsPerDoubling)
+	if bucket >= latencyBucketCount {
+		bucket = latencyBucketCount - 1
+	}
+	h.Counts[bucket]++
+	if ns > h.Max {
+		h.Max = ns
+	}
+}
+
+// Get the upper edge of the bucket holding the given fraction of samples.
+func (h LatencyHistogram) percentile(fraction float64, total int64) time.Duration {
+	target := int64(math.Ceil(fraction * float64(total)))
+	var seen int64
+	for bucket, count := range h.Counts {
+		seen += count
+		if seen >= target && seen > 0 {
+			upper := int64(math.Exp2(float64(bucket+1) / latencyBucketsPerDoubling))
+			if upper > h.Max {
+				upper = h.Max
+			}
+			return time.Duration(upper)
+		}
+	}
+	return time.Duration(h.Max)
+}
+
+// Summarise the histogram.
+func (h LatencyHistogram) percentiles() LatencyPercentiles {
+	var total int64
+	for _, count := range h.Counts {
+		total += count
+	}
+	if total == 0 {
+		return LatencyPercentiles{}
+	}
+	return LatencyPercentiles{
+		Count: total,
+		P50:   h.percentile(0.5, total),
+		P90:   h.percentile(0.9, total),
+		P99:   h.percentile(0.99, total),
+		P999:  h.percentile(0.999, total),
+		Max:   time.Duration(h.Max),
+	}
+}
+
+// RunBenchWorker Touch every page of a working set, timing each touch, and print the results as JSON.
+// This runs in its own process, so the memory is handed back the moment it exits.
+func RunBenchWorker(size int64, passes int, output io.Writer) error {
+	if size <= 0 || passes <= 0 {
+		return fmt.Errorf("无效的工作集大小或次数")
+	}
+	// Map the memory directly, so the garbage collector never scans or touches it.
+	memory, err := unix.Mmap(-1, 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_NORESERVE)
+	if err != nil {
+		return err
+	}
+	defer unix.Munmap(memory)
+
+	result := BenchWorkerResult{FirstTouch: newLatencyHistogram(), Retouch: newLatencyHistogram()}
+	pageSize := os.Getpagesize()
+	for pass := 0; pass < passes; pass++ {
+		histogram := &result.Retouch
+		if pass == 0 {
+			histogram = &result.FirstTouch
+		}
+		// A page that is still resident only costs the time of reading the clock.
+		// One that was swapped out costs a major fault, which is what the settings change.
+		for offset := 0; offset < len(memory); offset += pageSize {
+			start := time.Now()
+			memory[offset] = byte(pass + 1)
+			histogram.add(time.Since(start).Nanoseconds())
+		}
+	}
+	return json.NewEncoder(output).Encode(result)
+}
+
+// Pick a working set a little larger than available memory, or check a requested one is safe.
+func getBenchWorkingSet(requested int64) (int64, error) {
+	meminfo, err := readMeminfo(ProcRoot)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return 0, fmt.Errorf("读取内存信息时出错")
+	}
+	available := meminfo["MemAvailable"] * 1024
+	limit := available + meminfo["SwapFree"]*1024 - BenchSafetyMargin
+
+	size := requested
+	if size <= 0 {
+		size = available + int64(BenchDefaultOverflow*GigabyteMultiplier)
+	}
+	if size > limit {
+		return 0, fmt.Errorf("工作集 %.1fGB 超过了可用内存和交换 (%.1fGB)，可能会触发 OOM，请选择更小的大小或增加交换文件",
+			float64(size)/float64(GigabyteMultiplier), float64(limit)/float64(GigabyteMultiplier))
+	}
+	return size, nil
+}
+
+// Run the worker as a child process and wait for its results.
+func runBenchWorkerProcess(ctx context.Context, size int64, passes int) (BenchWorkerResult, error) {
+	var result BenchWorkerResult
+	executable, err := os.Executable()
+	if err != nil {
+		return result, err
+	}
+	cmd := exec.CommandContext(ctx, executable, BenchWorkerCommand,
+		"--size", strconv.FormatInt(size, 10), "--passes", strconv.Itoa(passes))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("基准测试已取消")
+	} else if err != nil {
+		CryoUtils.ErrorLog.Println(err, strings.TrimSpace(stderr.String()))
+		return result, fmt.Errorf("基准测试进程失败，可能被 OOM 终止，请选择更小的工作集")
+	}
+	err = json.Unmarshal(stdout.Bytes(), &result)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return result, fmt.Errorf("无法解析基准测试结果")
+	}
+	return result, nil
+}
+
+// RunMemoryBenchmark Run the memory benchmark, optionally under a profile, and save the report.
+func RunMemoryBenchmark(ctx context.Context, opts BenchOptions) (BenchReport, error) {
+	if opts.Passes <= 0 {
+		opts.Passes = DefaultBenchPasses
+	}
+	report := BenchReport{Time: time.Now(), Profile: opts.Profile, Passes: opts.Passes}
+
+	if opts.Profile != "" {
+		settings, err := loadSettings()
+		if err != nil {
+			return report, err
+		}
+		profile, err := settings.getProfile(opts.Profile)
+		if err != nil {
+			return report, err
+		}
+		baseline, err := getCurrentProfile(profile.params())
+		if err != nil {
+			return report, err
+		}
+		CryoUtils.InfoLog.Println("基准测试期间应用配置文件", opts.Profile, profile.String())
+		err = applyProfileLive(profile)
+		if err != nil {
+			return report, err
+		}
+		defer func() {
+			err := applyProfileLive(baseline)
+			if err != nil {
+				CryoUtils.ErrorLog.Println("恢复基准测试前的设置失败:", err)
+			}
+		}()
+	}
+	report.Settings = getBenchSettings()
+
+	var err error
+	report.WorkingSet, err = getBenchWorkingSet(opts.WorkingSet)
+	if err != nil {
+		return report, err
+	}
+
+	pressureBefore, err := readPressure(ProcRoot, "memory")
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return report, fmt.Errorf("读取内存压力时出错，内核可能未启用 PSI")
+	}
+	vmstatBefore, err := readVMStat(ProcRoot)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return report, fmt.Errorf("读取虚拟内存统计时出错")
+	}
+
+	CryoUtils.InfoLog.Println("开始内存基准测试，工作集", getHumanMemorySize(report.WorkingSet/1024))
+	start := time.Now()
+	result, err := runBenchWorkerProcess(ctx, report.WorkingSet, opts.Passes)
+	if err != nil {
+		return report, err
+	}
+	report.Duration = time.Since(start)
+
+	pressureAfter, err := readPressure(ProcRoot, "memory")
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return report, fmt.Errorf("读取内存压力时出错，内核可能未启用 PSI")
+	}
+	vmstatAfter, err := readVMStat(ProcRoot)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return report, fmt.Errorf("读取虚拟内存统计时出错")
+	}
+
+	report.FirstTouch = result.FirstTouch.percentiles()
+	report.Retouch = result.Retouch.percentiles()
+	report.MemorySomeStall = time.Duration(pressureAfter.Some.Total-pressureBefore.Some.Total) * time.Microsecond
+	report.MemoryFullStall = time.Duration(pressureAfter.Full.Total-pressureBefore.Full.Total) * time.Microsecond
+	pageSize := int64(os.Getpagesize())
+	report.SwapIn = (vmstatAfter["pswpin"] - vmstatBefore["pswpin"]) * pageSize
+	report.SwapOut = (vmstatAfter["pswpout"] - vmstatBefore["pswpout"]) * pageSize
+	if seconds := report.Duration.Seconds(); seconds > 0 {
+		report.SwapInRate = float64(report.SwapIn) / 1024 / 1024 / seconds
+		report.SwapOutRate = float64(report.SwapOut) / 1024 / 1024 / seconds
+	}
+
+	report.Path, err = saveBenchReport(report, opts.Output)
+	if err != nil {
+		return report, err
+	}
+	CryoUtils.InfoLog.Println("基准测试报告已保存到", report.Path)
+	return report, nil
+}
+
+// Get the settings that affect the benchmark, so the report says what was tested.
+func getBenchSettings() TunableProfile {
+	var params []string
+	for param := range UnitMatrix {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	settings, err := getCurrentProfile(params)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return TunableProfile{}
+	}
+	return settings
+}
+
+// Write the report as JSON, returning where it went.
+func saveBenchReport(report BenchReport, path string) (string, error) {
+	if path == "" {
+		name := report.Profile
+		if name == "" {
+			name = "current"
+		}
+		path = filepath.Join(BenchReportDirectory,
+			fmt.Sprintf("memory-%s-%s.json", report.Time.Format("20060102-150405"), name))
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, writeSharedFile(path, data)
+}
+
+// Get the name shown for the report, ex: in a comparison.
+func (r BenchReport) displayName() string {
+	if r.Profile == "" {
+		return "当前设置"
+	}
+	return r.Profile
+}
+
+// Get the report as rows of label and value, so two can be lined up side by side.
+func (r BenchReport) rows() [][2]string {
+	latency := func(d time.Duration) string {
+		return d.Round(time.Microsecond / 10).String()
+	}
+	return [][2]string{
+		{"工作集", getHumanMemorySize(r.WorkingSet / 1024)},
+		{"总时长", r.Duration.Round(time.Millisecond).String()},
+		{"首次访问 P50", latency(r.FirstTouch.P50)},
+		{"首次访问 P99", latency(r.FirstTouch.P99)},
+		{"再次访问 P50", latency(r.Retouch.P50)},
+		{"再次访问 P90", latency(r.Retouch.P90)},
+		{"再次访问 P99", latency(r.Retouch.P99)},
+		{"再次访问 P99.9", latency(r.Retouch.P999)},
+		{"再次访问最大", latency(r.Retouch.Max)},
+		{"内存停顿 (some)", r.MemorySomeStall.Round(time.Millisecond).String()},
+		{"内存停顿 (full)", r.MemoryFullStall.Round(time.Millisecond).String()},
+		{"换入", fmt.Sprintf("%s (%.1f MB/秒)", getHumanMemorySize(r.SwapIn/1024), r.SwapInRate)},
+		{"换出", fmt.Sprintf("%s (%.1f MB/秒)", getHumanMemorySize(r.SwapOut/1024), r.SwapOutRate)},
+	}
+}
+
+// Format the report for display.
+func (r BenchReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "配置文件: %s\n", r.displayName())
+	fmt.Fprintf(&b, "当前设置: %s\n", r.Settings)
+	for _, row := range r.rows() {
+		fmt.Fprintf(&b, "%s: %s\n", row[0], row[1])
+	}
+	if r.Path != "" {
+		fmt.Fprintf(&b, "报告: %s", r.Path)
+	}
+	return strings.TrimSpace(b.String())
+}
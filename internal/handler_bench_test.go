@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogramPercentiles(t *testing.T) {
+	h := newLatencyHistogram()
+	// 990 fast touches and 10 slow ones, like resident pages and a few major faults.
+	for i := 0; i < 990; i++ {
+		h.add(100)
+	}
+	for i := 0; i < 10; i++ {
+		h.add(int64(2 * time.Millisecond))
+	}
+
+	got := h.percentiles()
+	if got.Count != 1000 {
+		t.Errorf("Count = %v, want 1000", got.Count)
+	}
+	// Buckets are a quarter of a doubling wide, so allow that much error.
+	within := func(got time.Duration, want time.Duration) bool {
+		return got >= want && float64(got) <= float64(want)*1.19
+	}
+	if !within(got.P50, 100) || !within(got.P90, 100) {
+		t.Errorf("P50 = %v, P90 = %v, want about 100ns", got.P50, got.P90)
+	}
+	if !within(got.P999, 2*time.Millisecond) {
+		t.Errorf("P999 = %v, want about 2ms", got.P999)
+	}
+	if got.Max != 2*time.Millisecond {
+		t.Errorf("Max = %v, want 2ms", got.Max)
+	}
+	if (LatencyHistogram{Counts: make([]int64, latencyBucketCount)}).percentiles() != (LatencyPercentiles{}) {
+		t.Errorf("empty histogram should have no percentiles")
+	}
+}
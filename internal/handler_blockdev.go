@@ -0,0 +1,334 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// BlockDevice A disk from /sys/block and its queue settings.
+type BlockDevice struct {
+	Name        string
+	ModelAttr   string
+	Model       string
+	Serial      string
+	Scheduler   string
+	Schedulers  []string
+	ReadAheadKB string
+	NrRequests  string
+}
+
+// Read every real disk in /sys/block, sorted by name.
+func getBlockDevices(sysRoot string) ([]BlockDevice, error) {
+	entries, err := os.ReadDir(filepath.Join(sysRoot, "block"))
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return nil, fmt.Errorf("读取块设备列表时出错")
+	}
+
+	var devices []BlockDevice
+	for _, entry := range entries {
+		skip := false
+		for _, prefix := range BlockDeviceSkipPrefixes {
+			if strings.HasPrefix(entry.Name(), prefix) {
+				skip = true
+			}
+		}
+		dir := filepath.Join(sysRoot, "block", entry.Name())
+		if skip || !doesFileExist(filepath.Join(dir, "queue/scheduler")) {
+			continue
+		}
+		read := func(name string) string {
+			data, _ := os.ReadFile(filepath.Join(dir, name))
+			return strings.TrimSpace(string(data))
+		}
+
+		device := BlockDevice{
+			Name:        entry.Name(),
+			ModelAttr:   "model",
+			Model:       read("device/model"),
+			Serial:      read("device/serial"),
+			ReadAheadKB: read("queue/read_ahead_kb"),
+			NrRequests:  read("queue/nr_requests"),
+		}
+		// SD cards have a name rather than a model.
+		if device.Model == "" {
+			device.ModelAttr = "name"
+			device.Model = read("device/name")
+		}
+		// The active scheduler is the one in brackets, ex: "mq-deadline kyber [bfq] none"
+		for _, scheduler := range strings.Fields(read("queue/scheduler")) {
+			if strings.HasPrefix(scheduler, "[") {
+				scheduler = strings.Trim(scheduler, "[]")
+				device.Scheduler = scheduler
+			}
+			device.Schedulers = append(device.Schedulers, scheduler)
+		}
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices, nil
+}
+
+func getBlockDevice(sysRoot string, name string) (BlockDevice, error) {
+	devices, err := getBlockDevices(sysRoot)
+	if err != nil {
+		return BlockDevice{}, err
+	}
+	for _, device := range devices {
+		if device.Name == name {
+			return device, nil
+		}
+	}
+	return BlockDevice{}, fmt.Errorf("找不到块设备: %s", name)
+}
+
+// Check whether saved settings belong to this device.
+func (d BlockDevice) matches(s BlockDeviceSettings) bool {
+	return d.ModelAttr == s.ModelAttr && d.Model == s.Model && d.Serial == s.Serial
+}
+
+func (d BlockDevice) String() string {
+	name := d.Name
+	if d.Model != "" {
+		name += "  " + d.Model
+	}
+	if d.Serial != "" {
+		name += " (" + d.Serial + ")"
+	}
+	return fmt.Sprintf("%s  调度器: %s  预读: %s kB  队列深度: %s  可用调度器: %s",
+		name, d.Scheduler, d.ReadAheadKB, d.NrRequests, strings.Join(d.Schedulers, " "))
+}
+
+// Get the queue attributes to write, in order. Changing the scheduler resets nr_requests, so it goes first.
+func (s BlockDeviceSettings) attributes() [][2]string {
+	var attributes [][2]string
+	for _, attribute := range [][2]string{
+		{"scheduler", s.Scheduler},
+		{"read_ahead_kb", s.ReadAheadKB},
+		{"nr_requests", s.NrRequests},
+	} {
+		if attribute[1] != "" {
+			attributes = append(attributes, attribute)
+		}
+	}
+	return attributes
+}
+
+// Make sure the device accepts the settings.
+func (s BlockDeviceSettings) validate(device BlockDevice) error {
+	if s.Scheduler != "" && !contains(device.Schedulers, s.Scheduler) {
+		return fmt.Errorf("无效的调度器: %s，可用: %s", s.Scheduler, strings.Join(device.Schedulers, " "))
+	}
+	if s.ReadAheadKB != "" {
+		value, err := strconv.Atoi(s.ReadAheadKB)
+		if err != nil || value < 0 || value > 65536 {
+			return fmt.Errorf("无效的预读大小: %s", s.ReadAheadKB)
+		}
+	}
+	if s.NrRequests != "" {
+		// The kernel won't go below 4 requests.
+		value, err := strconv.Atoi(s.NrRequests)
+		if err != nil || value < 4 || value > 65536 {
+			return fmt.Errorf("无效的队列深度: %s", s.NrRequests)
+		}
+	}
+	// Without a model the rule would match every disk.
+	if device.Model == "" {
+		return fmt.Errorf("无法识别 %s 的型号，无法保存设置", device.Name)
+	}
+	// udev has no way to escape a quote.
+	if strings.Contains(s.Model+s.Serial, "\"") {
+		return fmt.Errorf("无法为 %s 生成 udev 规则", device.Name)
+	}
+	return nil
+}
+
+// Get the udev rule applying the settings to the device whenever it appears, under any name.
+func (s BlockDeviceSettings) rule() string {
+	rule := []string{`ACTION=="add|change"`, `SUBSYSTEM=="block"`, `ENV{DEVTYPE}=="disk"`,
+		fmt.Sprintf(`ATTRS{%s}=="%s"`, s.ModelAttr, s.Model)}
+	if s.Serial != "" {
+		rule = append(rule, fmt.Sprintf(`ATTRS{serial}=="%s"`, s.Serial))
+	}
+	for _, attribute := range s.attributes() {
+		rule = append(rule, fmt.Sprintf(`ATTR{queue/%s}="%s"`, attribute[0], attribute[1]))
+	}
+	return strings.Join(rule, ", ")
+}
+
+// Get the contents of the rules file for every saved device.
+func getBlockDeviceRules(devices []BlockDeviceSettings) string {
+	var b strings.Builder
+	b.WriteString("# Generated by CryoUtilities, changes will be overwritten.\n")
+	for _, device := range devices {
+		fmt.Fprintf(&b, "# %s %s\n%s\n", device.Model, device.Serial, device.rule())
+	}
+	return b.String()
+}
+
+// Write the rules file, or remove it once nothing is left in it, and have udev pick it up.
+func writeBlockDeviceRules(devices []BlockDeviceSettings) error {
+	var err error
+	if len(devices) == 0 {
+		err = removeFile(BlockDeviceRulesPath)
+	} else {
+		err = writeFile(BlockDeviceRulesPath, getBlockDeviceRules(devices))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = getExecutor().Run(context.Background(), "udevadm", "control", "--reload")
+	if err != nil {
+		return withCommandOp("重新加载 udev 规则", err)
+	}
+	return nil
+}
+
+// SetBlockDeviceQueue Change the queue settings of a device now, and whenever it appears again.
+// Empty values keep what was saved before.
+func SetBlockDeviceQueue(name string, changes BlockDeviceSettings) error {
+	device, err := getBlockDevice(SysRoot, name)
+	if err != nil {
+		return err
+	}
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+
+	merged := BlockDeviceSettings{ModelAttr: device.ModelAttr, Model: device.Model, Serial: device.Serial}
+	index := -1
+	for i, saved := range settings.BlockDevices {
+		if device.matches(saved) {
+			merged = saved
+			index = i
+		}
+	}
+	for _, field := range []struct {
+		change string
+		value  *string
+	}{{changes.Scheduler, &merged.Scheduler}, {changes.ReadAheadKB, &merged.ReadAheadKB}, {changes.NrRequests, &merged.NrRequests}} {
+		if field.change != "" {
+			*field.value = field.change
+		}
+	}
+	err = merged.validate(device)
+	if err != nil {
+		return err
+	}
+
+	// Write everything saved, a new scheduler would otherwise lose the saved queue depth.
+	for _, attribute := range merged.attributes() {
+		CryoUtils.InfoLog.Println("设置", name, attribute[0], "为", attribute[1])
+		err = writeKernelValue(filepath.Join(SysRoot, "block", name, "queue", attribute[0]), attribute[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	if index >= 0 {
+		settings.BlockDevices[index] = merged
+	} else {
+		settings.BlockDevices = append(settings.BlockDevices, merged)
+	}
+	err = saveSettings(settings)
+	if err != nil {
+		return err
+	}
+	return writeBlockDeviceRules(settings.BlockDevices)
+}
+
+// ResetBlockDevice Forget the saved settings of a device, the kernel's own apply the next time it appears.
+func ResetBlockDevice(name string) error {
+	device, err := getBlockDevice(SysRoot, name)
+	if err != nil {
+		return err
+	}
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	var kept []BlockDeviceSettings
+	for _, saved := range settings.BlockDevices {
+		if !device.matches(saved) {
+			kept = append(kept, saved)
+		}
+	}
+	if len(kept) == len(settings.BlockDevices) {
+		return nil
+	}
+	CryoUtils.InfoLog.Println("删除", name, "的队列设置")
+	settings.BlockDevices = kept
+	err = saveSettings(settings)
+	if err != nil {
+		return err
+	}
+	return writeBlockDeviceRules(settings.BlockDevices)
+}
+
+// BlockDeviceCLI List the block devices, or change one with 'name key=value ...' or 'name reset'.
+func BlockDeviceCLI(args []string) error {
+	if len(args) == 1 {
+		return fmt.Errorf("缺少设置，例如: %s scheduler=kyber", args[0])
+	} else if len(args) == 2 && args[1] == "reset" {
+		err := ResetBlockDevice(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println("已删除", args[0], "的保存设置，重新插入或重启后恢复内核默认值。")
+	} else if len(args) >= 2 {
+		var changes BlockDeviceSettings
+		for _, arg := range args[1:] {
+			key, value, found := strings.Cut(arg, "=")
+			switch {
+			case found && key == "scheduler":
+				changes.Scheduler = value
+			case found && key == "read_ahead_kb":
+				changes.ReadAheadKB = value
+			case found && key == "nr_requests":
+				changes.NrRequests = value
+			default:
+				return fmt.Errorf("无效的参数: %s", arg)
+			}
+		}
+		err := SetBlockDeviceQueue(args[0], changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	devices, err := getBlockDevices(SysRoot)
+	if err != nil {
+		return err
+	}
+	for _, device := range devices {
+		fmt.Println(device)
+	}
+	return nil
+}
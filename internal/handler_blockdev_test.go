@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeFakeBlockDevice(t *testing.T, sysRoot string, name string, files map[string]string) {
+	t.Helper()
+	for file, contents := range files {
+		path := filepath.Join(sysRoot, "block", name, file)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(contents+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetBlockDevices(t *testing.T) {
+	sysRoot := t.TempDir()
+	makeFakeBlockDevice(t, sysRoot, "nvme0n1", map[string]string{
+		"device/model":        "KINGSTON OM8PDP3512B-A01                ",
+		"device/serial":       "50026B7685A1B2C3    ",
+		"queue/scheduler":     "[none] mq-deadline kyber bfq",
+		"queue/read_ahead_kb": "128",
+		"queue/nr_requests":   "1023",
+	})
+	makeFakeBlockDevice(t, sysRoot, "mmcblk0", map[string]string{
+		"device/name":         "EC2QT",
+		"device/serial":       "0x1a2b3c4d",
+		"queue/scheduler":     "mq-deadline kyber [bfq] none",
+		"queue/read_ahead_kb": "128",
+		"queue/nr_requests":   "64",
+	})
+	makeFakeBlockDevice(t, sysRoot, "loop0", map[string]string{"queue/scheduler": "[none]"})
+
+	devices, err := getBlockDevices(sysRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("getBlockDevices() found %d devices, want 2: %v", len(devices), devices)
+	}
+	sd, nvme := devices[0], devices[1]
+	if sd.ModelAttr != "name" || sd.Model != "EC2QT" || sd.Scheduler != "bfq" || len(sd.Schedulers) != 4 {
+		t.Errorf("SD card = %+v", sd)
+	}
+	if nvme.ModelAttr != "model" || nvme.Model != "KINGSTON OM8PDP3512B-A01" || nvme.Serial != "50026B7685A1B2C3" ||
+		nvme.Scheduler != "none" {
+		t.Errorf("NVMe = %+v", nvme)
+	}
+
+	tests := []struct {
+		name     string
+		settings BlockDeviceSettings
+		wantErr  bool
+	}{
+		{"valid", BlockDeviceSettings{Scheduler: "kyber", ReadAheadKB: "512", NrRequests: "64"}, false},
+		{"unknown scheduler", BlockDeviceSettings{Scheduler: "cfq"}, true},
+		{"bad read-ahead", BlockDeviceSettings{ReadAheadKB: "-1"}, true},
+		{"too few requests", BlockDeviceSettings{NrRequests: "2"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.validate(sd)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	if err := (BlockDeviceSettings{}).validate(BlockDevice{Name: "vda"}); err == nil {
+		t.Errorf("validate() should refuse a device without a model")
+	}
+}
+
+func TestBlockDeviceRule(t *testing.T) {
+	settings := BlockDeviceSettings{ModelAttr: "name", Model: "EC2QT", Serial: "0x1a2b3c4d",
+		Scheduler: "bfq", NrRequests: "64"}
+	want := `ACTION=="add|change", SUBSYSTEM=="block", ENV{DEVTYPE}=="disk", ATTRS{name}=="EC2QT", ATTRS{serial}=="0x1a2b3c4d", ` +
+		`ATTR{queue/scheduler}="bfq", ATTR{queue/nr_requests}="64"`
+	if got := settings.rule(); got != want {
+		t.Errorf("rule() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,383 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// Memory sizes as the kernel accepts them, ex: "6G", "512M", "1073741824" or "max".
+var cgroupMemoryRegex = regexp.MustCompile(`^([0-9]+[KMG]?|max)$`)
+
+// The user's systemd instance, which systemd delegates the memory controller to.
+var userServiceCgroupRegex = regexp.MustCompile(`^(.*/user@[0-9]+\.service)(/|$)`)
+
+// CgroupManager Moves the running game and Steam's background processes into cgroups with memory limits.
+// Everything happens in the cgroup tree systemd delegates to the user, so no root is needed.
+type CgroupManager struct {
+	root     string
+	base     string
+	procRoot string
+	settings CgroupSettings
+	// The cgroup each moved process came from, relative to root, so it can be put back.
+	moved map[int]string
+	// Which of our cgroups each moved process is in.
+	placed map[int]string
+	// Where to put processes that weren't moved by us, like the game's children, when removing the cgroups.
+	fallback string
+}
+
+func newCgroupManager(root string, base string, procRoot string, settings CgroupSettings) *CgroupManager {
+	return &CgroupManager{
+		root:     root,
+		base:     base,
+		procRoot: procRoot,
+		settings: settings,
+		moved:    map[int]string{},
+		placed:   map[int]string{},
+	}
+}
+
+// Get the cgroup a process is in, relative to the cgroup root.
+func getProcessCgroup(procRoot string, pid string) (string, error) {
+	file, err := os.Open(filepath.Join(procRoot, pid, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// cgroup v2 is the line with hierarchy 0 and no controllers, ex: "0::/user.slice/..."
+		if path, found := strings.CutPrefix(scanner.Text(), "0::"); found {
+			return path, nil
+		}
+	}
+	if scanner.Err() != nil {
+		return "", scanner.Err()
+	}
+	return "", fmt.Errorf("没有找到 cgroup v2")
+}
+
+// Find the cgroup of the user's systemd instance, where cgroups can be created without root.
+func getDelegatedCgroup(procRoot string) (string, error) {
+	path, err := getProcessCgroup(procRoot, "self")
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return "", fmt.Errorf("无法读取当前的 cgroup，系统可能未使用 cgroup v2")
+	}
+	match := userServiceCgroupRegex.FindStringSubmatch(path)
+	if match == nil {
+		return "", fmt.Errorf("当前进程不在用户的 systemd 实例中 (%s)，无法创建 cgroup", path)
+	}
+	return match[1], nil
+}
+
+// Make sure the sizes are ones the kernel will accept.
+func (s CgroupSettings) validate() error {
+	for name, value := range map[string]string{
+		"game_low":        s.GameLow,
+		"game_min":        s.GameMin,
+		"background_high": s.BackgroundHigh,
+	} {
+		if !cgroupMemoryRegex.MatchString(value) {
+			return fmt.Errorf("无效的内存大小 %s: %s", name, value)
+		}
+	}
+	return nil
+}
+
+func (m *CgroupManager) path(name ...string) string {
+	return filepath.Join(append([]string{m.root, m.base, CgroupSliceName}, name...)...)
+}
+
+func writeCgroupFile(path string, value string) error {
+	// Appending lets a fake cgroupfs record every write, the kernel ignores the offset.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(value + "\n")
+	return err
+}
+
+// Create the game and background cgroups and set their limits.
+func (m *CgroupManager) setup() error {
+	err := m.settings.validate()
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{m.path(), m.path(CgroupGameName), m.path(CgroupBackgroundName)} {
+		err = os.Mkdir(dir, 0755)
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			CryoUtils.ErrorLog.Println(err)
+			return fmt.Errorf("无法创建 cgroup %s", dir)
+		}
+	}
+
+	// A cgroup can only hand controllers to its children while it holds no processes itself.
+	writes := [][2]string{
+		{m.path("cgroup.subtree_control"), "+memory"},
+		{m.path(CgroupGameName, "memory.min"), m.settings.GameMin},
+		{m.path(CgroupGameName, "memory.low"), m.settings.GameLow},
+		{m.path(CgroupBackgroundName, "memory.high"), m.settings.BackgroundHigh},
+	}
+	for _, write := range writes {
+		err = writeCgroupFile(write[0], write[1])
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+			return fmt.Errorf("无法写入 %s", write[0])
+		}
+	}
+	CryoUtils.InfoLog.Println("已创建游戏内存保护 cgroup:", m.path())
+	return nil
+}
+
+// Move a process into one of our cgroups, remembering where it came from.
+func (m *CgroupManager) move(pid int, name string) error {
+	if m.placed[pid] == name {
+		return nil
+	}
+	if _, ok := m.moved[pid]; !ok {
+		original, err := getProcessCgroup(m.procRoot, strconv.Itoa(pid))
+		if err != nil {
+			// The process has exited.
+			return nil
+		}
+		m.moved[pid] = original
+	}
+	if m.fallback == "" && name == CgroupGameName {
+		m.fallback = m.moved[pid]
+	}
+	err := writeCgroupFile(m.path(name, "cgroup.procs"), strconv.Itoa(pid))
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return fmt.Errorf("无法将进程 %d 移入 cgroup %s", pid, name)
+	}
+	m.placed[pid] = name
+	return nil
+}
+
+// Put a process back where it came from.
+func (m *CgroupManager) release(pid int) {
+	original := m.moved[pid]
+	delete(m.moved, pid)
+	delete(m.placed, pid)
+	if original == "" || !doesFileExist(filepath.Join(m.procRoot, strconv.Itoa(pid))) {
+		return
+	}
+	err := writeCgroupFile(filepath.Join(m.root, original, "cgroup.procs"), strconv.Itoa(pid))
+	// The old cgroup may be gone, then there's nothing to put it back in.
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		CryoUtils.ErrorLog.Println("无法将进程", pid, "移回", original, err)
+	}
+}
+
+// Get the PIDs of Steam's background processes.
+func (m *CgroupManager) getBackgroundProcesses() ([]int, error) {
+	pids, err := getProcessIDs(m.procRoot)
+	if err != nil {
+		return nil, err
+	}
+	var background []int
+	for _, pid := range pids {
+		if contains(CgroupBackgroundProcesses, getProcessName(m.procRoot, pid)) {
+			background = append(background, pid)
+		}
+	}
+	return background, nil
+}
+
+// Protect every running game, and limit the background while any game is running.
+// Processes started by a moved process inherit its cgroup, so only the ones found so far need moving.
+func (m *CgroupManager) step(games map[int][]int) error {
+	wanted := map[int]string{}
+	for _, pids := range games {
+		for _, pid := range pids {
+			wanted[pid] = CgroupGameName
+		}
+	}
+	if len(games) > 0 {
+		background, err := m.getBackgroundProcesses()
+		if err != nil {
+			return err
+		}
+		for _, pid := range background {
+			if _, ok := wanted[pid]; !ok {
+				wanted[pid] = CgroupBackgroundName
+			}
+		}
+	}
+
+	var pids []int
+	for pid := range wanted {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	var errs []error
+	for _, pid := range pids {
+		err := m.move(pid, wanted[pid])
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Everything no longer wanted goes back, ex: Steam once the game exits.
+	for pid := range m.moved {
+		if _, ok := wanted[pid]; !ok {
+			m.release(pid)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Put every moved process back and remove our cgroups.
+func (m *CgroupManager) restore() error {
+	for pid := range m.moved {
+		m.release(pid)
+	}
+	// Children the game started are still in the game cgroup, and have to go before it can be removed.
+	if m.fallback != "" {
+		for _, name := range []string{CgroupGameName, CgroupBackgroundName} {
+			data, err := os.ReadFile(m.path(name, "cgroup.procs"))
+			if err != nil {
+				continue
+			}
+			for _, line := range strings.Fields(string(data)) {
+				_ = writeCgroupFile(filepath.Join(m.root, m.fallback, "cgroup.procs"), line)
+			}
+		}
+	}
+
+	var errs []error
+	for _, dir := range []string{m.path(CgroupGameName), m.path(CgroupBackgroundName), m.path()} {
+		err := os.Remove(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		CryoUtils.ErrorLog.Println(errors.Join(errs...))
+		return fmt.Errorf("无法删除游戏内存保护 cgroup")
+	}
+	CryoUtils.InfoLog.Println("已删除游戏内存保护 cgroup")
+	return nil
+}
+
+// Get the memory used by each of our cgroups, in kB.
+func (m *CgroupManager) usage() map[string]int64 {
+	usage := map[string]int64{}
+	for _, name := range []string{CgroupGameName, CgroupBackgroundName} {
+		data, err := os.ReadFile(m.path(name, "memory.current"))
+		if err != nil {
+			continue
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err == nil {
+			usage[name] = value / 1024
+		}
+	}
+	return usage
+}
+
+// Format the settings as the key=value pairs accepted by set.
+func (s CgroupSettings) String() string {
+	return fmt.Sprintf("enabled=%t game_low=%s game_min=%s background_high=%s",
+		s.Enabled, s.GameLow, s.GameMin, s.BackgroundHigh)
+}
+
+// Change one setting by the name shown by String.
+func (s *CgroupSettings) set(key string, value string) error {
+	switch key {
+	case "enabled":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("无效的值 %s: %s", key, value)
+		}
+		s.Enabled = enabled
+	case "game_low":
+		s.GameLow = value
+	case "game_min":
+		s.GameMin = value
+	case "background_high":
+		s.BackgroundHigh = value
+	default:
+		return fmt.Errorf("未知的设置: %s", key)
+	}
+	return s.validate()
+}
+
+// CgroupCLI Show the game memory protection settings and usage, or change them.
+// Accepts 'on', 'off' or key=value pairs.
+func CgroupCLI(args []string) error {
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		switch arg {
+		case "on":
+			settings.Cgroup.Enabled = true
+			continue
+		case "off":
+			settings.Cgroup.Enabled = false
+			continue
+		}
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			return fmt.Errorf("无效的参数: %s", arg)
+		}
+		err = settings.Cgroup.set(strings.TrimSpace(key), strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) > 0 {
+		err = saveSettings(settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(settings.Cgroup.String())
+	base, err := getDelegatedCgroup(ProcRoot)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	usage := newCgroupManager(CgroupRoot, base, ProcRoot, settings.Cgroup).usage()
+	if len(usage) == 0 {
+		fmt.Println("游戏内存保护未运行，需要运行 'watch' 守护进程。")
+		return nil
+	}
+	fmt.Printf("游戏: %s  后台: %s\n", getHumanMemorySize(usage[CgroupGameName]), getHumanMemorySize(usage[CgroupBackgroundName]))
+	return nil
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Create an empty cgroup in a fake cgroupfs, files the kernel would provide are created empty.
+func makeFakeCgroup(t *testing.T, root string, path string) {
+	t.Helper()
+	dir := filepath.Join(root, path)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cgroup.procs", "cgroup.subtree_control", "memory.min", "memory.low", "memory.high",
+		"cpu.weight"} {
+		err = os.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func setFakeProcessCgroup(t *testing.T, procRoot string, pid string, path string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Join(procRoot, pid), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(procRoot, pid, "cgroup"), []byte("0::"+path+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFakeCgroupFile(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(data))
+}
+
+func TestCgroupManager(t *testing.T) {
+	root := t.TempDir()
+	procRoot := t.TempDir()
+	base := "/user.slice/user-1000.slice/user@1000.service"
+	appScope := base + "/app.slice/app-steam.scope"
+	makeFakeCgroup(t, root, appScope)
+
+	setFakeProcessCgroup(t, procRoot, "self", base+"/app.slice/cryoutilities-watch.service")
+	got, err := getDelegatedCgroup(procRoot)
+	if err != nil || got != base {
+		t.Fatalf("getDelegatedCgroup() = %v, %v, want %v", got, err, base)
+	}
+
+	makeFakeProcess(t, procRoot, 100, "steam", []string{"steam"}, nil)
+	makeFakeProcess(t, procRoot, 101, "steamwebhelper", []string{"steamwebhelper"}, nil)
+	makeFakeProcess(t, procRoot, 200, "RDR2.exe", []string{"RDR2.exe"}, []string{"SteamAppId=1174180"})
+	for _, pid := range []string{"100", "101", "200"} {
+		setFakeProcessCgroup(t, procRoot, pid, appScope)
+	}
+
+	settings := CgroupSettings{Enabled: true, GameLow: "6G", GameMin: "1G", BackgroundHigh: "2G"}
+	m := newCgroupManager(root, base, procRoot, settings)
+	// The kernel creates the control files along with the directory.
+	makeFakeCgroup(t, root, filepath.Join(base, CgroupSliceName))
+	makeFakeCgroup(t, root, filepath.Join(base, CgroupSliceName, CgroupGameName))
+	makeFakeCgroup(t, root, filepath.Join(base, CgroupSliceName, CgroupBackgroundName))
+	err = m.setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFakeCgroupFile(t, m.path(CgroupGameName, "memory.low")); len(got) != 1 || got[0] != "6G" {
+		t.Errorf("memory.low = %v, want 6G", got)
+	}
+	if got := readFakeCgroupFile(t, m.path(CgroupBackgroundName, "memory.high")); len(got) != 1 || got[0] != "2G" {
+		t.Errorf("memory.high = %v, want 2G", got)
+	}
+
+	// Nothing happens without a game.
+	err = m.step(map[int][]int{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.moved) != 0 {
+		t.Errorf("moved = %v, want nothing without a game", m.moved)
+	}
+
+	// The game is protected and Steam is limited, only once however many steps run.
+	for i := 0; i < 2; i++ {
+		err = m.step(map[int][]int{1174180: {200}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := readFakeCgroupFile(t, m.path(CgroupGameName, "cgroup.procs")); strings.Join(got, " ") != "200" {
+		t.Errorf("game cgroup.procs = %v, want [200]", got)
+	}
+	limited := readFakeCgroupFile(t, m.path(CgroupBackgroundName, "cgroup.procs"))
+	sort.Strings(limited)
+	if strings.Join(limited, " ") != "100 101" {
+		t.Errorf("background cgroup.procs = %v, want [100 101]", limited)
+	}
+
+	// Once the game exits, Steam goes back where it came from.
+	err = os.RemoveAll(filepath.Join(procRoot, "200"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.step(map[int][]int{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := readFakeCgroupFile(t, filepath.Join(root, appScope, "cgroup.procs"))
+	sort.Strings(released)
+	if strings.Join(released, " ") != "100 101" {
+		t.Errorf("original cgroup.procs = %v, want [100 101]", released)
+	}
+	if len(m.moved) != 0 {
+		t.Errorf("moved = %v, want nothing after the game exits", m.moved)
+	}
+}
+
+func TestCgroupSettingsSet(t *testing.T) {
+	settings := CgroupSettings{GameLow: "6G", GameMin: "1G", BackgroundHigh: "2G"}
+	for _, arg := range []string{"game_low=8G", "background_high=max", "enabled=true"} {
+		key, value, _ := strings.Cut(arg, "=")
+		err := settings.set(key, value)
+		if err != nil {
+			t.Fatalf("set(%s) error = %v", arg, err)
+		}
+	}
+	want := CgroupSettings{Enabled: true, GameLow: "8G", GameMin: "1G", BackgroundHigh: "max"}
+	if settings != want {
+		t.Errorf("settings = %+v, want %+v", settings, want)
+	}
+	for _, arg := range []string{"game_low=lots", "game_min=-1", "unknown=1"} {
+		key, value, _ := strings.Cut(arg, "=")
+		copy := settings
+		if err := copy.set(key, value); err == nil {
+			t.Errorf("set(%s) should fail", arg)
+		}
+	}
+}
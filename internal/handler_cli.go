@@ -17,10 +17,19 @@
 package internal
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"os"
+
+	"golang.org/x/sys/unix"
 )
 
 func init() {
@@ -28,23 +37,47 @@ func init() {
 	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
 }
 
-// ChangeSwapSizeCLI Change the swap file size to the specified size in GB
-func ChangeSwapSizeCLI(size int, isUI bool) error {
+// ChangeSwapSizeCLI Change the swap file size to the specified size in GB. Cancelling before the file
+// is rewritten, or interrupting dd, turns swap back on at the previous size instead of leaving it off.
+func ChangeSwapSizeCLI(ctx context.Context, size int, isUI bool) error {
+	release, err := acquireLock(LockSwap)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = checkCancelled(ctx)
+	if err != nil {
+		return err
+	}
 	// Refresh creds if running with UI
 	if isUI {
 		renewSudoAuth()
 	}
+	previous := getSwapFileSizeGB()
 	// Disable swap temporarily
-	err := disableSwap()
+	err = disableSwap()
 	if err != nil {
 		return err
 	}
 
 	// Resize the file
-	err = resizeSwapFile(size)
+	err = checkCancelled(ctx)
 	if err != nil {
+		return rollbackSwapResize(err, 0)
+	}
+	err = resizeSwapFile(ctx, size)
+	if err != nil {
+		if ctx.Err() != nil {
+			return rollbackSwapResize(checkCancelled(ctx), previous)
+		}
 		return err
 	}
+	// dd may have finished just as it was cancelled.
+	err = checkCancelled(ctx)
+	if err != nil {
+		return rollbackSwapResize(err, previous)
+	}
 
 	// Refresh creds if running with UI
 	// Prevents long-running swap resized from causing issues
@@ -65,7 +98,9 @@ func ChangeSwapSizeCLI(size int, isUI bool) error {
 	return nil
 }
 
-func UseRecommendedSettings() error {
+// UseRecommendedSettings Apply every recommended setting. Each step takes the tunables lock itself, so
+// it isn't held across them.
+func UseRecommendedSettings(ctx context.Context) error {
 	// Change swap
 	CryoUtils.InfoLog.Println("开始调整交换文件大小...")
 	availableSpace, err := getFreeSpace("/home")
@@ -90,101 +125,442 @@ func UseRecommendedSettings() error {
 				size = 16
 			}
 		}
-		err = ChangeSwapSizeCLI(size, true)
+		err = ChangeSwapSizeCLI(ctx, size, true)
 		if err != nil {
 			return err
 		}
 	} else {
-		err = ChangeSwapSizeCLI(RecommendedSwapSize, true)
+		err = ChangeSwapSizeCLI(ctx, RecommendedSwapSize, true)
 		if err != nil {
 			return err
 		}
 	}
-	CryoUtils.InfoLog.Println("调整交换文件大小，改变交换性能...")
-	err = ChangeSwappiness(RecommendedSwappiness)
+	return runSettingsSteps(ctx, []settingsStep{
+		{"调整交换文件大小，改变交换性能...", func() error { return ChangeSwappiness(RecommendedSwappiness) }},
+		{"交换功能已更改，启用大页面...", SetHugePages},
+		{"启用大页面，设置主动压缩...", SetCompactionProactiveness},
+		{"主动压缩已更改，禁用大页面碎片整理...", SetDefrag},
+		{"禁用大页面碎片整理，设置页面锁非公平性...", SetPageLockUnfairness},
+		{"页面锁不公平已更改，启用共享内存...", SetShMem},
+	}, "所有设置已配置！")
+}
+
+// UseStockSettings Put every setting back to stock. Each step takes the tunables lock itself, so it
+// isn't held across them.
+func UseStockSettings(ctx context.Context) error {
+	CryoUtils.InfoLog.Println("将交换文件大小调整为 1GB...")
+	// Revert swap file size
+	err := ChangeSwapSizeCLI(ctx, DefaultSwapSize, true)
 	if err != nil {
 		return err
 	}
 
-	CryoUtils.InfoLog.Println("交换功能已更改，启用大页面...")
-	err = SetHugePages()
+	return runSettingsSteps(ctx, []settingsStep{
+		{"将交换性设置为 100...", func() error { return ChangeSwappiness(DefaultSwappiness) }},
+		{"禁用大页面...", RevertHugePages},
+		{"恢复主动压缩...", RevertCompactionProactiveness},
+		{"启用大页面碎片整理...", RevertDefrag},
+		{"恢复页面锁定的不公正性...", RevertPageLockUnfairness},
+		{"禁用 KSM...", RevertKSM},
+		{"恢复 CPU 调频设置...", RevertCPU},
+		{"禁用大页面中的共享内存...", RevertShMem},
+	}, "所有设置恢复为默认值！")
+}
+
+// One part of applying or reverting every setting.
+type settingsStep struct {
+	message string
+	apply   func() error
+}
+
+// Run the steps in order, checking for cancellation before each so a cancel stops at a step boundary
+// instead of applying everything that's left.
+func runSettingsSteps(ctx context.Context, steps []settingsStep, done string) error {
+	for _, step := range steps {
+		err := checkCancelled(ctx)
+		if err != nil {
+			return err
+		}
+		CryoUtils.InfoLog.Println(step.message)
+		err = step.apply()
+		if err != nil {
+			return err
+		}
+	}
+	CryoUtils.InfoLog.Println(done)
+	return nil
+}
+
+// TrySettingsCLI Apply settings for a number of minutes, only keeping them if confirmed on stdin.
+func TrySettingsCLI(ctx context.Context, minutes int, args []string) error {
+	// Clean up after any earlier trial that was never confirmed.
+	err := RecoverSafeApply()
 	if err != nil {
 		return err
 	}
 
-	CryoUtils.InfoLog.Println("启用大页面，设置主动压缩...")
-	err = SetCompactionProactiveness()
+	profile, err := parseProfileArgs(args)
 	if err != nil {
 		return err
 	}
 
-	CryoUtils.InfoLog.Println("主动压缩已更改，禁用大页面碎片整理...")
-	err = SetDefrag()
+	s, err := StartSafeApply(profile, time.Duration(minutes)*time.Minute, false)
 	if err != nil {
 		return err
 	}
+	fmt.Printf("已应用: %s\n输入 'yes' 保留这些设置，%d 分钟内未确认将自动恢复: ", profile, minutes)
+
+	answers := make(chan string)
+	go func() {
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				// No more input, leave it to the timer.
+				return
+			}
+			answers <- strings.ToLower(strings.TrimSpace(line))
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("\n已中断，恢复之前的设置...")
+			return s.Revert()
+		case <-s.Done():
+			fmt.Println("\n试用时间已到，之前的设置已恢复。")
+			return s.Err()
+		case answer := <-answers:
+			switch answer {
+			case "yes", "y":
+				err = s.Confirm()
+				if err != nil {
+					return err
+				}
+				fmt.Println("设置已保存。")
+				return nil
+			case "no", "n":
+				fmt.Println("恢复之前的设置...")
+				return s.Revert()
+			default:
+				fmt.Print("请输入 'yes' 或 'no': ")
+			}
+		}
+	}
+}
+
+// ListProfilesCLI Print every profile and the games assigned to them.
+func ListProfilesCLI() error {
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	for _, name := range settings.getProfileNames() {
+		profile, _ := settings.getProfile(name)
+		fmt.Printf("%s: %s\n", name, profile)
+	}
+
+	var appIDs []int
+	for appID := range settings.GameProfiles {
+		appIDs = append(appIDs, appID)
+	}
+	sort.Ints(appIDs)
+	for _, appID := range appIDs {
+		fmt.Printf("%d -> %s\n", appID, settings.GameProfiles[appID])
+	}
+	return nil
+}
+
+// SaveProfileCLI Save a named profile, accepts the same arguments as 'try'.
+func SaveProfileCLI(name string, args []string) error {
+	if name == "recommended" || name == "stock" {
+		return fmt.Errorf("无法覆盖内置配置文件: %s", name)
+	}
+	profile, err := parseProfileArgs(args)
+	if err != nil {
+		return err
+	}
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	settings.Profiles[name] = profile
+	CryoUtils.InfoLog.Println("保存配置文件", name, profile.String())
+	return saveSettings(settings)
+}
+
+// DeleteProfileCLI Delete a named profile, as long as no game uses it.
+func DeleteProfileCLI(name string) error {
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	if _, ok := settings.Profiles[name]; !ok {
+		return fmt.Errorf("找不到配置文件: %s", name)
+	}
+	for appID, profile := range settings.GameProfiles {
+		if profile == name {
+			return fmt.Errorf("配置文件 %s 仍被游戏 %d 使用", name, appID)
+		}
+	}
+	delete(settings.Profiles, name)
+	CryoUtils.InfoLog.Println("删除配置文件", name)
+	return saveSettings(settings)
+}
 
-	CryoUtils.InfoLog.Println("禁用大页面碎片整理，设置页面锁非公平性...")
-	err = SetPageLockUnfairness()
+// AssignGameProfileCLI Use a profile while a game is running, 'none' removes the assignment.
+func AssignGameProfileCLI(appID int, name string) error {
+	settings, err := loadSettings()
 	if err != nil {
 		return err
 	}
+	if name == "none" {
+		delete(settings.GameProfiles, appID)
+		CryoUtils.InfoLog.Println("游戏", appID, "不再使用配置文件")
+		return saveSettings(settings)
+	}
+	_, err = settings.getProfile(name)
+	if err != nil {
+		return err
+	}
+	settings.GameProfiles[appID] = name
+	CryoUtils.InfoLog.Println("游戏", appID, "将使用配置文件", name)
+	return saveSettings(settings)
+}
 
-	CryoUtils.InfoLog.Println("页面锁不公平已更改，启用共享内存...")
-	err = SetShMem()
+// ThpStatsCLI Print THP and compaction statistics. Accepts 'start', 'show', 'stop' or a window in seconds.
+func ThpStatsCLI(ctx context.Context, args []string) error {
+	action := "show"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	var report ThpReport
+	var err error
+	switch action {
+	case "start":
+		err = StartThpStats()
+		if err != nil {
+			return err
+		}
+		fmt.Println("已开始记录，游戏结束后运行 'stats thp stop' 查看结果。")
+		return nil
+	case "show":
+		report, err = GetThpStatsSinceStart()
+	case "stop":
+		report, err = StopThpStats()
+	default:
+		seconds, convErr := strconv.Atoi(action)
+		if convErr != nil || seconds <= 0 {
+			return fmt.Errorf("无效的参数: %s", action)
+		}
+		fmt.Printf("记录 %d 秒，按 Ctrl-C 提前结束...\n", seconds)
+		report, err = GetThpStatsOverWindow(time.Duration(seconds)*time.Second, ctx.Done())
+	}
 	if err != nil {
 		return err
 	}
+	fmt.Println(report)
+	return nil
+}
+
+// SwapWriteStatsCLI Print how much has been written to swap. Accepts 'show' or 'sample'.
+func SwapWriteStatsCLI(args []string) error {
+	action := "show"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "show":
+	case "sample":
+		err := RecordSwapWriteSample()
+		if err != nil {
+			return err
+		}
+		fmt.Println("已记录交换写入量。")
+		return nil
+	default:
+		return fmt.Errorf("无效的参数: %s", action)
+	}
 
-	CryoUtils.InfoLog.Println("所有设置已配置！")
+	report, err := GetSwapWriteReport()
+	if err != nil {
+		return err
+	}
+	fmt.Println(report)
+	if report.Samples < 2 {
+		fmt.Println("提示: 运行 'watch' 守护进程或保持程序打开以持续记录交换写入量。")
+	}
 	return nil
 }
 
-func UseStockSettings() error {
-	CryoUtils.InfoLog.Println("将交换文件大小调整为 1GB...")
-	// Revert swap file size
-	err := ChangeSwapSizeCLI(DefaultSwapSize, true)
+// CompactMemoryCLI Compact memory now and print the change in free huge page blocks.
+func CompactMemoryCLI() error {
+	result, err := CompactMemory()
 	if err != nil {
 		return err
 	}
+	fmt.Println(result)
+	return nil
+}
 
-	CryoUtils.InfoLog.Println("将交换性设置为 100...")
-	// Revert swappiness
-	err = ChangeSwappiness(DefaultSwappiness)
+// DropCachesCLI Drop the page cache and print the change in free huge page blocks.
+func DropCachesCLI() error {
+	result, err := DropCaches()
 	if err != nil {
 		return err
 	}
+	fmt.Println(result)
+	return nil
+}
 
-	CryoUtils.InfoLog.Println("禁用大页面...")
-	// Enable HugePages
-	err = RevertHugePages()
+// PrelaunchCLI Compact memory, and optionally drop the page cache, then replace this process with the game.
+// Meant for Steam launch options, ex: "cryoutilities prelaunch -- %command%"
+func PrelaunchCLI(dropCaches bool, command []string) error {
+	if len(command) == 0 {
+		return fmt.Errorf("没有指定要启动的命令")
+	}
+	path, err := exec.LookPath(command[0])
 	if err != nil {
 		return err
 	}
 
-	CryoUtils.InfoLog.Println("恢复主动压缩...")
-	err = RevertCompactionProactiveness()
+	// Never stop the game from launching, the preparation is only a bonus.
+	if dropCaches {
+		_, err = DropCaches()
+		if err != nil {
+			CryoUtils.ErrorLog.Println("启动前清除页面缓存失败:", err)
+		}
+	}
+	_, err = CompactMemory()
+	if err != nil {
+		CryoUtils.ErrorLog.Println("启动前压缩内存失败:", err)
+	}
+
+	return unix.Exec(path, command, os.Environ())
+}
+
+// MemoryBenchCLI Run the memory benchmark and print the report.
+func MemoryBenchCLI(ctx context.Context, opts BenchOptions) error {
+	fmt.Println("正在运行内存基准测试，可能需要几分钟，按 Ctrl-C 取消...")
+	report, err := RunMemoryBenchmark(ctx, opts)
 	if err != nil {
 		return err
 	}
+	fmt.Println(report)
+	return nil
+}
 
-	CryoUtils.InfoLog.Println("启用大页面碎片整理...")
-	err = RevertDefrag()
+// KSMCLI Show KSM statistics, or change it. Accepts 'on', 'off' or 'tune' followed by param=value pairs.
+func KSMCLI(args []string) error {
+	action := "show"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	var err error
+	switch action {
+	case "show":
+	case "on":
+		err = SetKSM()
+	case "off":
+		err = RevertKSM()
+	case "tune":
+		var pagesToScan, sleepMillisecs string
+		for _, arg := range args[1:] {
+			key, value, found := strings.Cut(arg, "=")
+			switch {
+			case found && key == "pages_to_scan":
+				pagesToScan = value
+			case found && key == "sleep_millisecs":
+				sleepMillisecs = value
+			default:
+				return fmt.Errorf("无效的参数: %s", arg)
+			}
+		}
+		err = SetKSMTuning(pagesToScan, sleepMillisecs)
+	default:
+		return fmt.Errorf("无效的参数: %s", action)
+	}
 	if err != nil {
 		return err
 	}
 
-	CryoUtils.InfoLog.Println("恢复页面锁定的不公正性...")
-	err = RevertPageLockUnfairness()
+	stats, err := getKSMStats(SysRoot)
 	if err != nil {
 		return err
 	}
+	fmt.Println(stats)
+	return nil
+}
 
-	CryoUtils.InfoLog.Println("禁用大页面中的共享内存...")
-	err = RevertShMem()
+// CPUCLI Show the CPU frequency settings, or change them on every policy.
+// Accepts 'recommended', 'stock', 'governor', 'preference', 'min-freq' or 'max-freq' with a value, or 'smt on|off'.
+func CPUCLI(args []string) error {
+	var err error
+	if len(args) == 1 {
+		switch args[0] {
+		case "recommended":
+			err = SetCPUProfile(getRecommendedCPUProfile())
+		case "stock":
+			err = RevertCPU()
+		default:
+			return fmt.Errorf("无效的参数: %s", args[0])
+		}
+	} else if len(args) == 2 {
+		setting := strings.ReplaceAll(args[0], "-", "_")
+		var profile TunableProfile
+		switch setting {
+		case "governor", "preference", "min_freq", "max_freq":
+			profile, err = getAllPoliciesProfile(SysRoot, setting, args[1])
+		case "smt":
+			profile = TunableProfile{"smt": args[1]}
+		default:
+			return fmt.Errorf("无效的参数: %s", args[0])
+		}
+		if err == nil {
+			err = SetCPUProfile(profile)
+		}
+	} else if len(args) > 2 {
+		return fmt.Errorf("参数过多")
+	}
 	if err != nil {
-		CryoUtils.InfoLog.Println("所有设置恢复为默认值！")
+		return err
 	}
 
+	status, err := getCPUStatus(SysRoot)
+	if err != nil {
+		return err
+	}
+	fmt.Println(status)
 	return nil
 }
+
+// TopSwapCLI Print the groups of processes using the most swap, and the processes within them.
+func TopSwapCLI(count int) error {
+	processes, err := getProcessMemoryList(ProcRoot)
+	if err != nil {
+		return err
+	}
+	groups := groupProcessMemory(processes)
+	if count > 0 && len(groups) > count {
+		groups = groups[:count]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "分组\tPID\t进程\t交换\tRSS\t匿名 RSS")
+	for _, group := range groups {
+		fmt.Fprintf(w, "%s\t\t\t%s\t%s\t%s\n", group.Name,
+			getHumanMemorySize(group.Swap), getHumanMemorySize(group.RSS), getHumanMemorySize(group.RssAnon))
+		for _, process := range group.Processes {
+			if process.Swap == 0 && len(group.Processes) > 1 {
+				continue
+			}
+			fmt.Fprintf(w, "\t%d\t%s\t%s\t%s\t%s\n", process.PID, process.Name,
+				getHumanMemorySize(process.Swap), getHumanMemorySize(process.RSS), getHumanMemorySize(process.RssAnon))
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,290 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// The cpufreq files behind each per-policy parameter. The names sort with the governor first,
+// since some drivers refuse a preference until the governor allows one.
+var cpuPolicyFiles = map[string]string{
+	"governor":   "scaling_governor",
+	"max_freq":   "scaling_max_freq",
+	"min_freq":   "scaling_min_freq",
+	"preference": "energy_performance_preference",
+}
+
+// CPUPolicy The frequency settings of a group of CPUs that scale together.
+type CPUPolicy struct {
+	Name        string
+	Governor    string
+	Preference  string
+	MinFreq     int
+	MaxFreq     int
+	HardwareMin int
+	HardwareMax int
+	Governors   []string
+	Preferences []string
+}
+
+// The UnitMatrix parameters added by registerCPUParams, the only ones RevertCPU touches.
+var cpuParams = map[string]bool{}
+
+// Get the UnitMatrix parameter for a setting of a policy, ex: cpu_policy0_governor.
+func cpuParam(policy string, setting string) string {
+	return "cpu_" + policy + "_" + setting
+}
+
+// Read every cpufreq policy, sorted by name.
+func getCPUPolicies(sysRoot string) ([]CPUPolicy, error) {
+	matches, err := filepath.Glob(filepath.Join(sysRoot, CPUFreqDirectory, "policy*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	var policies []CPUPolicy
+	for _, dir := range matches {
+		read := func(name string) string {
+			data, _ := os.ReadFile(filepath.Join(dir, name))
+			return strings.TrimSpace(string(data))
+		}
+		readInt := func(name string) int {
+			value, _ := strconv.Atoi(read(name))
+			return value
+		}
+		policies = append(policies, CPUPolicy{
+			Name:        filepath.Base(dir),
+			Governor:    read("scaling_governor"),
+			Preference:  read("energy_performance_preference"),
+			MinFreq:     readInt("scaling_min_freq"),
+			MaxFreq:     readInt("scaling_max_freq"),
+			HardwareMin: readInt("cpuinfo_min_freq"),
+			HardwareMax: readInt("cpuinfo_max_freq"),
+			Governors:   strings.Fields(read("scaling_available_governors")),
+			Preferences: strings.Fields(read("energy_performance_available_preferences")),
+		})
+	}
+	if len(policies) == 0 {
+		return nil, fmt.Errorf("找不到 cpufreq 策略，内核可能未启用 CPU 调频")
+	}
+	return policies, nil
+}
+
+// Get whether SMT is on, or "" if it can't be changed on this system.
+func getSMTState(sysRoot string) string {
+	data, err := os.ReadFile(filepath.Join(sysRoot, SMTControlPath))
+	if err != nil {
+		return ""
+	}
+	state := strings.TrimSpace(string(data))
+	if state == "notsupported" || state == "notimplemented" {
+		return ""
+	}
+	return state
+}
+
+// RegisterCPUParams Add this system's CPU parameters to UnitMatrix, once at startup before anything reads it.
+func RegisterCPUParams() {
+	registerCPUParams(SysRoot)
+}
+
+// Add the parameters of every cpufreq policy and SMT to UnitMatrix, since how many there are depends on the CPU.
+func registerCPUParams(sysRoot string) {
+	register := func(param string, path string) {
+		UnitMatrix[param] = path
+		cpuParams[param] = true
+	}
+	matches, _ := filepath.Glob(filepath.Join(sysRoot, CPUFreqDirectory, "policy*"))
+	for _, dir := range matches {
+		for setting, file := range cpuPolicyFiles {
+			path := filepath.Join(dir, file)
+			if doesFileExist(path) {
+				register(cpuParam(filepath.Base(dir), setting), path)
+			}
+		}
+	}
+	if getSMTState(sysRoot) != "" {
+		register("smt", filepath.Join(sysRoot, SMTControlPath))
+	}
+}
+
+// Check a CPU value against what the kernel says it accepts, other parameters are left alone.
+func validateCPUValue(param string, value string) error {
+	path, ok := UnitMatrix[param]
+	if !ok {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	readList := func(name string) []string {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		return strings.Fields(string(data))
+	}
+	readInt := func(name string) int {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		number, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		return number
+	}
+
+	switch {
+	case param == "smt":
+		if value != "on" && value != "off" {
+			return fmt.Errorf("无效的 SMT 状态: %s，可用: on off", value)
+		}
+	case !strings.HasPrefix(param, "cpu_"):
+		return nil
+	case strings.HasSuffix(param, "_governor"):
+		available := readList("scaling_available_governors")
+		if !contains(available, value) {
+			return fmt.Errorf("无效的调速器: %s，可用: %s", value, strings.Join(available, " "))
+		}
+	case strings.HasSuffix(param, "_preference"):
+		available := readList("energy_performance_available_preferences")
+		if !contains(available, value) {
+			return fmt.Errorf("无效的能耗偏好: %s，可用: %s", value, strings.Join(available, " "))
+		}
+	case strings.HasSuffix(param, "_freq"):
+		freq, err := strconv.Atoi(value)
+		low, high := readInt("cpuinfo_min_freq"), readInt("cpuinfo_max_freq")
+		if err != nil || freq < low || (high > 0 && freq > high) {
+			return fmt.Errorf("无效的频率: %s，可用范围: %d - %d kHz", value, low, high)
+		}
+	}
+	return nil
+}
+
+// Build a CPU profile for every policy, leaving out what the driver doesn't offer.
+func getCPUProfile(sysRoot string, governor string, preference string, smt string) TunableProfile {
+	profile := TunableProfile{}
+	policies, err := getCPUPolicies(sysRoot)
+	if err != nil {
+		return profile
+	}
+	for _, policy := range policies {
+		if contains(policy.Governors, governor) {
+			profile[cpuParam(policy.Name, "governor")] = governor
+		}
+		if contains(policy.Preferences, preference) {
+			profile[cpuParam(policy.Name, "preference")] = preference
+		}
+		if policy.HardwareMax > 0 {
+			profile[cpuParam(policy.Name, "min_freq")] = strconv.Itoa(policy.HardwareMin)
+			profile[cpuParam(policy.Name, "max_freq")] = strconv.Itoa(policy.HardwareMax)
+		}
+	}
+	if getSMTState(sysRoot) != "" {
+		profile["smt"] = smt
+	}
+	return profile
+}
+
+// Get the CPU settings matching Cryo's recommendations, the full frequency range with a performance preference.
+func getRecommendedCPUProfile() TunableProfile {
+	return getCPUProfile(SysRoot, RecommendedCPUGovernor, RecommendedCPUPreference, DefaultSMT)
+}
+
+// Get the CPU settings matching Valve's defaults.
+func getStockCPUProfile() TunableProfile {
+	return getCPUProfile(SysRoot, DefaultCPUGovernor, DefaultCPUPreference, DefaultSMT)
+}
+
+// Set a value on every policy that has it, ex: "governor" on policy0 to policy3.
+func getAllPoliciesProfile(sysRoot string, setting string, value string) (TunableProfile, error) {
+	policies, err := getCPUPolicies(sysRoot)
+	if err != nil {
+		return nil, err
+	}
+	profile := TunableProfile{}
+	for _, policy := range policies {
+		param := cpuParam(policy.Name, setting)
+		if _, ok := UnitMatrix[param]; ok {
+			profile[param] = value
+		}
+	}
+	if len(profile) == 0 {
+		return nil, fmt.Errorf("此 CPU 不支持 %s", setting)
+	}
+	return profile, nil
+}
+
+// SetCPUProfile Apply CPU settings now and keep them across reboots.
+func SetCPUProfile(profile TunableProfile) error {
+	CryoUtils.InfoLog.Println("设置 CPU 参数:", profile.String())
+	err := applyProfileLive(profile)
+	if err != nil {
+		return err
+	}
+	return persistProfile(profile)
+}
+
+// RevertCPU Go back to Valve's CPU settings and remove the unit file of every CPU parameter on this system.
+func RevertCPU() error {
+	stock := getStockCPUProfile()
+	if len(stock) != 0 {
+		err := applyProfileLive(stock)
+		if err != nil {
+			return err
+		}
+	}
+	return withLock(LockTunables, func() error {
+		for param := range cpuParams {
+			err := removeUnitFile(param)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Format the policies and SMT state for display.
+func getCPUStatus(sysRoot string) (string, error) {
+	policies, err := getCPUPolicies(sysRoot)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, policy := range policies {
+		fmt.Fprintf(&b, "%s: 调速器 %s", policy.Name, policy.Governor)
+		if policy.Preference != "" {
+			fmt.Fprintf(&b, "  能耗偏好 %s", policy.Preference)
+		}
+		fmt.Fprintf(&b, "  频率 %d - %d MHz (硬件 %d - %d MHz)\n",
+			policy.MinFreq/1000, policy.MaxFreq/1000, policy.HardwareMin/1000, policy.HardwareMax/1000)
+	}
+	if smt := getSMTState(sysRoot); smt != "" {
+		fmt.Fprintf(&b, "SMT: %s\n", smt)
+	}
+	if len(policies[0].Governors) > 0 {
+		fmt.Fprintf(&b, "可用调速器: %s\n", strings.Join(policies[0].Governors, " "))
+	}
+	if len(policies[0].Preferences) > 0 {
+		fmt.Fprintf(&b, "可用能耗偏好: %s\n", strings.Join(policies[0].Preferences, " "))
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create a sysfs with one policy offering the usual amd-pstate-epp choices.
+func makeFakeCPUFreq(t *testing.T) string {
+	t.Helper()
+	sysRoot := t.TempDir()
+	dir := filepath.Join(sysRoot, CPUFreqDirectory, "policy0")
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"scaling_governor":                         "powersave",
+		"scaling_available_governors":              "performance powersave",
+		"energy_performance_preference":            "balance_performance",
+		"energy_performance_available_preferences": "default performance balance_performance balance_power power",
+		"scaling_min_freq":                         "400000",
+		"scaling_max_freq":                         "3500000",
+		"cpuinfo_min_freq":                         "400000",
+		"cpuinfo_max_freq":                         "3500000",
+	}
+	for name, contents := range files {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(contents+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.MkdirAll(filepath.Join(sysRoot, filepath.Dir(SMTControlPath)), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(sysRoot, SMTControlPath), []byte("on\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registerCPUParams(sysRoot)
+	t.Cleanup(func() {
+		for param := range cpuParams {
+			delete(UnitMatrix, param)
+			delete(cpuParams, param)
+		}
+	})
+	return sysRoot
+}
+
+func TestValidateCPUValue(t *testing.T) {
+	makeFakeCPUFreq(t)
+	tests := []struct {
+		param   string
+		value   string
+		wantErr bool
+	}{
+		{"cpu_policy0_governor", "performance", false},
+		{"cpu_policy0_governor", "schedutil", true},
+		{"cpu_policy0_preference", "balance_power", false},
+		{"cpu_policy0_preference", "turbo", true},
+		{"cpu_policy0_max_freq", "2800000", false},
+		{"cpu_policy0_max_freq", "5000000", true},
+		{"cpu_policy0_min_freq", "fast", true},
+		{"smt", "off", false},
+		{"smt", "forceoff", true},
+		{"swappiness", "anything", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.param+"="+tt.value, func(t *testing.T) {
+			err := validateCPUValue(tt.param, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCPUValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetCPUProfile(t *testing.T) {
+	sysRoot := makeFakeCPUFreq(t)
+	// schedutil isn't offered by this driver, so the governor is left alone.
+	got := getCPUProfile(sysRoot, "schedutil", "performance", "on")
+	want := TunableProfile{
+		"cpu_policy0_preference": "performance",
+		"cpu_policy0_min_freq":   "400000",
+		"cpu_policy0_max_freq":   "3500000",
+		"smt":                    "on",
+	}
+	if got.String() != want.String() {
+		t.Errorf("getCPUProfile() = %v, want %v", got, want)
+	}
+	if err := got.validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+
+	all, err := getAllPoliciesProfile(sysRoot, "governor", "performance")
+	if err != nil || all.String() != "cpu_policy0_governor=performance" {
+		t.Errorf("getAllPoliciesProfile() = %v, %v", all, err)
+	}
+}
+
+func TestRevertCPU(t *testing.T) {
+	sysRoot := makeFakeCPUFreq(t)
+	oldSysRoot := SysRoot
+	SysRoot = sysRoot
+	t.Cleanup(func() { SysRoot = oldSysRoot })
+	useLockDirectory(t, 0)
+	// Some other feature's parameter that happens to look like a CPU one.
+	UnitMatrix["cpu_boost"] = "/sys/devices/system/cpu/cpufreq/boost"
+	t.Cleanup(func() { delete(UnitMatrix, "cpu_boost") })
+
+	governorUnit := filepath.Join(TmpFilesRoot, "cpu_policy0_governor.conf")
+	boostUnit := filepath.Join(TmpFilesRoot, "cpu_boost.conf")
+	fake := useFakeExecutor(t, map[string]string{governorUnit: "", boostUnit: ""})
+	err := RevertCPU()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.Files[governorUnit]; ok {
+		t.Errorf("unit file of a registered parameter still there after RevertCPU()")
+	}
+	if _, ok := fake.Files[boostUnit]; !ok {
+		t.Errorf("RevertCPU() removed the unit file of a parameter it didn't register")
+	}
+}
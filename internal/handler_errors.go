@@ -0,0 +1,156 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// Arguments like password=..., whose value is never shown.
+var secretArgRegex = regexp.MustCompile(`(?i)^(-*[a-z_-]*(?:pass|token|secret)[a-z_-]*=).+$`)
+
+// CommandError A command that failed, keeping what's needed to tell why.
+type CommandError struct {
+	// Op What the command was run for, ex: "禁用交换", empty until the handler says.
+	Op string
+	// Args The command line, with secrets redacted.
+	Args     []string
+	ExitCode int
+	// Stderr The last lines the command printed, with stdout too when they were combined.
+	Stderr string
+	Err    error
+}
+
+// Make an error for a failed command. Without output, the stderr kept by exec's Output is used.
+func newCommandError(args []string, output []byte, err error) *CommandError {
+	var exitErr *exec.ExitError
+	if len(output) == 0 && errors.As(err, &exitErr) {
+		output = exitErr.Stderr
+	}
+	return &CommandError{
+		Args:     redactCommandArgs(args),
+		ExitCode: getExitCode(err),
+		Stderr:   getOutputTail(string(output), CommandErrorTailLines),
+		Err:      err,
+	}
+}
+
+func (e *CommandError) Error() string {
+	name := "命令"
+	if len(e.Args) > 0 {
+		name = e.Args[0]
+	}
+	message := fmt.Sprintf("%s 失败，退出码 %d", name, e.ExitCode)
+	if e.ExitCode == -1 {
+		message = fmt.Sprintf("%s 失败: %v", name, e.Err)
+	}
+	if e.Op != "" {
+		message = e.Op + "时出错: " + message
+	}
+	// The last line is usually the reason, the rest is in the details.
+	if lines := strings.Split(e.Stderr, "\n"); e.Stderr != "" {
+		message += ": " + lines[len(lines)-1]
+	}
+	return message
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Details Everything known about the failure, for the error dialog and bug reports.
+func (e *CommandError) Details() string {
+	var b strings.Builder
+	if e.Op != "" {
+		fmt.Fprintf(&b, "操作: %s\n", e.Op)
+	}
+	fmt.Fprintf(&b, "命令: %s\n", strings.Join(e.Args, " "))
+	fmt.Fprintf(&b, "退出码: %d\n", e.ExitCode)
+	if e.Err != nil {
+		fmt.Fprintf(&b, "错误: %v\n", e.Err)
+	}
+	if e.Stderr != "" {
+		fmt.Fprintf(&b, "输出:\n%s\n", e.Stderr)
+	}
+	return b.String()
+}
+
+// ErrorDetails Everything known about a failed command behind an error, or "" if no command failed.
+func ErrorDetails(err error) string {
+	var commandErr *CommandError
+	if errors.As(err, &commandErr) {
+		return commandErr.Details()
+	}
+	return ""
+}
+
+// Say what a failed command was for. Errors that didn't come from a command are wrapped with it instead.
+func withCommandOp(op string, err error) error {
+	var commandErr *CommandError
+	if errors.As(err, &commandErr) {
+		withOp := *commandErr
+		withOp.Op = op
+		return &withOp
+	}
+	return fmt.Errorf("%s时出错: %w", op, err)
+}
+
+// Run a command as the current user, returning its output or a CommandError.
+func runCommand(name string, args ...string) ([]byte, error) {
+	output, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return output, newCommandError(append([]string{name}, args...), nil, err)
+	}
+	return output, nil
+}
+
+// Hide anything secret in a command line: the sudo password and values of arguments like password=.
+func redactCommandArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		if CryoUtils.UserPassword != "" {
+			arg = strings.ReplaceAll(arg, CryoUtils.UserPassword, "******")
+		}
+		redacted[i] = secretArgRegex.ReplaceAllString(arg, "${1}******")
+	}
+	return redacted
+}
+
+// Get the last lines of a command's output. dd redraws its progress with carriage returns, so those
+// count as line breaks too.
+func getOutputTail(output string, lines int) string {
+	var kept []string
+	for _, line := range strings.FieldsFunc(output, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		if strings.TrimSpace(line) != "" {
+			kept = append(kept, strings.TrimRight(line, " \t"))
+		}
+	}
+	if len(kept) > lines {
+		kept = kept[len(kept)-lines:]
+	}
+	return strings.Join(kept, "\n")
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandError(t *testing.T) {
+	_, err := RootExecutor{}.Run(context.Background(), "sh", "-c", "echo 'writing 1G'; echo 'dd: error writing: No space left on device' >&2; exit 3")
+	var commandErr *CommandError
+	if !errors.As(err, &commandErr) {
+		t.Fatalf("Run() = %v, want a CommandError", err)
+	}
+	if commandErr.ExitCode != 3 || commandErr.Stderr != "writing 1G\ndd: error writing: No space left on device" {
+		t.Errorf("CommandError = %+v", commandErr)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("CommandError doesn't unwrap to the exec error")
+	}
+
+	err = withCommandOp("调整交换文件大小", err)
+	want := "调整交换文件大小时出错: sh 失败，退出码 3: dd: error writing: No space left on device"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if commandErr.Op != "" {
+		t.Errorf("withCommandOp() changed the original error")
+	}
+	if details := ErrorDetails(err); !strings.Contains(details, "操作: 调整交换文件大小\n") ||
+		!strings.Contains(details, "退出码: 3\n") || !strings.Contains(details, "No space left") {
+		t.Errorf("ErrorDetails() = %q", details)
+	}
+
+	// sudo complains on stderr, which Output would otherwise only keep in the exec error.
+	_, err = CommandExecutor{Command: []string{"sh", "-c", "echo 'sudo: a password is required' >&2; exit 1", "sh"}}.
+		ReadFile("/proc/sys/vm/swappiness")
+	if !errors.As(err, &commandErr) || commandErr.Stderr != "sudo: a password is required" {
+		t.Errorf("ReadFile() = %v, want sudo's stderr kept", err)
+	}
+
+	// Errors that didn't come from a command are still wrapped.
+	err = withCommandOp("写入文件", os.ErrNotExist)
+	if !errors.Is(err, os.ErrNotExist) || ErrorDetails(err) != "" {
+		t.Errorf("withCommandOp() on a plain error = %v", err)
+	}
+}
+
+func TestRedactCommandArgs(t *testing.T) {
+	oldPassword := CryoUtils.UserPassword
+	CryoUtils.UserPassword = "hunter2"
+	t.Cleanup(func() { CryoUtils.UserPassword = oldPassword })
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"swapon", "/home/swapfile"}, []string{"swapon", "/home/swapfile"}},
+		{[]string{"sh", "-c", "echo hunter2 | sudo -S true"}, []string{"sh", "-c", "echo ****** | sudo -S true"}},
+		{[]string{"tool", "--password=abc", "token=xyz", "count=4"}, []string{"tool", "--password=******", "token=******", "count=4"}},
+	}
+	for _, tt := range tests {
+		if got := redactCommandArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("redactCommandArgs(%v) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestGetOutputTail(t *testing.T) {
+	tests := []struct {
+		output string
+		lines  int
+		want   string
+	}{
+		{"", 3, ""},
+		{"one\ntwo\n", 3, "one\ntwo"},
+		{"one\ntwo\nthree\nfour\n", 2, "three\nfour"},
+		// dd's progress, redrawn with carriage returns
+		{"1073741824 bytes\r2147483648 bytes\r\ndd: error writing\n", 2, "2147483648 bytes\ndd: error writing"},
+		{"a\n\n  \nb  \n", 5, "a\nb"},
+	}
+	for _, tt := range tests {
+		if got := getOutputTail(tt.output, tt.lines); got != tt.want {
+			t.Errorf("getOutputTail(%q, %d) = %q, want %q", tt.output, tt.lines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// Executor Does everything that needs root. The handlers only go through this, so how root is
+// reached (already root, sudo, doas, run0 or the privileged helper) doesn't matter to them.
+type Executor interface {
+	// ReadFile Read a file only root can read.
+	ReadFile(path string) ([]byte, error)
+	// WriteFile Replace the contents of a file, or write a kernel value.
+	WriteFile(path string, data []byte) error
+	// RemoveFile Remove a file, it already being gone isn't an error.
+	RemoveFile(path string) error
+	// Run Run a command as root, returning its combined output. Cancelling ctx stops the command.
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// RootExecutor Does everything directly, for when the process is already root.
+type RootExecutor struct{}
+
+func (RootExecutor) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (RootExecutor) WriteFile(path string, data []byte) error {
+	return os.WriteFile(path, data, 0644)
+}
+
+func (RootExecutor) RemoveFile(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (RootExecutor) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return output, newCommandError(append([]string{name}, args...), output, err)
+	}
+	return output, nil
+}
+
+// CommandExecutor Runs everything through a privilege escalation command, ex: sudo, doas or run0.
+type CommandExecutor struct {
+	Command []string
+}
+
+// Get the command that runs name with args as root, stopped when ctx is cancelled.
+func (e CommandExecutor) command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	argv := append(append(append([]string{}, e.Command[1:]...), name), args...)
+	cmd := exec.CommandContext(ctx, e.Command[0], argv...)
+	// Killing sudo would leave the command running, sudo passes SIGTERM on to it instead.
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	// Don't wait forever on one that ignores it.
+	cmd.WaitDelay = PrivilegeCommandStopDelay
+	return cmd
+}
+
+// Run a command for its stdout, keeping its stderr, ex: sudo's complaints, in the error.
+func (e CommandExecutor) output(cmd *exec.Cmd) ([]byte, error) {
+	output, err := cmd.Output()
+	if err != nil {
+		return output, newCommandError(cmd.Args, nil, err)
+	}
+	return output, nil
+}
+
+func (e CommandExecutor) ReadFile(path string) ([]byte, error) {
+	return e.output(e.command(context.Background(), "cat", path))
+}
+
+func (e CommandExecutor) WriteFile(path string, data []byte) error {
+	cmd := e.command(context.Background(), "tee", path)
+	cmd.Stdin = bytes.NewReader(data)
+	_, err := e.output(cmd)
+	return err
+}
+
+func (e CommandExecutor) RemoveFile(path string) error {
+	_, err := e.output(e.command(context.Background(), "rm", "-f", path))
+	return err
+}
+
+func (e CommandExecutor) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := e.command(ctx, name, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return output, newCommandError(cmd.Args, output, err)
+	}
+	return output, nil
+}
+
+// HelperExecutor Asks the privileged helper, which refuses anything CryoUtilities doesn't do itself.
+type HelperExecutor struct{}
+
+func (HelperExecutor) ReadFile(path string) ([]byte, error) {
+	output, err := callHelper(HelperRequest{Op: "read", Path: path})
+	return []byte(output), err
+}
+
+func (HelperExecutor) WriteFile(path string, data []byte) error {
+	_, err := callHelper(HelperRequest{Op: "write", Path: path, Data: string(data), Caller: CryoUtils.Caller})
+	return err
+}
+
+func (HelperExecutor) RemoveFile(path string) error {
+	_, err := callHelper(HelperRequest{Op: "remove", Path: path, Caller: CryoUtils.Caller})
+	return err
+}
+
+func (HelperExecutor) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, err := callHelperContext(ctx, HelperRequest{Op: "run", Args: append([]string{name}, args...),
+		Caller: CryoUtils.Caller})
+	return []byte(output), err
+}
+
+// FakeExecutor Records what would have been done as root instead of doing it, for tests.
+type FakeExecutor struct {
+	mu sync.Mutex
+	// Files What ReadFile returns, and where WriteFile and RemoveFile land.
+	Files map[string]string
+	// Outputs What Run returns, by command name.
+	Outputs map[string]string
+	// Calls Every call in order, ex: "write /proc/sys/vm/swappiness 1\n" or "run swapoff -a".
+	Calls []string
+	// Err Returned by every call when set.
+	Err error
+	// Errs Returned by calls starting with the key, ex: "write /proc/sys/vm/swappiness" to fail one value.
+	Errs map[string]error
+}
+
+func (f *FakeExecutor) record(call string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls = append(f.Calls, call)
+	if f.Files == nil {
+		f.Files = map[string]string{}
+	}
+	for prefix, err := range f.Errs {
+		if strings.HasPrefix(call, prefix) {
+			return err
+		}
+	}
+	return f.Err
+}
+
+func (f *FakeExecutor) ReadFile(path string) ([]byte, error) {
+	if err := f.record("read " + path); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.Files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(data), nil
+}
+
+func (f *FakeExecutor) WriteFile(path string, data []byte) error {
+	if err := f.record("write " + path + " " + string(data)); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Files[path] = string(data)
+	return nil
+}
+
+func (f *FakeExecutor) RemoveFile(path string) error {
+	if err := f.record("remove " + path); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.Files, path)
+	return nil
+}
+
+func (f *FakeExecutor) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if err := f.record("run " + strings.Join(append([]string{name}, args...), " ")); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return []byte(f.Outputs[name]), nil
+}
+
+// Pick how to reach root: the helper if it's running, directly if already root, otherwise
+// the first privilege escalation command that's installed.
+func detectExecutor() Executor {
+	if _, err := callHelper(HelperRequest{Op: "ping"}); err == nil {
+		CryoUtils.InfoLog.Println("通过特权助手执行特权操作")
+		return HelperExecutor{}
+	}
+	if os.Geteuid() == 0 {
+		return RootExecutor{}
+	}
+	for _, command := range PrivilegeCommands {
+		if _, err := exec.LookPath(command); err == nil {
+			CryoUtils.InfoLog.Println("通过", command, "执行特权操作")
+			return CommandExecutor{Command: []string{command}}
+		}
+	}
+	CryoUtils.ErrorLog.Println("找不到", PrivilegeCommands, "中的任何命令")
+	return CommandExecutor{Command: []string{PrivilegeCommands[0]}}
+}
+
+// Guards CryoUtils.Executor, the GUI's background goroutines may pick it at the same time.
+var executorMutex sync.Mutex
+
+// Get the executor for privileged operations, picking one on first use. Everything it changes is audited.
+func getExecutor() Executor {
+	executor := currentExecutor()
+	// The helper audits everything it's asked itself, including what it refuses.
+	if _, ok := executor.(HelperExecutor); ok {
+		return executor
+	}
+	return AuditExecutor{Next: executor}
+}
+
+// Get how privileged operations reach root, picking it on first use.
+func currentExecutor() Executor {
+	executorMutex.Lock()
+	defer executorMutex.Unlock()
+	if CryoUtils.Executor == nil {
+		CryoUtils.Executor = detectExecutor()
+	}
+	return CryoUtils.Executor
+}
+
+// Change how privileged operations reach root from now on.
+func setExecutor(executor Executor) {
+	executorMutex.Lock()
+	defer executorMutex.Unlock()
+	CryoUtils.Executor = executor
+}
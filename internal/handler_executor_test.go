@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func useFakeExecutor(t *testing.T, files map[string]string) *FakeExecutor {
+	fake := &FakeExecutor{Files: files}
+	oldExecutor, oldSwapFile, oldAuditPath, oldLockDirectory := CryoUtils.Executor, CryoUtils.SwapFileLocation,
+		AuditLogPath, LockDirectory
+	CryoUtils.Executor = fake
+	AuditLogPath = filepath.Join(t.TempDir(), "audit.jsonl")
+	LockDirectory = t.TempDir()
+	t.Cleanup(func() {
+		CryoUtils.Executor, CryoUtils.SwapFileLocation, AuditLogPath = oldExecutor, oldSwapFile, oldAuditPath
+		LockDirectory = oldLockDirectory
+	})
+	return fake
+}
+
+func TestCommandExecutorCommand(t *testing.T) {
+	tests := []struct {
+		command []string
+		want    []string
+	}{
+		{[]string{"sudo"}, []string{"sudo", "tee", "/proc/sys/vm/swappiness"}},
+		{[]string{"doas", "-n"}, []string{"doas", "-n", "tee", "/proc/sys/vm/swappiness"}},
+	}
+	for _, tt := range tests {
+		got := CommandExecutor{Command: tt.command}.command(context.Background(), "tee", "/proc/sys/vm/swappiness").Args
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("command() with %v = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestExecutorTunables(t *testing.T) {
+	fake := useFakeExecutor(t, map[string]string{UnitMatrix["hugepages"]: "always [madvise] never\n"})
+	unitFile := filepath.Join(TmpFilesRoot, "swappiness.conf")
+
+	value, err := getUnitStatus("hugepages")
+	if err != nil || value != "madvise" {
+		t.Errorf("getUnitStatus() = %q, %v, want madvise", value, err)
+	}
+	_ = setUnitValue("swappiness", "1")
+	if got := fake.Files[UnitMatrix["swappiness"]]; got != "1\n" {
+		t.Errorf("swappiness = %q, want %q", got, "1\n")
+	}
+	_ = writeUnitFile("swappiness", "1")
+	if got := fake.Files[unitFile]; got != getUnitFileContents("swappiness", "1") {
+		t.Errorf("unit file = %q", got)
+	}
+	_ = removeUnitFile("swappiness")
+	if _, ok := fake.Files[unitFile]; ok {
+		t.Errorf("unit file still there after removeUnitFile()")
+	}
+
+	fake.Err = errors.New("denied")
+	if err = writeKernelValue(UnitMatrix["swappiness"], "60"); err == nil {
+		t.Errorf("writeKernelValue() should fail when the executor does")
+	}
+}
+
+func TestExecutorSwapResize(t *testing.T) {
+	fake := useFakeExecutor(t, nil)
+	CryoUtils.SwapFileLocation = "/home/swapfile"
+
+	for _, step := range []func() error{disableSwap, func() error { return resizeSwapFile(context.Background(), 4) },
+		setSwapPermissions, initNewSwapFile} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"run swapoff -a",
+		"run dd if=/dev/zero of=/home/swapfile bs=1G count=4 status=progress",
+		"run chmod 600 /home/swapfile",
+		"run mkswap /home/swapfile",
+		"run swapon /home/swapfile",
+	}
+	if !reflect.DeepEqual(fake.Calls, want) {
+		t.Errorf("calls = %v, want %v", fake.Calls, want)
+	}
+
+	// Everything done on the way must be something the helper would allow.
+	helper := &PrivilegedHelper{swapFile: "/home/swapfile"}
+	for _, call := range fake.Calls {
+		args := strings.Fields(strings.TrimPrefix(call, "run "))
+		if !helper.isAllowedCommand(args) {
+			t.Errorf("helper refuses %v", args)
+		}
+	}
+}
+
+func TestChangeSwapSizeCancelled(t *testing.T) {
+	fake := useFakeExecutor(t, nil)
+	CryoUtils.SwapFileLocation = "/home/swapfile"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Cancelled before starting, nothing is touched.
+	err := ChangeSwapSizeCLI(ctx, 4, false)
+	if !errors.Is(err, context.Canceled) || len(fake.Calls) != 0 {
+		t.Errorf("ChangeSwapSizeCLI() = %v with calls %v, want cancelled with none", err, fake.Calls)
+	}
+
+	// Cancelled after swapoff, swap is turned back on.
+	err = rollbackSwapResize(checkCancelled(ctx), 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("rollbackSwapResize() = %v, want the cancellation", err)
+	}
+	want := []string{"run chmod 600 /home/swapfile", "run mkswap /home/swapfile", "run swapon /home/swapfile"}
+	if !reflect.DeepEqual(fake.Calls, want) {
+		t.Errorf("calls = %v, want %v", fake.Calls, want)
+	}
+
+	// Cancelled once dd had truncated the file, it's written again at the previous size first.
+	fake.Calls = nil
+	err = rollbackSwapResize(checkCancelled(ctx), 2)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("rollbackSwapResize() = %v, want the cancellation", err)
+	}
+	want = append([]string{"run dd if=/dev/zero of=/home/swapfile bs=1G count=2 status=progress"}, want...)
+	if !reflect.DeepEqual(fake.Calls, want) {
+		t.Errorf("calls = %v, want %v", fake.Calls, want)
+	}
+}
+
+func TestGetSwapFileSizeGB(t *testing.T) {
+	oldLocation := CryoUtils.SwapFileLocation
+	t.Cleanup(func() { CryoUtils.SwapFileLocation = oldLocation })
+	tests := []struct {
+		name string
+		size int64
+		want int
+	}{
+		{"Missing", -1, DefaultSwapSize},
+		{"Empty", 0, DefaultSwapSize},
+		{"Whole", 4 * int64(GigabyteMultiplier), 4},
+		{"Partly written", 3*int64(GigabyteMultiplier) + 4096, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			CryoUtils.SwapFileLocation = filepath.Join(t.TempDir(), "swapfile")
+			if tt.size >= 0 {
+				// Sparse, nothing is actually written.
+				if err := os.WriteFile(CryoUtils.SwapFileLocation, nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Truncate(CryoUtils.SwapFileLocation, tt.size); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := getSwapFileSizeGB(); got != tt.want {
+				t.Errorf("getSwapFileSizeGB() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutorRunCancelled(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("no sleep")
+	}
+	tests := []struct {
+		name     string
+		executor Executor
+	}{
+		{"Root", RootExecutor{}},
+		// env stands in for sudo, what it runs must get the signal.
+		{"Command", CommandExecutor{Command: []string{"env"}}},
+		// And one that ignores it mustn't block forever.
+		{"Command ignoring SIGTERM", CommandExecutor{Command: []string{"sh", "-c", `trap "" TERM; "$@"`, "sh"}}},
+	}
+	oldDelay := PrivilegeCommandStopDelay
+	PrivilegeCommandStopDelay = 200 * time.Millisecond
+	t.Cleanup(func() { PrivilegeCommandStopDelay = oldDelay })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := tt.executor.Run(ctx, "sleep", "10")
+			if err == nil || time.Since(start) > 5*time.Second {
+				t.Errorf("Run() = %v after %v, want it stopped by the cancellation", err, time.Since(start))
+			}
+		})
+	}
+}
+
+func TestRunSettingsStepsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var ran []string
+	step := func(name string) settingsStep {
+		return settingsStep{name, func() error {
+			ran = append(ran, name)
+			if name == "hugepages" {
+				cancel()
+			}
+			return nil
+		}}
+	}
+
+	// Cancelled during a step, that step finishes and nothing after it runs.
+	err := runSettingsSteps(ctx, []settingsStep{step("swappiness"), step("hugepages"), step("defrag")}, "done")
+	if !errors.Is(err, context.Canceled) || !reflect.DeepEqual(ran, []string{"swappiness", "hugepages"}) {
+		t.Errorf("runSettingsSteps() = %v after running %v, want cancelled after hugepages", err, ran)
+	}
+}
+
+func TestGetExecutorConcurrent(t *testing.T) {
+	oldExecutor, oldSocket := CryoUtils.Executor, HelperSocketPath
+	CryoUtils.Executor, HelperSocketPath = nil, filepath.Join(t.TempDir(), "helper.sock")
+	t.Cleanup(func() { CryoUtils.Executor, HelperSocketPath = oldExecutor, oldSocket })
+
+	// The GUI's background goroutines may all be first to need root, only one picks.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = getExecutor()
+		}()
+	}
+	wg.Wait()
+	if currentExecutor() == nil {
+		t.Errorf("no executor picked")
+	}
+}
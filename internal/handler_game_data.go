@@ -17,6 +17,7 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -128,7 +129,7 @@ func (s *StorageStatus) getStorageStatus(left string, right string) error {
 	return nil
 }
 
-func (d *DataToMove) getSpaceNeeded(left string, right string) {
+func (d *DataToMove) getSpaceNeeded(ctx context.Context, left string, right string) error {
 	var leftCompat, rightCompat, leftShader, rightShader string
 	if left == SteamDataRoot {
 		leftCompat = SteamCompatRoot
@@ -146,15 +147,29 @@ func (d *DataToMove) getSpaceNeeded(left string, right string) {
 		rightShader = filepath.Join(right, ExternalShaderRoot)
 	}
 
+	add := func(total *int64, path string) error {
+		size, err := getDirectorySize(ctx, path)
+		*total += size
+		return err
+	}
 	for x := range d.left {
-		d.leftSize += getDirectorySize(filepath.Join(leftCompat, d.left[x]))
-		d.leftSize += getDirectorySize(filepath.Join(leftShader, d.left[x]))
+		if err := add(&d.leftSize, filepath.Join(leftCompat, d.left[x])); err != nil {
+			return err
+		}
+		if err := add(&d.leftSize, filepath.Join(leftShader, d.left[x])); err != nil {
+			return err
+		}
 	}
 
 	for x := range d.right {
-		d.rightSize += getDirectorySize(filepath.Join(rightCompat, d.right[x]))
-		d.rightSize += getDirectorySize(filepath.Join(rightShader, d.right[x]))
+		if err := add(&d.rightSize, filepath.Join(rightCompat, d.right[x])); err != nil {
+			return err
+		}
+		if err := add(&d.rightSize, filepath.Join(rightShader, d.right[x])); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // Populate a DataToMove object with the current queue of data needing to be moved.
@@ -199,8 +214,15 @@ func (d *DataToMove) getDataToMove(left string, right string) error {
 	return nil
 }
 
-// Move game data between each location as necessary
-func moveGameData(data DataToMove, left string, right string) error {
+// Move game data between each location as necessary. Cancelling stops between games, or after the
+// current file with the partial copy undone.
+func moveGameData(ctx context.Context, data DataToMove, left string, right string) error {
+	release, err := acquireLock(LockGameData)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	var progressPerMove = 1.0 / float64(len(data.right)+len(data.left))
 	var leftCompatPath, leftShaderPath, rightCompatPath, rightShaderPath string
 
@@ -222,133 +244,125 @@ func moveGameData(data DataToMove, left string, right string) error {
 
 	// Moving to the left
 	for _, directory := range data.right {
-		leftCompatDir := filepath.Join(leftCompatPath, directory)
-		leftShaderDir := filepath.Join(leftShaderPath, directory)
-		rightCompatDir := filepath.Join(rightCompatPath, directory)
-		rightShaderDir := filepath.Join(rightShaderPath, directory)
-
-		// Remove any symlinks on the SSD in preparation for either moving to the SSD, or creating new symlinks
-		steamCompatDir := filepath.Join(SteamCompatRoot, directory)
-		if isSymbolicLink(steamCompatDir) {
-			_ = os.Remove(steamCompatDir)
-		}
-		steamShaderDir := filepath.Join(SteamShaderRoot, directory)
-		if isSymbolicLink(steamShaderDir) {
-			_ = os.Remove(steamShaderDir)
-		}
-
-		// Copy the files
-		CryoUtils.InfoLog.Println("移动 " + directory + " 往左...")
-		err := cp.Copy(rightCompatDir, leftCompatDir)
+		err = checkCancelled(ctx)
 		if err != nil {
-			CryoUtils.ErrorLog.Println(err)
 			return err
 		}
-		err = cp.Copy(rightShaderDir, leftShaderDir)
+		CryoUtils.InfoLog.Println("移动 " + directory + " 往左...")
+		err = moveGameDirectory(ctx, directory, rightCompatPath, rightShaderPath, leftCompatPath, leftShaderPath)
 		if err != nil {
-			CryoUtils.ErrorLog.Println(err)
 			return err
 		}
+		CryoUtils.MoveDataProgressBar.SetValue(CryoUtils.MoveDataProgressBar.Value + progressPerMove)
+	}
 
-		// Remove the old files on the right
-		CryoUtils.InfoLog.Println("删除旧的 " + rightCompatDir)
-		err = os.RemoveAll(rightCompatDir)
+	// Moving to the right
+	for _, directory := range data.left {
+		err = checkCancelled(ctx)
 		if err != nil {
-			CryoUtils.ErrorLog.Println(err)
 			return err
 		}
-		waitForDeletion(rightCompatPath, directory)
-		CryoUtils.InfoLog.Println("删除旧的 " + rightShaderDir)
-		err = os.RemoveAll(rightShaderDir)
+		CryoUtils.InfoLog.Println("移动 " + directory + " 往右...")
+		err = moveGameDirectory(ctx, directory, leftCompatPath, leftShaderPath, rightCompatPath, rightShaderPath)
 		if err != nil {
-			CryoUtils.ErrorLog.Println(err)
 			return err
 		}
-		waitForDeletion(rightShaderPath, directory)
-
-		// If the destination is NOT on the SSD, make symlinks
-		if leftCompatPath != SteamCompatRoot {
-			// Create symlinks on the SSD to the new location
-			CryoUtils.InfoLog.Println("在固态硬盘上创建指向新路径的符号链接...")
-			err = os.Symlink(leftCompatDir, filepath.Join(SteamCompatRoot, directory))
-			if err != nil {
-				CryoUtils.ErrorLog.Println(err)
-				return err
-			}
-			err = os.Symlink(leftShaderDir, filepath.Join(SteamShaderRoot, directory))
-			if err != nil {
-				CryoUtils.ErrorLog.Println(err)
-				return err
-			}
-		}
-
 		CryoUtils.MoveDataProgressBar.SetValue(CryoUtils.MoveDataProgressBar.Value + progressPerMove)
 	}
+	return nil
+}
 
-	// Moving to the right
-	for _, directory := range data.left {
-		leftCompatDir := filepath.Join(leftCompatPath, directory)
-		leftShaderDir := filepath.Join(leftShaderPath, directory)
-		rightCompatDir := filepath.Join(rightCompatPath, directory)
-		rightShaderDir := filepath.Join(rightShaderPath, directory)
-
-		// Remove any symlinks on the SSD in preparation for either moving to the SSD, or creating new symlinks
-		steamCompatDir := filepath.Join(SteamCompatRoot, directory)
-		if isSymbolicLink(steamCompatDir) {
-			_ = os.Remove(steamCompatDir)
+// Move one game's compatdata and shadercache, leaving symlinks on the SSD if they end up elsewhere.
+func moveGameDirectory(ctx context.Context, directory string, fromCompatPath string, fromShaderPath string,
+	toCompatPath string, toShaderPath string) error {
+	fromCompatDir := filepath.Join(fromCompatPath, directory)
+	fromShaderDir := filepath.Join(fromShaderPath, directory)
+	toCompatDir := filepath.Join(toCompatPath, directory)
+	toShaderDir := filepath.Join(toShaderPath, directory)
+
+	// Remove any symlinks on the SSD in preparation for either moving to the SSD, or creating new symlinks
+	steamCompatDir := filepath.Join(SteamCompatRoot, directory)
+	steamShaderDir := filepath.Join(SteamShaderRoot, directory)
+	removedLinks := map[string]string{}
+	for _, link := range []string{steamCompatDir, steamShaderDir} {
+		if isSymbolicLink(link) {
+			target, _ := os.Readlink(link)
+			_ = os.Remove(link)
+			removedLinks[link] = target
 		}
-		steamShaderDir := filepath.Join(SteamShaderRoot, directory)
-		if isSymbolicLink(steamShaderDir) {
-			_ = os.Remove(steamShaderDir)
+	}
+
+	// Copy the files, checking for cancellation before each one
+	createdDirs := []string{}
+	for _, dir := range []string{toCompatDir, toShaderDir} {
+		if _, err := os.Lstat(dir); os.IsNotExist(err) {
+			createdDirs = append(createdDirs, dir)
 		}
+	}
+	opts := cp.Options{Skip: func(os.FileInfo, string, string) (bool, error) {
+		return false, checkCancelled(ctx)
+	}}
+	err := cp.Copy(fromCompatDir, toCompatDir, opts)
+	if err == nil {
+		err = cp.Copy(fromShaderDir, toShaderDir, opts)
+	}
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		rollbackGameDirectoryCopy(createdDirs, removedLinks)
+		return err
+	}
 
-		// Copy the files
-		CryoUtils.InfoLog.Println("移动 " + directory + " 往右...")
-		err := cp.Copy(leftCompatDir, rightCompatDir)
+	// The copy is complete, so from here on finishing the move is the clean way out.
+	CryoUtils.InfoLog.Println("删除旧的 " + fromCompatDir)
+	err = os.RemoveAll(fromCompatDir)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return err
+	}
+	waitForDeletion(fromCompatPath, directory)
+	CryoUtils.InfoLog.Println("删除旧的 " + fromShaderDir)
+	err = os.RemoveAll(fromShaderDir)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return err
+	}
+	waitForDeletion(fromShaderPath, directory)
+
+	// If the destination is NOT on the SSD, make symlinks
+	if toCompatPath != SteamCompatRoot {
+		// Create symlinks on the SSD to the new location
+		CryoUtils.InfoLog.Println("在固态硬盘上创建指向新路径的符号链接...")
+		err = os.Symlink(toCompatDir, steamCompatDir)
 		if err != nil {
 			CryoUtils.ErrorLog.Println(err)
 			return err
 		}
-		err = cp.Copy(leftShaderDir, rightShaderDir)
+		err = os.Symlink(toShaderDir, steamShaderDir)
 		if err != nil {
 			CryoUtils.ErrorLog.Println(err)
 			return err
 		}
+	}
+	return nil
+}
 
-		// Remove the old files on the left
-		CryoUtils.InfoLog.Println("删除旧的 " + leftCompatDir)
-		err = os.RemoveAll(leftCompatDir)
+// Undo a copy that didn't finish: remove the directories it created and put the SSD symlinks back.
+// Nothing has been removed from the source yet, so the game is left where it was.
+func rollbackGameDirectoryCopy(createdDirs []string, removedLinks map[string]string) {
+	for _, dir := range createdDirs {
+		CryoUtils.InfoLog.Println("回滚，删除未完成的副本", dir)
+		err := os.RemoveAll(dir)
 		if err != nil {
 			CryoUtils.ErrorLog.Println(err)
-			return err
 		}
-		waitForDeletion(leftCompatPath, directory)
-		CryoUtils.InfoLog.Println("删除旧的 " + leftShaderDir)
-		err = os.RemoveAll(leftShaderDir)
+	}
+	for link, target := range removedLinks {
+		CryoUtils.InfoLog.Println("回滚，恢复符号链接", link)
+		err := os.Symlink(target, link)
 		if err != nil {
 			CryoUtils.ErrorLog.Println(err)
-			return err
 		}
-		waitForDeletion(leftShaderPath, directory)
-
-		// If the destination is NOT on the SSD, make symlinks
-		if rightCompatPath != SteamCompatRoot {
-			// Create symlinks on the SSD to the new location
-			CryoUtils.InfoLog.Println("在固态硬盘上创建指向新路径的符号链接...")
-			err = os.Symlink(rightCompatDir, filepath.Join(SteamCompatRoot, directory))
-			if err != nil {
-				CryoUtils.ErrorLog.Println(err)
-				return err
-			}
-			err = os.Symlink(rightShaderDir, filepath.Join(SteamShaderRoot, directory))
-			if err != nil {
-				CryoUtils.ErrorLog.Println(err)
-				return err
-			}
-		}
-		CryoUtils.MoveDataProgressBar.SetValue(CryoUtils.MoveDataProgressBar.Value + progressPerMove)
 	}
-	return nil
 }
 
 // Confirm that all directories are in the proper locations post-move.
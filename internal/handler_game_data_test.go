@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fyne.io/fyne/v2/widget"
+)
+
+// Lay out an SSD and a microSD card in a temp dir, with one game's data moved to the card and
+// symlinked back, the way moveGameData leaves it.
+func useGameDataDirs(t *testing.T) (ssd string, card string) {
+	dir := t.TempDir()
+	ssd = filepath.Join(dir, "ssd")
+	card = filepath.Join(dir, "card")
+	oldCompat, oldShader, oldDataRoot := SteamCompatRoot, SteamShaderRoot, SteamDataRoot
+	SteamDataRoot = ssd
+	SteamCompatRoot = filepath.Join(ssd, "steamapps/compatdata")
+	SteamShaderRoot = filepath.Join(ssd, "steamapps/shadercache")
+	oldDirectory := LockDirectory
+	LockDirectory = dir
+	oldProgress := CryoUtils.MoveDataProgressBar
+	CryoUtils.MoveDataProgressBar = widget.NewProgressBar()
+	t.Cleanup(func() {
+		SteamCompatRoot, SteamShaderRoot, SteamDataRoot = oldCompat, oldShader, oldDataRoot
+		LockDirectory, CryoUtils.MoveDataProgressBar = oldDirectory, oldProgress
+	})
+
+	for _, root := range []string{ExternalCompatRoot, ExternalShaderRoot} {
+		game := filepath.Join(card, root, "620")
+		if err := os.MkdirAll(game, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(game, "data"), []byte("save"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, root := range []string{SteamCompatRoot, SteamShaderRoot} {
+		if err := os.MkdirAll(root, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(card, ExternalCompatRoot, "620"), filepath.Join(SteamCompatRoot, "620")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(card, ExternalShaderRoot, "620"), filepath.Join(SteamShaderRoot, "620")); err != nil {
+		t.Fatal(err)
+	}
+	return ssd, card
+}
+
+func TestMoveGameDataCancelled(t *testing.T) {
+	ssd, card := useGameDataDirs(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Moving the game from the card back to the SSD.
+	err := moveGameDirectory(ctx, "620", filepath.Join(card, ExternalCompatRoot), filepath.Join(card, ExternalShaderRoot),
+		SteamCompatRoot, SteamShaderRoot)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("moveGameDirectory() = %v, want cancelled", err)
+	}
+	// The game is left where it was, reachable through the symlinks as before.
+	for _, root := range []string{SteamCompatRoot, SteamShaderRoot} {
+		if !isSymbolicLink(filepath.Join(root, "620")) {
+			t.Errorf("symlink in %s not restored", root)
+		}
+		if data, err := os.ReadFile(filepath.Join(root, "620", "data")); err != nil || string(data) != "save" {
+			t.Errorf("data through %s = %q, %v", root, data, err)
+		}
+	}
+
+	err = moveGameData(context.Background(), DataToMove{left: []string{"620"}}, card, ssd)
+	if err != nil {
+		t.Fatalf("moveGameData() = %v", err)
+	}
+	for _, root := range []string{SteamCompatRoot, SteamShaderRoot} {
+		if isSymbolicLink(filepath.Join(root, "620")) {
+			t.Errorf("%s still a symlink after the move", root)
+		}
+		if data, err := os.ReadFile(filepath.Join(root, "620", "data")); err != nil || string(data) != "save" {
+			t.Errorf("moved data in %s = %q, %v", root, data, err)
+		}
+	}
+	if doesFileExist(filepath.Join(card, ExternalCompatRoot, "620")) {
+		t.Errorf("data left on the card after the move")
+	}
+}
+
+func TestGetDirectorySize(t *testing.T) {
+	_, card := useGameDataDirs(t)
+	size, err := getDirectorySize(context.Background(), filepath.Join(card, ExternalDataRoot))
+	if err != nil || size != 8 {
+		t.Errorf("getDirectorySize() = %d, %v, want 8", size, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = getDirectorySize(ctx, filepath.Join(card, ExternalDataRoot))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("getDirectorySize() cancelled = %v", err)
+	}
+}
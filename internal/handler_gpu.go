@@ -2,7 +2,6 @@ package internal
 
 import (
 	"fmt"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -17,7 +16,10 @@ func init() {
 
 // Get the current VRAM
 func getVRAMValue() (int, error) {
-	cmd, err := exec.Command("glxinfo", "-B").Output()
+	cmd, err := runCommand("glxinfo", "-B")
+	if err != nil {
+		return 100, withCommandOp("获取当前显存", err)
+	}
 
 	// Extract video memory
 	re := regexp.MustCompile(`显存: [0-9]+`)
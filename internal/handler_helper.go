@@ -0,0 +1,448 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// errHelperUnavailable The helper isn't running, the caller should fall back to sudo.
+var errHelperUnavailable = errors.New("特权助手未运行")
+
+var blockQueueAttributeRegex = regexp.MustCompile(`^[^/]+/queue/(scheduler|read_ahead_kb|nr_requests)$`)
+var blockDeviceRuleRegex = regexp.MustCompile(`^ACTION=="add\|change", SUBSYSTEM=="block", ENV\{DEVTYPE\}=="disk", ` +
+	`ATTRS\{(model|name)\}=="[^"\\]+"(, ATTRS\{serial\}=="[^"\\]*")?` +
+	`(, ATTR\{queue/(scheduler|read_ahead_kb|nr_requests)\}="[a-z0-9_-]+")*$`)
+var helperSwapCountRegex = regexp.MustCompile(`^count=([1-9][0-9]?|1[01][0-9]|12[0-8])$`)
+
+// HelperRequest One operation asked of the privileged helper. Only the fields the operation needs are set.
+type HelperRequest struct {
+	Op   string   `json:"op"`
+	Path string   `json:"path,omitempty"`
+	Data string   `json:"data,omitempty"`
+	Args []string `json:"args,omitempty"`
+	// Caller What asked for it, for the audit log, ex: "gui". Taken on trust, unlike the uid.
+	Caller string `json:"caller,omitempty"`
+}
+
+// HelperResponse The result of a request, Error is empty on success.
+type HelperResponse struct {
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// ExitCode and Stderr Set when a command failed, so the caller's error can say why.
+	ExitCode int    `json:"exit_code,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+}
+
+// PrivilegedHelper Runs the allow-listed operations as root for one user.
+type PrivilegedHelper struct {
+	uid int
+	// The swap file when the helper started, the only one besides the default it will touch.
+	swapFile string
+}
+
+// RunHelper Serve privileged operations over a Unix socket until the context is cancelled
+// or the parent process exits. Only uid, or root, may connect.
+func RunHelper(ctx context.Context, uid int, parent int) error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("特权助手必须以 root 身份运行")
+	}
+	if uid < 0 {
+		return fmt.Errorf("未指定允许连接的用户")
+	}
+	if _, err := callHelper(HelperRequest{Op: "ping"}); err == nil {
+		return fmt.Errorf("特权助手已在运行")
+	}
+
+	err := os.MkdirAll(filepath.Dir(HelperSocketPath), 0755)
+	if err != nil {
+		return err
+	}
+	// Left behind by a helper that was killed.
+	_ = os.Remove(HelperSocketPath)
+	listener, err := net.Listen("unix", HelperSocketPath)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	// Anyone may connect, the peer's uid is checked on every connection instead.
+	err = os.Chmod(HelperSocketPath, 0666)
+	if err != nil {
+		return err
+	}
+
+	helper := &PrivilegedHelper{uid: uid}
+	helper.swapFile, _ = getSwapFileLocation()
+	CryoUtils.InfoLog.Println("特权助手已启动，用户", uid, "父进程", parent)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				listener.Close()
+				return
+			case <-ticker.C:
+				if parent > 0 && unix.Kill(parent, 0) == unix.ESRCH {
+					CryoUtils.InfoLog.Println("父进程已退出，特权助手停止")
+					cancel()
+				}
+			}
+		}
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			CryoUtils.ErrorLog.Println(err)
+			continue
+		}
+		go helper.serve(ctx, conn.(*net.UnixConn))
+	}
+}
+
+// Get the process on the other end of a connection, from the kernel rather than the request.
+func getPeerCredentials(conn *net.UnixConn) (*unix.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	return cred, nil
+}
+
+// Answer a single request on a connection.
+func (h *PrivilegedHelper) serve(ctx context.Context, conn *net.UnixConn) {
+	defer conn.Close()
+	peer, err := getPeerCredentials(conn)
+	if err != nil {
+		CryoUtils.ErrorLog.Println("无法获取连接的 uid", err)
+		return
+	}
+	if int(peer.Uid) != h.uid && peer.Uid != 0 {
+		CryoUtils.ErrorLog.Println("拒绝来自 uid", peer.Uid, "的连接")
+		return
+	}
+
+	var request HelperRequest
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	decoder := json.NewDecoder(conn)
+	err = decoder.Decode(&request)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return
+	}
+	// Anything more from the caller is a cancel request, and so is it going away. Either stops the command.
+	_ = conn.SetReadDeadline(time.Time{})
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		var next HelperRequest
+		_ = decoder.Decode(&next)
+		cancel()
+	}()
+	output, err := h.handle(ctx, request, int(peer.Pid))
+	response := HelperResponse{Output: output}
+	if err != nil {
+		CryoUtils.ErrorLog.Println(request.Op, err)
+		response.Error = err.Error()
+		var commandErr *CommandError
+		if errors.As(err, &commandErr) {
+			response.Error = commandErr.Err.Error()
+			response.ExitCode, response.Stderr = commandErr.ExitCode, commandErr.Stderr
+		}
+	}
+	err = json.NewEncoder(conn).Encode(response)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+	}
+}
+
+// Do one allow-listed operation for the process pid. Changes are audited here, whoever connected,
+// and so are the ones refused.
+func (h *PrivilegedHelper) handle(ctx context.Context, request HelperRequest, pid int) (string, error) {
+	CryoUtils.InfoLog.Println("特权助手请求:", request.Op, request.Path, request.Args)
+	root := AuditExecutor{Next: RootExecutor{}, Caller: request.Caller, PID: pid}
+	err := h.check(request)
+	if err != nil {
+		if request.Op == "write" || request.Op == "remove" || request.Op == "run" {
+			entry := root.newEntry(request.Op)
+			entry.Path, entry.Args = request.Path, request.Args
+			root.record(entry, err)
+		}
+		return "", err
+	}
+	switch request.Op {
+	case "read":
+		data, err := root.ReadFile(request.Path)
+		return string(data), err
+	case "write":
+		return "", root.WriteFile(request.Path, []byte(request.Data))
+	case "remove":
+		return "", root.RemoveFile(request.Path)
+	case "run":
+		output, err := root.Run(ctx, request.Args[0], request.Args[1:]...)
+		return string(output), err
+	}
+	return "pong", nil
+}
+
+// Refuse anything outside the paths, contents and commands CryoUtilities uses.
+func (h *PrivilegedHelper) check(request HelperRequest) error {
+	switch request.Op {
+	case "ping":
+		return nil
+	case "read":
+		if !isHelperTunablePath(request.Path) {
+			return fmt.Errorf("不允许读取 %s", request.Path)
+		}
+		return nil
+	case "write":
+		if isHelperTunablePath(request.Path) && isHelperValue(strings.TrimSuffix(request.Data, "\n")) {
+			return nil
+		}
+		if isHelperUnitFile(request.Path, request.Data) {
+			return nil
+		}
+		if request.Path == BlockDeviceRulesPath && isHelperBlockDeviceRules(request.Data) {
+			return nil
+		}
+		return fmt.Errorf("不允许写入 %s", request.Path)
+	case "remove":
+		if getHelperUnitParam(request.Path) != "" || request.Path == BlockDeviceRulesPath ||
+			contains(HelperLegacyFiles, request.Path) {
+			return nil
+		}
+		return fmt.Errorf("不允许删除 %s", request.Path)
+	case "run":
+		if !h.isAllowedCommand(request.Args) {
+			return fmt.Errorf("不允许运行 %s", strings.Join(request.Args, " "))
+		}
+		return nil
+	}
+	return fmt.Errorf("未知操作: %s", request.Op)
+}
+
+// Check a command is exactly one CryoUtilities runs, on the swap file it uses.
+func (h *PrivilegedHelper) isAllowedCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	swapFile := args[len(args)-1]
+	isSwapFile := swapFile == DefaultSwapFileLocation || (h.swapFile != "" && swapFile == h.swapFile)
+	switch strings.Join(args, " ") {
+	case "swapoff -a", "udevadm control --reload":
+		return true
+	case "chmod 600 " + swapFile, "mkswap " + swapFile, "swapon " + swapFile:
+		return isSwapFile
+	}
+	if len(args) != 6 || args[0] != "dd" || args[1] != "if=/dev/zero" || args[3] != "bs=1G" ||
+		args[5] != "status=progress" || !helperSwapCountRegex.MatchString(args[4]) {
+		return false
+	}
+	target := strings.TrimPrefix(args[2], "of=")
+	return target == DefaultSwapFileLocation || (h.swapFile != "" && target == h.swapFile)
+}
+
+// Check whether a path is a kernel value CryoUtilities changes.
+func isHelperTunablePath(path string) bool {
+	if filepath.Clean(path) != path {
+		return false
+	}
+	for _, known := range UnitMatrix {
+		if path == known {
+			return true
+		}
+	}
+	if path == CompactMemoryPath || path == DropCachesPath {
+		return true
+	}
+	relative, err := filepath.Rel(filepath.Join(SysRoot, "block"), path)
+	return err == nil && blockQueueAttributeRegex.MatchString(relative)
+}
+
+// Check a value is a single short line, the kernel checks the rest.
+func isHelperValue(value string) bool {
+	return value != "" && len(value) <= 256 && !strings.ContainsAny(value, "\n\r")
+}
+
+// Get the parameter of a unit file CryoUtilities writes, or "" if the path isn't one.
+func getHelperUnitParam(path string) string {
+	param := strings.TrimSuffix(filepath.Base(path), ".conf")
+	if _, ok := UnitMatrix[param]; !ok || path != filepath.Join(TmpFilesRoot, param+".conf") {
+		return ""
+	}
+	return param
+}
+
+// Check a unit file is the template for its parameter, with a single line value.
+func isHelperUnitFile(path string, data string) bool {
+	param := getHelperUnitParam(path)
+	if param == "" {
+		return false
+	}
+	before, after, _ := strings.Cut(getUnitFileContents(param, "VALUE"), "VALUE")
+	if !strings.HasPrefix(data, before) || !strings.HasSuffix(data, after) || len(data) < len(before)+len(after) {
+		return false
+	}
+	return isHelperValue(data[len(before) : len(data)-len(after)])
+}
+
+// Check every line of a udev rules file is a comment or a queue rule, so nothing can be run or renamed.
+func isHelperBlockDeviceRules(data string) bool {
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		if !strings.HasPrefix(line, "#") && !blockDeviceRuleRegex.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// Ask the privileged helper to do one operation. Returns errHelperUnavailable if it isn't running.
+func callHelper(request HelperRequest) (string, error) {
+	return callHelperContext(context.Background(), request)
+}
+
+// Send a request to the privileged helper, asking it to stop if ctx is cancelled before it's done.
+// The helper has its own session, so Ctrl-C never reaches what it runs, ex: dd.
+func callHelperContext(ctx context.Context, request HelperRequest) (string, error) {
+	conn, err := net.Dial("unix", HelperSocketPath)
+	if err != nil {
+		return "", errHelperUnavailable
+	}
+	defer conn.Close()
+
+	err = json.NewEncoder(conn).Encode(request)
+	if err != nil {
+		return "", err
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = json.NewEncoder(conn).Encode(HelperRequest{Op: "cancel"})
+		case <-finished:
+		}
+	}()
+	var response HelperResponse
+	err = json.NewDecoder(conn).Decode(&response)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return "", fmt.Errorf("特权助手拒绝了请求")
+	}
+	if response.Error != "" {
+		if request.Op == "run" && response.ExitCode != 0 {
+			return response.Output, &CommandError{Args: redactCommandArgs(request.Args), ExitCode: response.ExitCode,
+				Stderr: response.Stderr, Err: fmt.Errorf("特权助手: %s", response.Error)}
+		}
+		return response.Output, errors.New(response.Error)
+	}
+	return response.Output, nil
+}
+
+// StartHelper Start the privileged helper with the password, so it never needs to be kept.
+// An empty password starts the root-owned copy, only if the drop-in lets sudo do that without one.
+// The helper exits with this process.
+func StartHelper(password string) error {
+	if _, err := callHelper(HelperRequest{Op: "ping"}); err == nil {
+		return nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		executable = HelperInstallPath
+	}
+	logFile, err := openSharedFile(HelperLogPath, os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	args := []string{"-S", "-p", ""}
+	if password == "" {
+		args = []string{"-n"}
+	}
+	args = append(args, "--", executable, HelperCommand,
+		"--uid", strconv.Itoa(os.Getuid()), "--parent", strconv.Itoa(os.Getpid()))
+	cmd := exec.Command("sudo", args...)
+	cmd.Stdin = strings.NewReader(password + "\n")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// Its own session, so a terminal closing doesn't take it down before the GUI.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	deadline := time.Now().Add(HelperStartTimeout)
+	for time.Now().Before(deadline) {
+		if _, err = callHelper(HelperRequest{Op: "ping"}); err == nil {
+			CryoUtils.InfoLog.Println("特权助手已启动")
+			return nil
+		}
+		select {
+		// sudo refusing, or the helper failing, won't get any better by waiting.
+		case err = <-exited:
+			return fmt.Errorf("特权助手未能启动: %v，请查看 %s", err, HelperLogPath)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("特权助手未能启动，请查看 %s", HelperLogPath)
+}
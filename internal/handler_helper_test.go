@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIsHelperTunablePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{UnitMatrix["swappiness"], true},
+		{DropCachesPath, true},
+		{filepath.Join(SysRoot, "block/mmcblk0/queue/scheduler"), true},
+		{filepath.Join(SysRoot, "block/mmcblk0/queue/rotational"), false},
+		{SysRoot + "/block/../block/sda/queue/scheduler", false},
+		{"/proc/sys/vm/../../../etc/shadow", false},
+		{"/etc/shadow", false},
+	}
+	for _, tt := range tests {
+		if got := isHelperTunablePath(tt.path); got != tt.want {
+			t.Errorf("isHelperTunablePath(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPrivilegedHelperCheck(t *testing.T) {
+	helper := &PrivilegedHelper{uid: 1000, swapFile: "/home/swapfile"}
+	unitFile := filepath.Join(TmpFilesRoot, "swappiness.conf")
+	rules := getBlockDeviceRules([]BlockDeviceSettings{
+		{ModelAttr: "model", Model: "Samsung SSD 980", Serial: "S123", Scheduler: "kyber", ReadAheadKB: "512"},
+		{ModelAttr: "name", Model: "EC2QT", NrRequests: "64"},
+	})
+	tests := []struct {
+		request HelperRequest
+		allowed bool
+	}{
+		{HelperRequest{Op: "ping"}, true},
+		{HelperRequest{Op: "read", Path: UnitMatrix["swappiness"]}, true},
+		{HelperRequest{Op: "write", Path: UnitMatrix["swappiness"], Data: "1\n"}, true},
+		{HelperRequest{Op: "write", Path: unitFile, Data: getUnitFileContents("swappiness", "1")}, true},
+		{HelperRequest{Op: "write", Path: BlockDeviceRulesPath, Data: rules}, true},
+		{HelperRequest{Op: "remove", Path: unitFile}, true},
+		{HelperRequest{Op: "remove", Path: OldSwappinessUnitFile}, true},
+		{HelperRequest{Op: "run", Args: []string{"swapoff", "-a"}}, true},
+		{HelperRequest{Op: "run", Args: []string{"dd", "if=/dev/zero", "of=/home/swapfile", "bs=1G", "count=16", "status=progress"}}, true},
+		{HelperRequest{Op: "run", Args: []string{"mkswap", DefaultSwapFileLocation}}, true},
+		{HelperRequest{Op: "run", Args: []string{"udevadm", "control", "--reload"}}, true},
+
+		{HelperRequest{Op: "exec", Path: "/bin/sh"}, false},
+		{HelperRequest{Op: "read", Path: "/etc/shadow"}, false},
+		{HelperRequest{Op: "write", Path: UnitMatrix["swappiness"], Data: "1\nvm.overcommit_memory=1"}, false},
+		{HelperRequest{Op: "write", Path: "/etc/passwd", Data: "1"}, false},
+		{HelperRequest{Op: "write", Path: unitFile, Data: getUnitFileContents("swappiness", "1\nw /etc/passwd")}, false},
+		{HelperRequest{Op: "write", Path: filepath.Join(TmpFilesRoot, "../../etc/passwd.conf"), Data: "1"}, false},
+		{HelperRequest{Op: "write", Path: BlockDeviceRulesPath, Data: rules + `ACTION=="add", RUN+="/bin/sh"` + "\n"}, false},
+		{HelperRequest{Op: "write", Path: BlockDeviceRulesPath, Data: `ACTION=="add|change", SUBSYSTEM=="block", ENV{DEVTYPE}=="disk", ATTRS{model}=="x", ATTR{queue/scheduler}="bfq", RUN+="x"`}, false},
+		{HelperRequest{Op: "remove", Path: "/etc/fstab"}, false},
+		// A live tunable, not a file left behind.
+		{HelperRequest{Op: "remove", Path: "/proc/sys/vm/nr_hugepages"}, false},
+		{HelperRequest{Op: "run", Args: []string{"sh", "-c", "id"}}, false},
+		{HelperRequest{Op: "run", Args: []string{"dd", "if=/dev/zero", "of=/etc/passwd", "bs=1G", "count=1", "status=progress"}}, false},
+		{HelperRequest{Op: "run", Args: []string{"dd", "if=/dev/zero", "of=/home/swapfile", "bs=1G", "count=0", "status=progress"}}, false},
+		{HelperRequest{Op: "run", Args: []string{"chmod", "600", "/etc/shadow"}}, false},
+		{HelperRequest{Op: "run"}, false},
+	}
+	for _, tt := range tests {
+		err := helper.check(tt.request)
+		if (err == nil) != tt.allowed {
+			t.Errorf("check(%+v) error = %v, want allowed %v", tt.request, err, tt.allowed)
+		}
+	}
+}
+
+func TestRunHelper(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the helper only runs as root")
+	}
+	oldPath, oldAuditPath := HelperSocketPath, AuditLogPath
+	HelperSocketPath = filepath.Join(t.TempDir(), "helper.sock")
+	AuditLogPath = filepath.Join(t.TempDir(), "audit.jsonl")
+	t.Cleanup(func() { HelperSocketPath, AuditLogPath = oldPath, oldAuditPath })
+
+	if _, err := callHelper(HelperRequest{Op: "ping"}); !errors.Is(err, errHelperUnavailable) {
+		t.Fatalf("callHelper() without a helper error = %v, want errHelperUnavailable", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- RunHelper(ctx, os.Getuid(), 0)
+	}()
+	var output string
+	var err error
+	for i := 0; i < 50; i++ {
+		output, err = callHelper(HelperRequest{Op: "ping"})
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil || output != "pong" {
+		t.Fatalf("ping = %q, %v", output, err)
+	}
+	if _, err = callHelper(HelperRequest{Op: "read", Path: "/etc/shadow"}); err == nil || errors.Is(err, errHelperUnavailable) {
+		t.Errorf("read /etc/shadow error = %v, want a refusal", err)
+	}
+
+	// Asked directly on the socket, what the helper refuses is still audited.
+	_, err = callHelper(HelperRequest{Op: "write", Path: "/etc/passwd", Data: "x", Caller: "test"})
+	if err == nil {
+		t.Errorf("write /etc/passwd should be refused")
+	}
+	entries, err := loadAuditEntries()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("audit entries = %+v, %v, want the refused write", entries, err)
+	}
+	if e := entries[0]; e.Op != "write" || e.Path != "/etc/passwd" || e.Caller != "test" || e.PID != os.Getpid() ||
+		e.Executor != "helper" || e.Error == "" {
+		t.Errorf("audit entry = %+v", e)
+	}
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Errorf("RunHelper() error = %v", err)
+	}
+	if doesFileExist(HelperSocketPath) {
+		t.Errorf("socket left behind after the helper stopped")
+	}
+}
@@ -0,0 +1,194 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// KSMStats The state of kernel samepage merging, from /sys/kernel/mm/ksm.
+type KSMStats struct {
+	Run            string `json:"run"`
+	PagesToScan    string `json:"pages_to_scan"`
+	SleepMillisecs string `json:"sleep_millisecs"`
+	// Deduplicated pages in use, and how many more places share them, which is what's saved.
+	PagesShared   int64 `json:"pages_shared"`
+	PagesSharing  int64 `json:"pages_sharing"`
+	PagesUnshared int64 `json:"pages_unshared"`
+	FullScans     int64 `json:"full_scans"`
+}
+
+// Read the KSM statistics, these are readable without root.
+func getKSMStats(sysRoot string) (KSMStats, error) {
+	var stats KSMStats
+	dir := filepath.Join(sysRoot, "kernel/mm/ksm")
+	read := func(name string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		return strings.TrimSpace(string(data)), err
+	}
+	readInt := func(name string) (int64, error) {
+		value, err := read(name)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(value, 10, 64)
+	}
+
+	var err error
+	stats.Run, err = read("run")
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return stats, fmt.Errorf("读取 KSM 状态时出错，内核可能未启用 KSM")
+	}
+	stats.PagesToScan, _ = read("pages_to_scan")
+	stats.SleepMillisecs, _ = read("sleep_millisecs")
+	stats.PagesShared, _ = readInt("pages_shared")
+	stats.PagesSharing, _ = readInt("pages_sharing")
+	stats.PagesUnshared, _ = readInt("pages_unshared")
+	stats.FullScans, _ = readInt("full_scans")
+	return stats, nil
+}
+
+// Get how much memory merging is saving, in kB.
+func (s KSMStats) saved() int64 {
+	return s.PagesSharing * int64(os.Getpagesize()) / 1024
+}
+
+// Format the statistics for display.
+func (s KSMStats) String() string {
+	var b strings.Builder
+	state := "已禁用"
+	if s.Run == "1" {
+		state = "已启用"
+	}
+	fmt.Fprintf(&b, "KSM: %s  每次扫描页面: %s  扫描间隔: %s 毫秒\n", state, s.PagesToScan, s.SleepMillisecs)
+	fmt.Fprintf(&b, "节省: %s (pages_sharing %d, pages_shared %d, 完整扫描 %d 次)",
+		getHumanMemorySize(s.saved()), s.PagesSharing, s.PagesShared, s.FullScans)
+	if s.Run == "1" && s.FullScans > 0 && s.PagesShared == 0 && s.PagesUnshared == 0 {
+		b.WriteString("\n没有可合并的页面: KSM 只扫描程序标记为可合并 (MADV_MERGEABLE) 的内存。")
+	}
+	return b.String()
+}
+
+func getKSMStatus() bool {
+	status, err := getUnitStatus("ksm_run")
+	if err != nil {
+		CryoUtils.ErrorLog.Println("无法获取当前的 KSM 状态")
+		return false
+	}
+	if status == "1" {
+		return true
+	}
+	return false
+}
+
+// ToggleKSM Simple one-function toggle for the button to use
+func ToggleKSM() error {
+	if getKSMStatus() {
+		err := RevertKSM()
+		if err != nil {
+			return err
+		}
+	} else {
+		err := SetKSM()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func SetKSM() error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	CryoUtils.InfoLog.Println("启用 KSM...")
+	err = setUnitValue("ksm_run", "1")
+	if err != nil {
+		return err
+	}
+	err = writeUnitFile("ksm_run", "1")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func RevertKSM() error {
+	// Nothing to revert on a kernel without KSM.
+	if !doesFileExist(UnitMatrix["ksm_run"]) {
+		return nil
+	}
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	CryoUtils.InfoLog.Println("禁用 KSM...")
+	// Writing 2 to run would also unmerge every page, 0 just stops merging more.
+	stock := getStockOptionalProfile()
+	for _, param := range []string{"ksm_run", "ksm_pages_to_scan", "ksm_sleep_millisecs"} {
+		err = setUnitValue(param, stock[param])
+		if err != nil {
+			return err
+		}
+		err = removeUnitFile(param)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetKSMTuning Change how fast KSM scans, and keep it across reboots.
+func SetKSMTuning(pagesToScan string, sleepMillisecs string) error {
+	tuning := TunableProfile{}
+	if pagesToScan != "" {
+		value, err := strconv.Atoi(pagesToScan)
+		if err != nil || value <= 0 {
+			return fmt.Errorf("无效的 pages_to_scan: %s", pagesToScan)
+		}
+		tuning["ksm_pages_to_scan"] = pagesToScan
+	}
+	if sleepMillisecs != "" {
+		value, err := strconv.Atoi(sleepMillisecs)
+		if err != nil || value <= 0 {
+			return fmt.Errorf("无效的 sleep_millisecs: %s", sleepMillisecs)
+		}
+		tuning["ksm_sleep_millisecs"] = sleepMillisecs
+	}
+
+	CryoUtils.InfoLog.Println("设置 KSM 扫描参数:", tuning.String())
+	err := applyProfileLive(tuning)
+	if err != nil {
+		return err
+	}
+	return persistProfile(tuning)
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetKSMStats(t *testing.T) {
+	sysRoot := t.TempDir()
+	dir := filepath.Join(sysRoot, "kernel/mm/ksm")
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"run":             "1\n",
+		"pages_to_scan":   "1000\n",
+		"sleep_millisecs": "50\n",
+		"pages_shared":    "200\n",
+		"pages_sharing":   "2560\n",
+		"pages_unshared":  "5000\n",
+		"full_scans":      "3\n",
+	}
+	for name, contents := range files {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := getKSMStats(sysRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := KSMStats{Run: "1", PagesToScan: "1000", SleepMillisecs: "50",
+		PagesShared: 200, PagesSharing: 2560, PagesUnshared: 5000, FullScans: 3}
+	if got != want {
+		t.Errorf("getKSMStats() = %+v, want %+v", got, want)
+	}
+	if saved := got.saved(); saved != 2560*int64(os.Getpagesize())/1024 {
+		t.Errorf("saved() = %v", saved)
+	}
+
+	_, err = getKSMStats(t.TempDir())
+	if err == nil {
+		t.Errorf("getKSMStats() on a kernel without KSM should fail")
+	}
+}
+
+func TestRevertKSM(t *testing.T) {
+	// RevertKSM checks the kernel has KSM on the real path, which a test machine may not.
+	if !doesFileExist(UnitMatrix["ksm_run"]) {
+		t.Skip("no KSM on this kernel")
+	}
+	useLockDirectory(t, 0)
+	ksmFiles := map[string]string{}
+	for _, param := range []string{"ksm_run", "ksm_pages_to_scan", "ksm_sleep_millisecs"} {
+		ksmFiles[UnitMatrix[param]] = "5000\n"
+		ksmFiles[filepath.Join(TmpFilesRoot, param+".conf")] = getUnitFileContents(param, "5000")
+	}
+	fake := useFakeExecutor(t, ksmFiles)
+
+	err := RevertKSM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for param, want := range getStockOptionalProfile() {
+		if got := fake.Files[UnitMatrix[param]]; got != want+"\n" {
+			t.Errorf("%s = %q after RevertKSM(), want %q", param, got, want+"\n")
+		}
+		if _, ok := fake.Files[filepath.Join(TmpFilesRoot, param+".conf")]; ok {
+			t.Errorf("%s unit file still there after RevertKSM()", param)
+		}
+	}
+}
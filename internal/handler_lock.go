@@ -0,0 +1,255 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// Subsystems that can't have two operations running at once, each with its own lock file.
+const (
+	LockSwap     = "swap"
+	LockTunables = "tunables"
+	LockGameData = "gamedata"
+	// LockSwapWrites The swap write history, sampled by both the GUI and the watch daemon.
+	LockSwapWrites = "swapwrites"
+)
+
+// Locks held by this process, by subsystem. The flock only keeps other processes out, so each subsystem
+// also has a mutex keeping out the GUI's other goroutines.
+var (
+	heldLocksMutex   sync.Mutex
+	heldLocks        = map[string]*os.File{}
+	subsystemMutexes = map[string]*sync.Mutex{}
+)
+
+// LockInfo Who holds a lock, written into the lock file while it's held.
+type LockInfo struct {
+	PID    int       `json:"pid"`
+	Caller string    `json:"caller"`
+	Since  time.Time `json:"since"`
+}
+
+// LockBusyError Another process is in the middle of an operation on the same subsystem.
+type LockBusyError struct {
+	Subsystem string
+	Holder    LockInfo
+}
+
+func (e *LockBusyError) Error() string {
+	name := getLockSubsystemName(e.Subsystem)
+	if e.Holder.PID == 0 {
+		return fmt.Sprintf("另一个进程正在进行%s操作，请稍后再试", name)
+	}
+	return fmt.Sprintf("%s操作正在由 PID %d (%s) 进行中，开始于 %s，请稍后再试", name, e.Holder.PID,
+		e.Holder.Caller, e.Holder.Since.Local().Format("15:04:05"))
+}
+
+// Get the name of a subsystem to show in errors.
+func getLockSubsystemName(subsystem string) string {
+	switch subsystem {
+	case LockSwap:
+		return "交换文件"
+	case LockTunables:
+		return "内核参数"
+	case LockGameData:
+		return "游戏数据"
+	case LockSwapWrites:
+		return "交换写入记录"
+	}
+	return subsystem
+}
+
+func getLockPath(subsystem string) string {
+	return filepath.Join(LockDirectory, subsystem+".lock")
+}
+
+// Read who holds, or last held, a lock. A zero PID means nobody is recorded.
+func readLockInfo(path string) LockInfo {
+	var info LockInfo
+	data, err := os.ReadFile(path)
+	if err != nil || len(strings.TrimSpace(string(data))) == 0 {
+		return info
+	}
+	_ = json.Unmarshal(data, &info)
+	return info
+}
+
+// Lock a subsystem, waiting up to CryoUtils.LockWait if another process or goroutine has it.
+// The lock isn't reentrant, so nothing run while holding it may take it again.
+func acquireLock(subsystem string) (func(), error) {
+	return acquireLockWaiting(subsystem, CryoUtils.LockWait)
+}
+
+// Lock a subsystem, waiting up to wait if another process or goroutine has it. 0 fails at once, below 0 waits for good.
+func acquireLockWaiting(subsystem string, wait time.Duration) (func(), error) {
+	var deadline time.Time
+	if wait > 0 {
+		deadline = time.Now().Add(wait)
+	}
+	waiting := false
+	for {
+		err := tryLock(subsystem)
+		if err == nil {
+			var once sync.Once
+			return func() { once.Do(func() { releaseLock(subsystem) }) }, nil
+		}
+		var busy *LockBusyError
+		if !errors.As(err, &busy) || wait == 0 ||
+			(!deadline.IsZero() && time.Now().After(deadline)) {
+			return nil, err
+		}
+		if !waiting {
+			CryoUtils.InfoLog.Println("等待锁释放:", err)
+			waiting = true
+		}
+		time.Sleep(LockPollInterval)
+	}
+}
+
+// Get the mutex keeping a subsystem to one goroutine at a time.
+func getSubsystemMutex(subsystem string) *sync.Mutex {
+	heldLocksMutex.Lock()
+	defer heldLocksMutex.Unlock()
+	mutex, ok := subsystemMutexes[subsystem]
+	if !ok {
+		mutex = &sync.Mutex{}
+		subsystemMutexes[subsystem] = mutex
+	}
+	return mutex
+}
+
+// Take the lock once without waiting.
+func tryLock(subsystem string) error {
+	path := getLockPath(subsystem)
+	mutex := getSubsystemMutex(subsystem)
+	if !mutex.TryLock() {
+		return &LockBusyError{Subsystem: subsystem, Holder: readLockInfo(path)}
+	}
+	err := lockFile(subsystem, path)
+	if err != nil {
+		mutex.Unlock()
+	}
+	return err
+}
+
+// Take the flock on a subsystem's lock file, with its mutex already held.
+func lockFile(subsystem string, path string) error {
+	err := os.MkdirAll(LockDirectory, 0755)
+	if err != nil {
+		return fmt.Errorf("无法创建锁目录: %v", err)
+	}
+	writable := true
+	file, err := openSharedFile(path, os.O_RDWR)
+	if errors.Is(err, os.ErrPermission) {
+		// Left by root before lock files were handed over, a read-only descriptor can still be locked.
+		file, err = os.Open(path)
+		writable = false
+	}
+	if err != nil {
+		return fmt.Errorf("无法打开锁文件 %s: %v", path, err)
+	}
+
+	err = unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err != nil {
+		file.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return &LockBusyError{Subsystem: subsystem, Holder: readLockInfo(path)}
+		}
+		return fmt.Errorf("无法锁定 %s: %v", path, err)
+	}
+
+	// The holder clears the file on release, anything left means it died mid-operation.
+	if previous := readLockInfo(path); previous.PID != 0 {
+		CryoUtils.ErrorLog.Println("清除过期的锁", path, "PID", previous.PID, previous.Caller,
+			"开始于", previous.Since.Local().Format(time.RFC3339), "进程仍在运行:", isProcessRunning(previous.PID))
+	}
+	if writable {
+		data, _ := json.Marshal(LockInfo{PID: os.Getpid(), Caller: CryoUtils.Caller, Since: time.Now()})
+		if file.Truncate(0) != nil {
+			CryoUtils.ErrorLog.Println("无法写入锁文件", path)
+		} else {
+			_, _ = file.WriteAt(data, 0)
+		}
+	}
+	heldLocksMutex.Lock()
+	heldLocks[subsystem] = file
+	heldLocksMutex.Unlock()
+	return nil
+}
+
+func releaseLock(subsystem string) {
+	heldLocksMutex.Lock()
+	file, ok := heldLocks[subsystem]
+	delete(heldLocks, subsystem)
+	heldLocksMutex.Unlock()
+	if !ok {
+		return
+	}
+	_ = file.Truncate(0)
+	_ = unix.Flock(int(file.Fd()), unix.LOCK_UN)
+	file.Close()
+	getSubsystemMutex(subsystem).Unlock()
+}
+
+// Run fn while holding a subsystem's lock.
+func withLock(subsystem string, fn func() error) error {
+	release, err := acquireLock(subsystem)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn()
+}
+
+// ParseLockFlags Take '--wait' out of the arguments. Alone it waits for a busy lock as long as it
+// takes, '--wait=DURATION' waits up to DURATION. Without it a busy lock fails at once.
+func ParseLockFlags(args []string) (time.Duration, []string, error) {
+	var wait time.Duration
+	var rest []string
+	for _, arg := range args {
+		if arg == "--wait" {
+			wait = -1
+			continue
+		}
+		value, found := strings.CutPrefix(arg, "--wait=")
+		if !found {
+			rest = append(rest, arg)
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return 0, nil, fmt.Errorf("invalid --wait duration: %s", value)
+		}
+		wait = duration
+	}
+	return wait, rest, nil
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func useLockDirectory(t *testing.T, wait time.Duration) {
+	oldDirectory, oldWait, oldInterval := LockDirectory, CryoUtils.LockWait, LockPollInterval
+	LockDirectory = t.TempDir()
+	CryoUtils.LockWait = wait
+	LockPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { LockDirectory, CryoUtils.LockWait, LockPollInterval = oldDirectory, oldWait, oldInterval })
+}
+
+// Hold a lock the way another process would, through a descriptor of its own.
+func holdLock(t *testing.T, subsystem string, info string) *os.File {
+	file, err := os.OpenFile(getLockPath(subsystem), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.WriteString(info); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestLockInProcess(t *testing.T) {
+	useLockDirectory(t, 0)
+	release, err := acquireLock(LockSwap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := readLockInfo(getLockPath(LockSwap)); info.PID != os.Getpid() {
+		t.Errorf("lock held by PID %d, want %d", info.PID, os.Getpid())
+	}
+	// Another goroutine of the same process is kept out too.
+	var busy *LockBusyError
+	done := make(chan error)
+	go func() { done <- withLock(LockSwap, func() error { return nil }) }()
+	if err = <-done; !errors.As(err, &busy) {
+		t.Errorf("withLock() from another goroutine = %v, want a LockBusyError", err)
+	}
+
+	// And one willing to wait gets it once released.
+	CryoUtils.LockWait = 5 * time.Second
+	go func() { done <- withLock(LockSwap, func() error { return nil }) }()
+	time.Sleep(50 * time.Millisecond)
+	release()
+	release()
+	if err = <-done; err != nil {
+		t.Errorf("withLock() after release = %v", err)
+	}
+	if info := readLockInfo(getLockPath(LockSwap)); info.PID != 0 {
+		t.Errorf("lock file not cleared on release: %+v", info)
+	}
+	if _, held := heldLocks[LockSwap]; held {
+		t.Errorf("lock still counted as held")
+	}
+}
+
+func TestLockBusy(t *testing.T) {
+	useLockDirectory(t, 0)
+	holdLock(t, LockGameData, `{"pid":1,"caller":"cli swap","since":"2024-01-01T10:00:00Z"}`)
+
+	_, err := acquireLock(LockGameData)
+	var busy *LockBusyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("acquireLock() = %v, want a LockBusyError", err)
+	}
+	if busy.Subsystem != LockGameData || busy.Holder.PID != 1 || busy.Holder.Caller != "cli swap" {
+		t.Errorf("busy = %+v", busy)
+	}
+	if !strings.Contains(err.Error(), "PID 1") {
+		t.Errorf("Error() = %q, want the PID", err.Error())
+	}
+	// Other subsystems aren't affected.
+	if err = withLock(LockTunables, func() error { return nil }); err != nil {
+		t.Errorf("withLock(tunables) = %v", err)
+	}
+}
+
+func TestLockWait(t *testing.T) {
+	useLockDirectory(t, 5*time.Second)
+	file := holdLock(t, LockTunables, `{"pid":1}`)
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = file.Truncate(0)
+		_ = unix.Flock(int(file.Fd()), unix.LOCK_UN)
+		close(released)
+	}()
+	release, err := acquireLock(LockTunables)
+	<-released
+	if err != nil {
+		t.Fatalf("acquireLock() while waiting = %v", err)
+	}
+	release()
+
+	CryoUtils.LockWait = 30 * time.Millisecond
+	holdLock(t, LockTunables, `{"pid":1}`)
+	start := time.Now()
+	_, err = acquireLock(LockTunables)
+	var busy *LockBusyError
+	if !errors.As(err, &busy) {
+		t.Errorf("acquireLock() after the wait ran out = %v, want a LockBusyError", err)
+	}
+	if time.Since(start) < CryoUtils.LockWait {
+		t.Errorf("gave up after %v, before the wait ran out", time.Since(start))
+	}
+}
+
+func TestLockStale(t *testing.T) {
+	useLockDirectory(t, 0)
+	// Left behind by a process that died holding it, the kernel already dropped the lock.
+	err := os.WriteFile(getLockPath(LockSwap), []byte(`{"pid":999999999,"caller":"gui"}`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isProcessRunning(999999999) {
+		t.Skip("PID 999999999 exists")
+	}
+	err = withLock(LockSwap, func() error {
+		if info := readLockInfo(getLockPath(LockSwap)); info.PID != os.Getpid() {
+			t.Errorf("stale lock not replaced: %+v", info)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("withLock() over a stale lock = %v", err)
+	}
+}
+
+func TestParseLockFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantWait time.Duration
+		wantRest []string
+		wantErr  bool
+	}{
+		{[]string{"swap", "8"}, 0, []string{"swap", "8"}, false},
+		{[]string{"--wait", "swap", "8"}, -1, []string{"swap", "8"}, false},
+		{[]string{"swap", "--wait=5m", "8"}, 5 * time.Minute, []string{"swap", "8"}, false},
+		{[]string{"--wait=soon"}, 0, nil, true},
+		{[]string{"--wait=-1s"}, 0, nil, true},
+	}
+	for _, tt := range tests {
+		wait, rest, err := ParseLockFlags(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLockFlags(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if wait != tt.wantWait || !reflect.DeepEqual(rest, tt.wantRest) {
+			t.Errorf("ParseLockFlags(%v) = %v, %v, want %v, %v", tt.args, wait, rest, tt.wantWait, tt.wantRest)
+		}
+	}
+}
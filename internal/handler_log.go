@@ -0,0 +1,396 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// The file and line log.Lshortfile puts in front of a message.
+var logCallerRegex = regexp.MustCompile(`^([\w.-]+\.go:\d+): `)
+
+// LogLevel How important a log message is.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// syslog priorities, as the journal expects them.
+var logLevelPriorities = []int{7, 6, 4, 3}
+
+func (l LogLevel) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "LEVEL" + strconv.Itoa(int(l))
+	}
+	return logLevelNames[l]
+}
+
+// Parse a level name, ex: "debug" or "WARN".
+func parseLogLevel(name string) (LogLevel, error) {
+	for i, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) || (levelName == "WARN" && strings.EqualFold(name, "warning")) {
+			return LogLevel(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %s", name)
+}
+
+// LogOptions Where log messages go, and how important they have to be to get there.
+type LogOptions struct {
+	Level LogLevel
+	// Stderr Show warnings and errors on the terminal, or everything when Verbose.
+	Stderr  bool
+	Verbose bool
+	Journal bool
+}
+
+// ParseLogFlags Take the logging flags out of the command line, wherever they are, leaving the rest
+// for the command. Accepts --log-level <level>, --log-level=<level>, --verbose (-v) and --journal.
+func ParseLogFlags(args []string) (LogOptions, []string, error) {
+	opts := LogOptions{Level: LevelInfo}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--verbose" || arg == "-v":
+			// Verbose shows everything, on the terminal too.
+			opts.Stderr = true
+			opts.Verbose = true
+			opts.Level = LevelDebug
+		case arg == "--journal":
+			opts.Journal = true
+		case arg == "--log-level" || strings.HasPrefix(arg, "--log-level="):
+			name, found := strings.CutPrefix(arg, "--log-level=")
+			if !found {
+				if i+1 >= len(args) {
+					return opts, nil, fmt.Errorf("--log-level needs a level")
+				}
+				i++
+				name = args[i]
+			}
+			level, err := parseLogLevel(name)
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.Level = level
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return opts, rest, nil
+}
+
+// Logger Writes leveled messages with key=value fields to a rotating file, and optionally to
+// stderr and the systemd journal.
+type Logger struct {
+	mu      sync.Mutex
+	opts    LogOptions
+	path    string
+	file    *os.File
+	size    int64
+	stderr  io.Writer
+	journal net.Conn
+}
+
+// NewLogger Start a log for this run, keeping the previous runs' logs as backups. With an empty path
+// nothing goes to a file.
+func NewLogger(path string, opts LogOptions) (*Logger, error) {
+	l := &Logger{opts: opts, path: path}
+	if path != "" {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return nil, err
+		}
+		// Keep the last run's log, crash reports need it most.
+		info, err := os.Stat(path)
+		if err == nil && info.Size() > 0 {
+			err = rotateLogFiles(path, LogBackups)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = l.openFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Stderr {
+		l.stderr = os.Stderr
+	}
+	if opts.Journal {
+		conn, err := net.Dial("unixgram", JournalSocketPath)
+		if err != nil {
+			// Not fatal, there's still the file.
+			fmt.Fprintln(os.Stderr, "无法连接到 systemd 日志:", err)
+		} else {
+			l.journal = conn
+		}
+	}
+	return l, nil
+}
+
+func (l *Logger) openFile() error {
+	file, err := openSharedFile(l.path, os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Shift path to path.1, path.1 to path.2 and so on, dropping anything past backups. Used for both the
+// run logs and the audit log.
+func rotateLogFiles(path string, backups int) error {
+	if backups <= 0 {
+		return os.Remove(path)
+	}
+	for i := backups; i > 0; i-- {
+		from := path
+		if i > 1 {
+			from = path + "." + strconv.Itoa(i-1)
+		}
+		err := os.Rename(from, path+"."+strconv.Itoa(i))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close Stop writing the log.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var err error
+	if l.file != nil {
+		err = l.file.Close()
+		l.file = nil
+	}
+	if l.journal != nil {
+		l.journal.Close()
+		l.journal = nil
+	}
+	return err
+}
+
+func (l *Logger) Debug(msg string, fields ...any) { l.Log(LevelDebug, msg, fields...) }
+func (l *Logger) Info(msg string, fields ...any)  { l.Log(LevelInfo, msg, fields...) }
+func (l *Logger) Warn(msg string, fields ...any)  { l.Log(LevelWarn, msg, fields...) }
+func (l *Logger) Error(msg string, fields ...any) { l.Log(LevelError, msg, fields...) }
+
+// Log Write a message if it's important enough. fields are key, value pairs, ex: "param", "swappiness".
+func (l *Logger) Log(level LogLevel, msg string, fields ...any) {
+	// Tests and the helper may have no logger.
+	if l == nil || level < l.opts.Level {
+		return
+	}
+	now := time.Now()
+	line := formatLogLine(now, level, msg, fields)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil && l.size+int64(len(line)) > LogMaxSize {
+		l.file.Close()
+		l.file = nil
+		// Without a file the message still reaches stderr and the journal.
+		if rotateLogFiles(l.path, LogBackups) == nil {
+			_ = l.openFile()
+		}
+	}
+	if l.file != nil {
+		n, _ := io.WriteString(l.file, line)
+		l.size += int64(n)
+	}
+	if l.stderr != nil && (l.opts.Verbose || level >= LevelWarn) {
+		_, _ = io.WriteString(l.stderr, line)
+	}
+	if l.journal != nil {
+		_, _ = l.journal.Write(formatJournalMessage(level, msg, fields))
+	}
+}
+
+// Adapter Get a log.Logger writing every line at one level, so the existing InfoLog and ErrorLog keep working.
+func (l *Logger) Adapter(level LogLevel, flags int) *log.Logger {
+	return log.New(logAdapter{logger: l, level: level}, "", flags)
+}
+
+type logAdapter struct {
+	logger *Logger
+	level  LogLevel
+}
+
+func (a logAdapter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	// log.Lshortfile's file:line becomes a field, so the message stays readable.
+	if match := logCallerRegex.FindStringSubmatch(msg); match != nil {
+		a.logger.Log(a.level, msg[len(match[0]):], "caller", match[1])
+	} else {
+		a.logger.Log(a.level, msg)
+	}
+	return len(p), nil
+}
+
+// UseLogger Send everything the program logs to a logger.
+func UseLogger(l *Logger) {
+	CryoUtils.Log = l
+	CryoUtils.InfoLog = l.Adapter(LevelInfo, 0)
+	CryoUtils.ErrorLog = l.Adapter(LevelError, log.Lshortfile)
+	log.SetFlags(0)
+	log.SetOutput(logAdapter{logger: l, level: LevelInfo})
+}
+
+// Format one line of the log: time, level, message and fields, quoted only where needed.
+func formatLogLine(t time.Time, level LogLevel, msg string, fields []any) string {
+	var b strings.Builder
+	b.WriteString(t.Format("2006-01-02T15:04:05.000Z07:00"))
+	fmt.Fprintf(&b, " %-5s ", level)
+	b.WriteString(quoteLogValue(msg, false))
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		value := "MISSING"
+		if i+1 < len(fields) {
+			value = fmt.Sprint(fields[i+1])
+		}
+		b.WriteString(" " + key + "=" + quoteLogValue(value, true))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// Quote a value that would otherwise be ambiguous. Messages only need it for line breaks.
+func quoteLogValue(value string, field bool) string {
+	if strings.ContainsAny(value, "\n\r\"") || (field && (value == "" || strings.ContainsAny(value, " =\t"))) {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// Format a message in the journal's native protocol, every field in the binary form so anything may be in it.
+func formatJournalMessage(level LogLevel, msg string, fields []any) []byte {
+	var b bytes.Buffer
+	writeField := func(key string, value string) {
+		b.WriteString(key + "\n")
+		_ = binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+		b.WriteString(value + "\n")
+	}
+	priority := logLevelPriorities[LevelInfo]
+	if level >= LevelDebug && level <= LevelError {
+		priority = logLevelPriorities[level]
+	}
+	writeField("PRIORITY", strconv.Itoa(priority))
+	writeField("SYSLOG_IDENTIFIER", "cryoutilities")
+	writeField("MESSAGE", msg)
+	for i := 0; i+1 < len(fields); i += 2 {
+		writeField("CRYO_"+journalFieldName(fmt.Sprint(fields[i])), fmt.Sprint(fields[i+1]))
+	}
+	return b.Bytes()
+}
+
+// Get a valid journal field name, uppercase letters, digits and underscores only.
+func journalFieldName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// LogLine One parsed line of the log file.
+type LogLine struct {
+	Level LogLevel
+	Text  string
+}
+
+// Read the last lines of a log at or above a level. Lines that can't be parsed, ex: from older
+// versions, are kept as info.
+func readLogLines(path string, level LogLevel, limit int) ([]LogLine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []LogLine
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := LogLine{Level: LevelInfo, Text: scanner.Text()}
+		fields := strings.Fields(line.Text)
+		if len(fields) > 1 {
+			if parsed, err := parseLogLevel(fields[1]); err == nil {
+				line.Level = parsed
+			}
+		}
+		if line.Level < level {
+			continue
+		}
+		lines = append(lines, line)
+		if limit > 0 && len(lines) > limit {
+			lines = lines[1:]
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// Get the paths of the current log and its backups, newest first, skipping any that don't exist.
+func getLogFiles(path string) []string {
+	var files []string
+	for i := 0; i <= LogBackups; i++ {
+		candidate := path
+		if i > 0 {
+			candidate = path + "." + strconv.Itoa(i)
+		}
+		if doesFileExist(candidate) {
+			files = append(files, candidate)
+		}
+	}
+	return files
+}
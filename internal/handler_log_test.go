@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantOpts LogOptions
+		wantRest []string
+		wantErr  bool
+	}{
+		{[]string{"swap", "8"}, LogOptions{Level: LevelInfo}, []string{"swap", "8"}, false},
+		{[]string{"--verbose", "swap", "8"}, LogOptions{Level: LevelDebug, Stderr: true, Verbose: true}, []string{"swap", "8"}, false},
+		{[]string{"watch", "--power", "--log-level=warn"}, LogOptions{Level: LevelWarn}, []string{"watch", "--power"}, false},
+		{[]string{"--log-level", "error", "--journal", "gui"}, LogOptions{Level: LevelError, Journal: true}, []string{"gui"}, false},
+		{[]string{"--log-level"}, LogOptions{}, nil, true},
+		{[]string{"--log-level=loud"}, LogOptions{}, nil, true},
+	}
+	for _, tt := range tests {
+		opts, rest, err := ParseLogFlags(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLogFlags(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (opts != tt.wantOpts || !reflect.DeepEqual(rest, tt.wantRest)) {
+			t.Errorf("ParseLogFlags(%v) = %+v, %v, want %+v, %v", tt.args, opts, rest, tt.wantOpts, tt.wantRest)
+		}
+	}
+}
+
+func TestFormatLogLine(t *testing.T) {
+	now := time.Date(2023, 5, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		level  LogLevel
+		msg    string
+		fields []any
+		want   string
+	}{
+		{LevelInfo, "正在写入 1", nil, "2023-05-02T12:00:00.000Z INFO  正在写入 1\n"},
+		{LevelError, "failed", []any{"param", "swappiness", "exit_code", 1},
+			"2023-05-02T12:00:00.000Z ERROR failed param=swappiness exit_code=1\n"},
+		{LevelWarn, "two\nlines", []any{"path", "/home/my games", "empty", ""},
+			`2023-05-02T12:00:00.000Z WARN  "two\nlines" path="/home/my games" empty=""` + "\n"},
+		{LevelDebug, "odd", []any{"key"}, "2023-05-02T12:00:00.000Z DEBUG odd key=MISSING\n"},
+	}
+	for _, tt := range tests {
+		if got := formatLogLine(now, tt.level, tt.msg, tt.fields); got != tt.want {
+			t.Errorf("formatLogLine() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cryoutilities.log")
+	oldSize, oldBackups := LogMaxSize, LogBackups
+	LogMaxSize, LogBackups = 400, 2
+	t.Cleanup(func() { LogMaxSize, LogBackups = oldSize, oldBackups })
+	if err := os.WriteFile(path, []byte("last run\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger, err := NewLogger(path, LogOptions{Level: LevelInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	logger.stderr = &stderr
+	logger.Debug("hidden")
+	logger.Adapter(LevelInfo, 0).Println("正在写入", "1")
+	logger.Adapter(LevelError, 0).Println("handler_swap.go:42: 禁用交换时出错")
+	logger.Close()
+
+	data, err := os.ReadFile(path + ".1")
+	if err != nil || string(data) != "last run\n" {
+		t.Errorf("previous run's log = %q, %v", data, err)
+	}
+	lines, err := readLogLines(path, LevelDebug, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || !strings.HasSuffix(lines[0].Text, "INFO  正在写入 1") ||
+		lines[1].Level != LevelError || !strings.HasSuffix(lines[1].Text, "禁用交换时出错 caller=handler_swap.go:42") {
+		t.Errorf("log lines = %+v", lines)
+	}
+	// Only warnings and errors reach the terminal unless verbose.
+	if strings.Count(stderr.String(), "\n") != 1 || !strings.Contains(stderr.String(), "ERROR") {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+	if lines, _ = readLogLines(path, LevelWarn, 0); len(lines) != 1 {
+		t.Errorf("readLogLines() at warn = %+v, want only the error", lines)
+	}
+
+	// Rotates by size too, keeping LogBackups files.
+	logger, err = NewLogger(path, LogOptions{Level: LevelInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		logger.Info("filling up the log", "i", i)
+	}
+	logger.Close()
+	if got := getLogFiles(path); len(got) != 3 {
+		t.Errorf("getLogFiles() = %v, want the log and 2 backups", got)
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more backups kept than LogBackups")
+	}
+}
+
+func TestFormatJournalMessage(t *testing.T) {
+	got := formatJournalMessage(LevelWarn, "a\nb", []any{"swap-file", "/home/swapfile"})
+	field := func(key, value string) string {
+		var b bytes.Buffer
+		b.WriteString(key + "\n")
+		_ = binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+		b.WriteString(value + "\n")
+		return b.String()
+	}
+	want := field("PRIORITY", "4") + field("SYSLOG_IDENTIFIER", "cryoutilities") + field("MESSAGE", "a\nb") +
+		field("CRYO_SWAP_FILE", "/home/swapfile")
+	if string(got) != want {
+		t.Errorf("formatJournalMessage() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,197 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// SwapDevice One line of /proc/swaps, sizes are in kB.
+type SwapDevice struct {
+	Filename string `json:"filename"`
+	Type     string `json:"type"`
+	Size     int64  `json:"size"`
+	Used     int64  `json:"used"`
+	Priority int    `json:"priority"`
+}
+
+// MemoryUsage A breakdown of memory and swap use, sizes are in kB and rates in pages per second.
+type MemoryUsage struct {
+	Total          int64        `json:"total"`
+	Used           int64        `json:"used"`
+	Available      int64        `json:"available"`
+	Cached         int64        `json:"cached"`
+	Anonymous      int64        `json:"anonymous"`
+	AnonHugePages  int64        `json:"anon_huge_pages"`
+	ShmemHugePages int64        `json:"shmem_huge_pages"`
+	SwapCached     int64        `json:"swap_cached"`
+	SwapTotal      int64        `json:"swap_total"`
+	SwapUsed       int64        `json:"swap_used"`
+	Swaps          []SwapDevice `json:"swaps"`
+	SwapInRate     float64      `json:"swap_in_rate"`
+	SwapOutRate    float64      `json:"swap_out_rate"`
+}
+
+// MemoryUsageSampler Remembers the previous sample, so swap activity can be given as a rate.
+type MemoryUsageSampler struct {
+	procRoot    string
+	lastTime    time.Time
+	lastSwapIn  int64
+	lastSwapOut int64
+}
+
+// Parse a "key value" proc file like meminfo or vmstat, ignoring any unit suffix.
+func readProcKeyValues(path string) (map[string]int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := map[string]int64{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = value
+	}
+	return values, scanner.Err()
+}
+
+// Read /proc/meminfo, values are in kB.
+func readMeminfo(procRoot string) (map[string]int64, error) {
+	return readProcKeyValues(filepath.Join(procRoot, "meminfo"))
+}
+
+// Read /proc/vmstat, values are counters or pages.
+func readVMStat(procRoot string) (map[string]int64, error) {
+	return readProcKeyValues(filepath.Join(procRoot, "vmstat"))
+}
+
+// Get every active swap device or file.
+func getSwapDevices(procRoot string) ([]SwapDevice, error) {
+	file, err := os.Open(filepath.Join(procRoot, "swaps"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var swaps []SwapDevice
+	scanner := bufio.NewScanner(file)
+	// skip the first line (header)
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[2], 10, 64)
+		used, _ := strconv.ParseInt(fields[3], 10, 64)
+		priority, _ := strconv.Atoi(fields[4])
+		swaps = append(swaps, SwapDevice{
+			// Spaces in paths are escaped as \040
+			Filename: strings.ReplaceAll(fields[0], "\\040", " "),
+			Type:     fields[1],
+			Size:     size,
+			Used:     used,
+			Priority: priority,
+		})
+	}
+	return swaps, scanner.Err()
+}
+
+func newMemoryUsageSampler(procRoot string) *MemoryUsageSampler {
+	return &MemoryUsageSampler{procRoot: procRoot}
+}
+
+// Take a sample of memory use, the swap rates are 0 on the first sample.
+func (s *MemoryUsageSampler) sample() (MemoryUsage, error) {
+	var usage MemoryUsage
+	meminfo, err := readMeminfo(s.procRoot)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return usage, fmt.Errorf("读取内存信息时出错")
+	}
+	vmstat, err := readVMStat(s.procRoot)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return usage, fmt.Errorf("读取虚拟内存统计时出错")
+	}
+	usage.Swaps, err = getSwapDevices(s.procRoot)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return usage, fmt.Errorf("读取交换设备时出错")
+	}
+
+	usage.Total = meminfo["MemTotal"]
+	usage.Available = meminfo["MemAvailable"]
+	usage.Used = usage.Total - usage.Available
+	usage.Cached = meminfo["Cached"] + meminfo["Buffers"]
+	usage.Anonymous = meminfo["AnonPages"]
+	usage.AnonHugePages = meminfo["AnonHugePages"]
+	usage.ShmemHugePages = meminfo["ShmemHugePages"]
+	usage.SwapCached = meminfo["SwapCached"]
+	usage.SwapTotal = meminfo["SwapTotal"]
+	usage.SwapUsed = meminfo["SwapTotal"] - meminfo["SwapFree"]
+
+	now := time.Now()
+	if !s.lastTime.IsZero() {
+		elapsed := now.Sub(s.lastTime).Seconds()
+		if elapsed > 0 {
+			usage.SwapInRate = float64(vmstat["pswpin"]-s.lastSwapIn) / elapsed
+			usage.SwapOutRate = float64(vmstat["pswpout"]-s.lastSwapOut) / elapsed
+		}
+	}
+	s.lastTime = now
+	s.lastSwapIn = vmstat["pswpin"]
+	s.lastSwapOut = vmstat["pswpout"]
+	return usage, nil
+}
+
+// Format the breakdown for display.
+func (u MemoryUsage) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "已用: %s / %s  可用: %s  缓存: %s\n",
+		getHumanMemorySize(u.Used), getHumanMemorySize(u.Total),
+		getHumanMemorySize(u.Available), getHumanMemorySize(u.Cached))
+	fmt.Fprintf(&b, "匿名: %s  AnonHugePages: %s  ShmemHugePages: %s  SwapCached: %s\n",
+		getHumanMemorySize(u.Anonymous), getHumanMemorySize(u.AnonHugePages),
+		getHumanMemorySize(u.ShmemHugePages), getHumanMemorySize(u.SwapCached))
+	fmt.Fprintf(&b, "交换: %s / %s  换入: %.0f 页/秒  换出: %.0f 页/秒",
+		getHumanMemorySize(u.SwapUsed), getHumanMemorySize(u.SwapTotal), u.SwapInRate, u.SwapOutRate)
+	for _, swap := range u.Swaps {
+		fmt.Fprintf(&b, "\n  %s: %s / %s", swap.Filename, getHumanMemorySize(swap.Used), getHumanMemorySize(swap.Size))
+	}
+	return b.String()
+}
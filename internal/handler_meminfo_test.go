@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadProcKeyValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     map[string]int64
+	}{
+		{
+			name:     "Meminfo",
+			contents: "MemTotal:       16039536 kB\nMemAvailable:    9873212 kB\nHugePages_Total:       0\n",
+			want:     map[string]int64{"MemTotal": 16039536, "MemAvailable": 9873212, "HugePages_Total": 0},
+		},
+		{
+			name:     "Vmstat",
+			contents: "pswpin 1024\npswpout 4096\n",
+			want:     map[string]int64{"pswpin": 1024, "pswpout": 4096},
+		},
+		{
+			name:     "Malformed lines",
+			contents: "MemTotal:\nMemFree: lots kB\n\nSwapTotal: 8388604 kB\n",
+			want:     map[string]int64{"SwapTotal": 8388604},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "meminfo")
+			err := os.WriteFile(path, []byte(tt.contents), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := readProcKeyValues(path)
+			if err != nil {
+				t.Fatalf("readProcKeyValues() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readProcKeyValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := readProcKeyValues(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("readProcKeyValues() of a missing file should fail")
+	}
+}
+
+func TestGetSwapDevices(t *testing.T) {
+	tests := []struct {
+		name  string
+		swaps string
+		want  []SwapDevice
+	}{
+		{
+			name:  "No swap",
+			swaps: "Filename\t\t\t\tType\t\tSize\t\tUsed\t\tPriority\n",
+			want:  nil,
+		},
+		{
+			name: "Swap file and zram",
+			swaps: "Filename\t\t\t\tType\t\tSize\t\tUsed\t\tPriority\n" +
+				"/home/swapfile                          file\t\t8388604\t\t1048576\t\t-2\n" +
+				"/dev/zram0                              partition\t4194300\t\t0\t\t100\n",
+			want: []SwapDevice{
+				{Filename: "/home/swapfile", Type: "file", Size: 8388604, Used: 1048576, Priority: -2},
+				{Filename: "/dev/zram0", Type: "partition", Size: 4194300, Used: 0, Priority: 100},
+			},
+		},
+		{
+			name: "Escaped space",
+			swaps: "Filename\t\t\t\tType\t\tSize\t\tUsed\t\tPriority\n" +
+				"/home/my\\040swap                        file\t\t1048572\t\t0\t\t-2\n",
+			want: []SwapDevice{{Filename: "/home/my swap", Type: "file", Size: 1048572, Priority: -2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procRoot := t.TempDir()
+			err := os.WriteFile(filepath.Join(procRoot, "swaps"), []byte(tt.swaps), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := getSwapDevices(procRoot)
+			if err != nil {
+				t.Fatalf("getSwapDevices() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getSwapDevices() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
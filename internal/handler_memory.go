@@ -166,10 +166,14 @@ func TogglePageLockUnfairness() error {
 }
 
 func SetHugePages() error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	CryoUtils.InfoLog.Println("启用大页面...")
-	// Remove a file accidentally included in a beta for testing
-	_ = removeFile(NHPTestingFile)
-	err := setUnitValue("hugepages", RecommendedHugePages)
+	err = setUnitValue("hugepages", RecommendedHugePages)
 	if err != nil {
 		return err
 	}
@@ -181,8 +185,14 @@ func SetHugePages() error {
 }
 
 func RevertHugePages() error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	CryoUtils.InfoLog.Println("禁用大页面...")
-	err := setUnitValue("hugepages", DefaultHugePages)
+	err = setUnitValue("hugepages", DefaultHugePages)
 	if err != nil {
 		return err
 	}
@@ -194,8 +204,14 @@ func RevertHugePages() error {
 }
 
 func SetCompactionProactiveness() error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	CryoUtils.InfoLog.Println("设置压缩主动性...")
-	err := setUnitValue("compaction_proactiveness", RecommendedCompactionProactiveness)
+	err = setUnitValue("compaction_proactiveness", RecommendedCompactionProactiveness)
 	if err != nil {
 		return err
 	}
@@ -207,8 +223,14 @@ func SetCompactionProactiveness() error {
 }
 
 func RevertCompactionProactiveness() error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	CryoUtils.InfoLog.Println("禁用压缩主动性...")
-	err := setUnitValue("compaction_proactiveness", DefaultCompactionProactiveness)
+	err = setUnitValue("compaction_proactiveness", DefaultCompactionProactiveness)
 	if err != nil {
 		return err
 	}
@@ -220,8 +242,14 @@ func RevertCompactionProactiveness() error {
 }
 
 func SetPageLockUnfairness() error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	CryoUtils.InfoLog.Println("启用页面锁定不公平...")
-	err := setUnitValue("page_lock_unfairness", RecommendedPageLockUnfairness)
+	err = setUnitValue("page_lock_unfairness", RecommendedPageLockUnfairness)
 	if err != nil {
 		return err
 	}
@@ -233,8 +261,14 @@ func SetPageLockUnfairness() error {
 }
 
 func RevertPageLockUnfairness() error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	CryoUtils.InfoLog.Println("禁用页面锁定不公平...")
-	err := setUnitValue("page_lock_unfairness", DefaultPageLockUnfairness)
+	err = setUnitValue("page_lock_unfairness", DefaultPageLockUnfairness)
 	if err != nil {
 		return err
 	}
@@ -246,8 +280,14 @@ func RevertPageLockUnfairness() error {
 }
 
 func SetShMem() error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	CryoUtils.InfoLog.Println("启用内存管理 shmem_enabled")
-	err := setUnitValue("shmem_enabled", RecommendedShMem)
+	err = setUnitValue("shmem_enabled", RecommendedShMem)
 	if err != nil {
 		return err
 	}
@@ -259,8 +299,14 @@ func SetShMem() error {
 }
 
 func RevertShMem() error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	CryoUtils.InfoLog.Println("禁用内存管理 shmem_enabled")
-	err := setUnitValue("shmem_enabled", DefaultShMem)
+	err = setUnitValue("shmem_enabled", DefaultShMem)
 	if err != nil {
 		return err
 	}
@@ -272,8 +318,14 @@ func RevertShMem() error {
 }
 
 func SetDefrag() error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	CryoUtils.InfoLog.Println("启用内存管理 shmem_enabled")
-	err := setUnitValue("defrag", RecommendedHugePageDefrag)
+	err = setUnitValue("defrag", RecommendedHugePageDefrag)
 	if err != nil {
 		return err
 	}
@@ -285,8 +337,14 @@ func SetDefrag() error {
 }
 
 func RevertDefrag() error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	CryoUtils.InfoLog.Println("禁用内存管理 shmem_enabled")
-	err := setUnitValue("defrag", DefaultHugePageDefrag)
+	err = setUnitValue("defrag", DefaultHugePageDefrag)
 	if err != nil {
 		return err
 	}
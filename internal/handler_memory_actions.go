@@ -0,0 +1,136 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// MemoryActionResult How a one-off memory action changed fragmentation and the page cache.
+type MemoryActionResult struct {
+	Action           string        `json:"action"`
+	Duration         time.Duration `json:"duration"`
+	HugeBlocksBefore int64         `json:"huge_blocks_before"`
+	HugeBlocksAfter  int64         `json:"huge_blocks_after"`
+	CachedBefore     int64         `json:"cached_before"`
+	CachedAfter      int64         `json:"cached_after"`
+}
+
+// Count the free blocks large enough for a huge page, from /proc/buddyinfo.
+// Each line is "Node 0, zone Normal" followed by the free block count of every order, smallest first.
+func getFreeHugePageBlocks(procRoot string) (int64, error) {
+	file, err := os.Open(filepath.Join(procRoot, "buddyinfo"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var blocks int64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		for order, field := range fields[4:] {
+			if order < HugePageOrder {
+				continue
+			}
+			count, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("无法解析 buddyinfo: %s", scanner.Text())
+			}
+			// A free block of a higher order holds several huge pages.
+			blocks += count << (order - HugePageOrder)
+		}
+	}
+	return blocks, scanner.Err()
+}
+
+// Run a memory action, measuring fragmentation and the page cache either side of it.
+func runMemoryAction(name string, action func() error) (MemoryActionResult, error) {
+	result := MemoryActionResult{Action: name}
+	var err error
+	result.HugeBlocksBefore, err = getFreeHugePageBlocks(ProcRoot)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return result, fmt.Errorf("读取内存碎片信息时出错")
+	}
+	meminfo, err := readMeminfo(ProcRoot)
+	if err == nil {
+		result.CachedBefore = meminfo["Cached"]
+	}
+
+	CryoUtils.InfoLog.Println("正在执行:", name)
+	start := time.Now()
+	err = action()
+	if err != nil {
+		return result, err
+	}
+	result.Duration = time.Since(start)
+
+	result.HugeBlocksAfter, err = getFreeHugePageBlocks(ProcRoot)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return result, fmt.Errorf("读取内存碎片信息时出错")
+	}
+	meminfo, err = readMeminfo(ProcRoot)
+	if err == nil {
+		result.CachedAfter = meminfo["Cached"]
+	}
+	CryoUtils.InfoLog.Println(result.String())
+	return result, nil
+}
+
+// CompactMemory Compact all memory now, to make room for huge pages.
+func CompactMemory() (MemoryActionResult, error) {
+	return runMemoryAction("压缩内存", func() error {
+		return writeKernelValue(CompactMemoryPath, "1")
+	})
+}
+
+// DropCaches Write out dirty pages and drop the page cache.
+func DropCaches() (MemoryActionResult, error) {
+	return runMemoryAction("清除页面缓存", func() error {
+		// Dirty pages can't be dropped, so write them out first.
+		_, err := exec.Command("sync").Output()
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+		}
+		return writeKernelValue(DropCachesPath, DropCachesValue)
+	})
+}
+
+// Format the result for display.
+func (r MemoryActionResult) String() string {
+	return fmt.Sprintf("%s 完成，用时 %s\n可用大页面块 (2MB): %d -> %d\n页面缓存: %s -> %s",
+		r.Action, r.Duration.Round(time.Millisecond),
+		r.HugeBlocksBefore, r.HugeBlocksAfter,
+		getHumanMemorySize(r.CachedBefore), getHumanMemorySize(r.CachedAfter))
+}
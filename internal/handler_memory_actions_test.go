@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetFreeHugePageBlocks(t *testing.T) {
+	tests := []struct {
+		name      string
+		buddyinfo string
+		want      int64
+		wantErr   bool
+	}{
+		{
+			name:      "Empty",
+			buddyinfo: "",
+			want:      0,
+		},
+		{
+			name: "Several zones",
+			// Order 9 counts once, order 10 holds two huge pages.
+			buddyinfo: "Node 0, zone      DMA      0      0      0      0      0      0      0      0      1      1      3\n" +
+				"Node 0, zone   Normal   3953   1471   1428    811    226    177    194    121     50     44     72\n",
+			want: 1 + 3*2 + 44 + 72*2,
+		},
+		{
+			name:      "Damaged",
+			buddyinfo: "Node 0, zone Normal 1 2 3 4 5 6 7 8 9 x 11\n",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procRoot := t.TempDir()
+			err := os.WriteFile(filepath.Join(procRoot, "buddyinfo"), []byte(tt.buddyinfo), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := getFreeHugePageBlocks(procRoot)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getFreeHugePageBlocks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("getFreeHugePageBlocks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
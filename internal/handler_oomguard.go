@@ -0,0 +1,321 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// OOMVictim A process the OOM guard could kill.
+type OOMVictim struct {
+	PID      int
+	Name     string
+	AppID    int
+	OOMScore int
+}
+
+// OOMGuard Kills the process the kernel would choose, before the system freezes rather than after.
+type OOMGuard struct {
+	procRoot string
+	settings OOMGuardSettings
+	self     int
+	// The victim that was warned about, and when.
+	pending      *OOMVictim
+	pendingSince time.Time
+	now          func() time.Time
+	kill         func(pid int) error
+	notify       func(message string)
+}
+
+func newOOMGuard(procRoot string, settings OOMGuardSettings,
+	kill func(pid int) error, notify func(message string)) *OOMGuard {
+	return &OOMGuard{
+		procRoot: procRoot,
+		settings: settings,
+		self:     os.Getpid(),
+		now:      time.Now,
+		kill:     kill,
+		notify:   notify,
+	}
+}
+
+// Check whether memory is short enough that a freeze is on the way, and say why.
+func (g *OOMGuard) inDanger() (bool, string, error) {
+	pressure, err := readPressure(g.procRoot, "memory")
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return false, "", fmt.Errorf("读取内存压力时出错，内核可能未启用 PSI")
+	}
+	if pressure.Full.Avg10 >= g.settings.FullAvg10 {
+		return true, fmt.Sprintf("内存完全停顿 %.1f%%", pressure.Full.Avg10), nil
+	}
+
+	meminfo, err := readMeminfo(g.procRoot)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return false, "", fmt.Errorf("读取内存信息时出错")
+	}
+	if meminfo["MemTotal"] == 0 {
+		return false, "", nil
+	}
+	available := float64(meminfo["MemAvailable"]) / float64(meminfo["MemTotal"]) * 100
+	// Without swap, running out of RAM is running out of everything.
+	swapFree := 0.0
+	if meminfo["SwapTotal"] > 0 {
+		swapFree = float64(meminfo["SwapFree"]) / float64(meminfo["SwapTotal"]) * 100
+	}
+	if available <= g.settings.MinAvailablePercent && swapFree <= g.settings.MinSwapFreePercent {
+		return true, fmt.Sprintf("可用内存 %.1f%%，可用交换 %.1f%%", available, swapFree), nil
+	}
+	return false, "", nil
+}
+
+// Pick the process with the highest oom_score, skipping anything protected.
+func (g *OOMGuard) chooseVictim() (*OOMVictim, error) {
+	pids, err := getProcessIDs(g.procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var victim *OOMVictim
+	for _, pid := range pids {
+		if pid == 1 || pid == g.self {
+			continue
+		}
+		dir := filepath.Join(g.procRoot, strconv.Itoa(pid))
+		score, err := readProcInt(filepath.Join(dir, "oom_score"))
+		// Kernel threads and exited processes have nothing to free.
+		if err != nil || score <= 0 {
+			continue
+		}
+		adjust, err := readProcInt(filepath.Join(dir, "oom_score_adj"))
+		if err == nil && adjust <= -1000 {
+			continue
+		}
+		name := getProcessName(g.procRoot, pid)
+		if contains(OOMGuardNeverKill, name) {
+			continue
+		}
+		appID := getProcessAppID(g.procRoot, pid)
+		if appID != 0 && containsInt(g.settings.ProtectedAppIDs, appID) {
+			continue
+		}
+		if victim == nil || score > victim.OOMScore {
+			victim = &OOMVictim{PID: pid, Name: name, AppID: appID, OOMScore: score}
+		}
+	}
+	return victim, nil
+}
+
+// Check memory once, warning about a victim first and killing it if memory is still short after the grace period.
+func (g *OOMGuard) step() error {
+	danger, reason, err := g.inDanger()
+	if err != nil {
+		return err
+	}
+	if !danger {
+		if g.pending != nil {
+			CryoUtils.InfoLog.Println("内存已恢复，不再结束", g.pending.Name)
+			g.pending = nil
+		}
+		return nil
+	}
+
+	// Always re-check the victim, the warned one might have exited or shrunk.
+	victim, err := g.chooseVictim()
+	if err != nil {
+		return err
+	}
+	if victim == nil {
+		CryoUtils.ErrorLog.Println("内存不足 (", reason, ")，但没有可以结束的进程")
+		return nil
+	}
+
+	// Only ever kill the process the user was warned about, a new one gets its own warning and grace period.
+	if g.pending != nil && g.pending.PID != victim.PID {
+		CryoUtils.InfoLog.Println("要结束的进程从", g.pending.Name, "变为", victim.Name)
+		g.pending = nil
+	}
+	if g.pending == nil {
+		g.pending = victim
+		g.pendingSince = g.now()
+		g.notify(fmt.Sprintf("内存即将耗尽 (%s)，%d 秒后将结束 %s (PID %d)",
+			reason, g.settings.GraceSeconds, victim.Name, victim.PID))
+		return nil
+	}
+	if g.now().Sub(g.pendingSince) < time.Duration(g.settings.GraceSeconds)*time.Second {
+		return nil
+	}
+
+	CryoUtils.InfoLog.Println("内存不足 (", reason, ")，正在结束", victim.Name, "PID", victim.PID, "oom_score", victim.OOMScore)
+	g.pending = nil
+	err = g.kill(victim.PID)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return fmt.Errorf("无法结束 %s (PID %d)", victim.Name, victim.PID)
+	}
+	g.notify(fmt.Sprintf("内存耗尽，已结束 %s (PID %d)", victim.Name, victim.PID))
+	return nil
+}
+
+// Read a proc file holding a single number.
+func readProcInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+func containsInt(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Kill a process outright, it may not have the memory to handle a polite request.
+func killProcess(pid int) error {
+	return unix.Kill(pid, unix.SIGKILL)
+}
+
+// Tell the user, through the log, the terminal and a desktop notification if there is a desktop.
+func notifyUser(message string) {
+	CryoUtils.InfoLog.Println(message)
+	fmt.Fprintln(os.Stderr, message)
+	_, err := exec.Command("notify-send", "--urgency=critical", "--app-name=CryoUtilities", "CryoUtilities", message).Output()
+	if err != nil {
+		CryoUtils.ErrorLog.Println("无法发送桌面通知:", err)
+	}
+}
+
+// OOMGuardCLI Run the OOM guard until interrupted.
+func OOMGuardCLI(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultOOMGuardInterval
+	}
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	guard := newOOMGuard(ProcRoot, settings.OOMGuard, killProcess, notifyUser)
+	// Fail now rather than on every check if PSI isn't available.
+	_, _, err = guard.inDanger()
+	if err != nil {
+		return err
+	}
+	CryoUtils.InfoLog.Println("OOM 守护已启动:", settings.OOMGuard.String())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err = guard.step()
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// OOMGuardConfigCLI Print the OOM guard settings, or change them with key=value pairs.
+func OOMGuardConfigCLI(args []string) error {
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			return fmt.Errorf("无效的参数: %s", arg)
+		}
+		err = settings.OOMGuard.set(strings.TrimSpace(key), strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) > 0 {
+		err = saveSettings(settings)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println(settings.OOMGuard.String())
+	return nil
+}
+
+// Change one setting by the name shown by String.
+func (s *OOMGuardSettings) set(key string, value string) error {
+	var err error
+	switch key {
+	case "full_avg10":
+		s.FullAvg10, err = strconv.ParseFloat(value, 64)
+	case "min_available_percent":
+		s.MinAvailablePercent, err = strconv.ParseFloat(value, 64)
+	case "min_swap_free_percent":
+		s.MinSwapFreePercent, err = strconv.ParseFloat(value, 64)
+	case "grace_seconds":
+		s.GraceSeconds, err = strconv.Atoi(value)
+	case "protected":
+		s.ProtectedAppIDs = nil
+		for _, field := range strings.Split(value, ",") {
+			if strings.TrimSpace(field) == "" {
+				continue
+			}
+			appID, convErr := strconv.Atoi(strings.TrimSpace(field))
+			if convErr != nil || appID <= 0 {
+				return fmt.Errorf("无效的 AppID: %s", field)
+			}
+			s.ProtectedAppIDs = append(s.ProtectedAppIDs, appID)
+		}
+	default:
+		return fmt.Errorf("未知的设置: %s", key)
+	}
+	if err != nil {
+		return fmt.Errorf("无效的值 %s: %s", key, value)
+	}
+	return nil
+}
+
+// Format the settings as the key=value pairs accepted by set.
+func (s OOMGuardSettings) String() string {
+	var protected []string
+	for _, appID := range s.ProtectedAppIDs {
+		protected = append(protected, strconv.Itoa(appID))
+	}
+	return fmt.Sprintf("full_avg10=%g min_available_percent=%g min_swap_free_percent=%g grace_seconds=%d protected=%s",
+		s.FullAvg10, s.MinAvailablePercent, s.MinSwapFreePercent, s.GraceSeconds, strings.Join(protected, ","))
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Write the memory state the OOM guard reads into a fake proc root.
+func setFakeMemoryState(t *testing.T, procRoot string, fullAvg10 float64, availableKB int64, swapFreeKB int64) {
+	t.Helper()
+	pressure := "some avg10=0.00 avg60=0.00 avg300=0.00 total=0\n" +
+		"full avg10=" + strconv.FormatFloat(fullAvg10, 'f', 2, 64) + " avg60=0.00 avg300=0.00 total=0\n"
+	meminfo := "MemTotal: 16000000 kB\n" +
+		"MemAvailable: " + strconv.FormatInt(availableKB, 10) + " kB\n" +
+		"SwapTotal: 16000000 kB\n" +
+		"SwapFree: " + strconv.FormatInt(swapFreeKB, 10) + " kB\n"
+	err := os.MkdirAll(filepath.Join(procRoot, "pressure"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(procRoot, "pressure", "memory"), []byte(pressure), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(procRoot, "meminfo"), []byte(meminfo), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setFakeOOMScore(t *testing.T, procRoot string, pid int, score int) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(procRoot, strconv.Itoa(pid), "oom_score"), []byte(strconv.Itoa(score)+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOOMGuardStep(t *testing.T) {
+	procRoot := t.TempDir()
+	setFakeMemoryState(t, procRoot, 0, 8000000, 8000000)
+	// The protected game has the highest score, Steam itself is never a victim.
+	makeFakeProcess(t, procRoot, 100, "RDR2.exe", []string{"RDR2.exe"}, []string{"SteamAppId=1174180"})
+	setFakeOOMScore(t, procRoot, 100, 900)
+	makeFakeProcess(t, procRoot, 101, "steam", []string{"steam"}, nil)
+	setFakeOOMScore(t, procRoot, 101, 800)
+	makeFakeProcess(t, procRoot, 102, "firefox", []string{"firefox"}, nil)
+	setFakeOOMScore(t, procRoot, 102, 500)
+	makeFakeProcess(t, procRoot, 103, "discord", []string{"discord"}, nil)
+	setFakeOOMScore(t, procRoot, 103, 300)
+
+	settings := OOMGuardSettings{
+		FullAvg10:           40,
+		MinAvailablePercent: 3,
+		MinSwapFreePercent:  5,
+		GraceSeconds:        10,
+		ProtectedAppIDs:     []int{1174180},
+	}
+	var killed []int
+	var notifications int
+	guard := newOOMGuard(procRoot, settings,
+		func(pid int) error {
+			killed = append(killed, pid)
+			return nil
+		},
+		func(string) {
+			notifications++
+		})
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	guard.now = func() time.Time { return now }
+
+	steps := []struct {
+		name              string
+		fullAvg10         float64
+		availableKB       int64
+		swapFreeKB        int64
+		advance           time.Duration
+		setup             func()
+		wantKilled        []int
+		wantNotifications int
+	}{
+		{name: "Plenty of memory", availableKB: 8000000, swapFreeKB: 8000000},
+		{name: "Low RAM but swap left", availableKB: 100000, swapFreeKB: 8000000},
+		{name: "Out of both warns first", availableKB: 100000, swapFreeKB: 100000, wantNotifications: 1},
+		{name: "Recovered cancels", availableKB: 8000000, swapFreeKB: 8000000, advance: 20 * time.Second, wantNotifications: 1},
+		{name: "Stalled warns again", fullAvg10: 60, availableKB: 8000000, swapFreeKB: 8000000, wantNotifications: 2},
+		{name: "Still within grace", fullAvg10: 60, availableKB: 8000000, swapFreeKB: 8000000, advance: 5 * time.Second, wantNotifications: 2},
+		{name: "Grace over kills", fullAvg10: 60, availableKB: 8000000, swapFreeKB: 8000000, advance: 5 * time.Second,
+			wantKilled: []int{102}, wantNotifications: 3},
+		{name: "Still stalled warns", fullAvg10: 60, availableKB: 8000000, swapFreeKB: 8000000,
+			wantKilled: []int{102}, wantNotifications: 4},
+		{name: "Top victim changes within grace warns about it", fullAvg10: 60, availableKB: 8000000, swapFreeKB: 8000000,
+			advance: 5 * time.Second, setup: func() { setFakeOOMScore(t, procRoot, 103, 600) },
+			wantKilled: []int{102}, wantNotifications: 5},
+		{name: "Grace restarts for the new victim", fullAvg10: 60, availableKB: 8000000, swapFreeKB: 8000000,
+			advance: 5 * time.Second, wantKilled: []int{102}, wantNotifications: 5},
+		{name: "Grace over kills the new victim", fullAvg10: 60, availableKB: 8000000, swapFreeKB: 8000000,
+			advance: 5 * time.Second, wantKilled: []int{102, 103}, wantNotifications: 6},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.setup != nil {
+			step.setup()
+		}
+		setFakeMemoryState(t, procRoot, step.fullAvg10, step.availableKB, step.swapFreeKB)
+		err := guard.step()
+		if err != nil {
+			t.Fatalf("%s: step() error = %v", step.name, err)
+		}
+		if !reflect.DeepEqual(killed, step.wantKilled) {
+			t.Errorf("%s: killed = %v, want %v", step.name, killed, step.wantKilled)
+		}
+		if notifications != step.wantNotifications {
+			t.Errorf("%s: notifications = %v, want %v", step.name, notifications, step.wantNotifications)
+		}
+	}
+}
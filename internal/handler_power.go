@@ -0,0 +1,291 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// PowerState Where the power is coming from, from /sys/class/power_supply.
+type PowerState struct {
+	OnAC       bool
+	HasBattery bool
+	Capacity   int
+	Status     string
+}
+
+// Read the power supplies, a machine without any is treated as plugged in.
+func getPowerState(sysRoot string) (PowerState, error) {
+	state := PowerState{}
+	entries, err := os.ReadDir(filepath.Join(sysRoot, "class/power_supply"))
+	if err != nil && !os.IsNotExist(err) {
+		CryoUtils.ErrorLog.Println(err)
+		return state, fmt.Errorf("读取电源状态时出错")
+	}
+
+	foundAC := false
+	for _, entry := range entries {
+		dir := filepath.Join(sysRoot, "class/power_supply", entry.Name())
+		read := func(name string) string {
+			data, _ := os.ReadFile(filepath.Join(dir, name))
+			return strings.TrimSpace(string(data))
+		}
+		switch read("type") {
+		case "Mains", "USB", "USB_PD", "USB_C":
+			foundAC = true
+			if read("online") == "1" {
+				state.OnAC = true
+			}
+		case "Battery":
+			// Only the first battery counts, the Deck has one and controllers report theirs with scope "Device".
+			if state.HasBattery || read("scope") == "Device" {
+				continue
+			}
+			state.HasBattery = true
+			state.Status = read("status")
+			state.Capacity, _ = strconv.Atoi(read("capacity"))
+		}
+	}
+	// Without an AC adapter to ask, the battery charging is the only sign of being plugged in.
+	if !foundAC && (!state.HasBattery || state.Status == "Charging" || state.Status == "Full") {
+		state.OnAC = true
+	}
+	return state, nil
+}
+
+func (s PowerState) String() string {
+	if !s.HasBattery {
+		return "电源: 交流电 (无电池)"
+	}
+	source := "电池"
+	if s.OnAC {
+		source = "交流电"
+	}
+	return fmt.Sprintf("电源: %s  电量: %d%%  状态: %s", source, s.Capacity, s.Status)
+}
+
+// PowerWatcher Switches between the AC and battery profiles as the power source changes.
+type PowerWatcher struct {
+	sysRoot  string
+	settings Settings
+	// The values from before any profile was applied, put back when the watcher stops.
+	original TunableProfile
+	started  bool
+	battery  bool
+	// A switch waiting out the delay, and since when.
+	pending      bool
+	pendingSince time.Time
+	now          func() time.Time
+	apply        func(TunableProfile) error
+}
+
+func newPowerWatcher(sysRoot string, settings Settings, original TunableProfile,
+	apply func(TunableProfile) error) *PowerWatcher {
+	return &PowerWatcher{
+		sysRoot:  sysRoot,
+		settings: settings,
+		original: original,
+		now:      time.Now,
+		apply:    apply,
+	}
+}
+
+// Get every parameter the power profiles touch, so they can be captured before any are applied.
+func (s Settings) getPowerProfileParams() ([]string, error) {
+	var params []string
+	for _, name := range []string{s.Power.ACProfile, s.Power.BatteryProfile} {
+		profile, err := s.getProfile(name)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, profile.params()...)
+	}
+	return params, nil
+}
+
+// Decide whether the battery profile should be used. With a threshold, the battery profile goes on once the
+// charge drops to it, and stays while charging until the charge is back above the hysteresis, so plugging in
+// and out at a low charge doesn't flip between profiles.
+func (w *PowerWatcher) wantBattery(state PowerState) bool {
+	if !state.HasBattery {
+		return false
+	}
+	threshold := w.settings.Power.BatteryThreshold
+	if threshold <= 0 {
+		return !state.OnAC
+	}
+	if w.started && w.battery && state.Capacity < threshold+w.settings.Power.Hysteresis {
+		return true
+	}
+	return !state.OnAC && state.Capacity <= threshold
+}
+
+func (w *PowerWatcher) profileName(battery bool) string {
+	if battery {
+		return w.settings.Power.BatteryProfile
+	}
+	return w.settings.Power.ACProfile
+}
+
+// Check the power source once, switching profiles once a change has lasted for the delay.
+func (w *PowerWatcher) step() error {
+	state, err := getPowerState(w.sysRoot)
+	if err != nil {
+		return err
+	}
+	battery := w.wantBattery(state)
+	if w.started && battery == w.battery {
+		w.pending = false
+		return nil
+	}
+
+	// The first profile goes on straight away, there's nothing to flap from yet.
+	if w.started {
+		if !w.pending {
+			w.pending = true
+			w.pendingSince = w.now()
+			return nil
+		}
+		if w.now().Sub(w.pendingSince) < time.Duration(w.settings.Power.DelaySeconds)*time.Second {
+			return nil
+		}
+	}
+
+	name := w.profileName(battery)
+	profile, err := w.settings.getProfile(name)
+	if err != nil {
+		return err
+	}
+	CryoUtils.InfoLog.Println(state.String(), "，应用配置文件", name, profile.String())
+	err = w.apply(profile)
+	if err != nil {
+		return err
+	}
+	w.started = true
+	w.battery = battery
+	w.pending = false
+	return nil
+}
+
+// Put back the values from before the watcher started.
+func (w *PowerWatcher) restore() error {
+	if !w.started || len(w.original) == 0 {
+		return nil
+	}
+	CryoUtils.InfoLog.Println("电源监视停止，恢复原来的设置", w.original.String())
+	err := applyProfileLive(w.original)
+	if err != nil {
+		return err
+	}
+	w.started = false
+	return nil
+}
+
+// PowerCLI Show the power source and the profile it selects, or change the settings with 'config key=value'.
+func PowerCLI(args []string) error {
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && args[0] == "config" {
+		for _, arg := range args[1:] {
+			key, value, found := strings.Cut(arg, "=")
+			if !found {
+				return fmt.Errorf("无效的参数: %s", arg)
+			}
+			err = settings.Power.set(settings, strings.TrimSpace(key), strings.TrimSpace(value))
+			if err != nil {
+				return err
+			}
+		}
+		if len(args) > 1 {
+			err = saveSettings(settings)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Println(settings.Power.String())
+		return nil
+	} else if len(args) > 0 {
+		return fmt.Errorf("未知的参数: %s", args[0])
+	}
+
+	state, err := getPowerState(SysRoot)
+	if err != nil {
+		return err
+	}
+	watcher := newPowerWatcher(SysRoot, settings, nil, nil)
+	fmt.Println(state.String())
+	fmt.Println("配置文件:", watcher.profileName(watcher.wantBattery(state)))
+	fmt.Println(settings.Power.String())
+	return nil
+}
+
+// Change one setting by the name shown by String, profiles must already exist.
+func (s *PowerSettings) set(settings Settings, key string, value string) error {
+	var err error
+	switch key {
+	case "ac_profile", "battery_profile":
+		_, err = settings.getProfile(value)
+		if err != nil {
+			return err
+		}
+		if key == "ac_profile" {
+			s.ACProfile = value
+		} else {
+			s.BatteryProfile = value
+		}
+		return nil
+	case "battery_threshold":
+		s.BatteryThreshold, err = strconv.Atoi(value)
+		if s.BatteryThreshold < 0 || s.BatteryThreshold > 100 {
+			err = fmt.Errorf("out of range")
+		}
+	case "hysteresis":
+		s.Hysteresis, err = strconv.Atoi(value)
+		if s.Hysteresis <= 0 {
+			err = fmt.Errorf("out of range")
+		}
+	case "delay_seconds":
+		s.DelaySeconds, err = strconv.Atoi(value)
+		if s.DelaySeconds <= 0 {
+			err = fmt.Errorf("out of range")
+		}
+	default:
+		return fmt.Errorf("未知的设置: %s", key)
+	}
+	if err != nil {
+		return fmt.Errorf("无效的值 %s: %s", key, value)
+	}
+	return nil
+}
+
+// Format the settings as the key=value pairs accepted by set.
+func (s PowerSettings) String() string {
+	return fmt.Sprintf("ac_profile=%s battery_profile=%s battery_threshold=%d hysteresis=%d delay_seconds=%d",
+		s.ACProfile, s.BatteryProfile, s.BatteryThreshold, s.Hysteresis, s.DelaySeconds)
+}
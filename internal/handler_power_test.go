@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setFakePowerSupply(t *testing.T, sysRoot string, name string, files map[string]string) {
+	t.Helper()
+	dir := filepath.Join(sysRoot, "class/power_supply", name)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, contents := range files {
+		err = os.WriteFile(filepath.Join(dir, file), []byte(contents+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetPowerState(t *testing.T) {
+	tests := []struct {
+		name     string
+		supplies map[string]map[string]string
+		want     PowerState
+	}{
+		{"no supplies", nil, PowerState{OnAC: true}},
+		{"docked", map[string]map[string]string{
+			"ACAD": {"type": "Mains", "online": "1"},
+			"BAT1": {"type": "Battery", "capacity": "80", "status": "Charging"},
+		}, PowerState{OnAC: true, HasBattery: true, Capacity: 80, Status: "Charging"}},
+		{"handheld", map[string]map[string]string{
+			"ACAD":       {"type": "Mains", "online": "0"},
+			"BAT1":       {"type": "Battery", "capacity": "55", "status": "Discharging"},
+			"controller": {"type": "Battery", "capacity": "10", "status": "Discharging", "scope": "Device"},
+		}, PowerState{HasBattery: true, Capacity: 55, Status: "Discharging"}},
+		{"no adapter", map[string]map[string]string{
+			"BAT0": {"type": "Battery", "capacity": "100", "status": "Full"},
+		}, PowerState{OnAC: true, HasBattery: true, Capacity: 100, Status: "Full"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sysRoot := t.TempDir()
+			for name, files := range tt.supplies {
+				setFakePowerSupply(t, sysRoot, name, files)
+			}
+			got, err := getPowerState(sysRoot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("getPowerState() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPowerWatcher(t *testing.T) {
+	sysRoot := t.TempDir()
+	settings := Settings{
+		Profiles: map[string]TunableProfile{
+			"dock":    {"swappiness": "60"},
+			"battery": {"swappiness": "10"},
+		},
+		Power: PowerSettings{ACProfile: "dock", BatteryProfile: "battery", BatteryThreshold: 30, Hysteresis: 5, DelaySeconds: 10},
+	}
+	var applied []string
+	apply := func(profile TunableProfile) error {
+		applied = append(applied, profile["swappiness"])
+		return nil
+	}
+	watcher := newPowerWatcher(sysRoot, settings, nil, apply)
+	now := time.Unix(0, 0)
+	watcher.now = func() time.Time { return now }
+
+	steps := []struct {
+		online   string
+		capacity string
+		elapsed  time.Duration
+		want     []string
+	}{
+		// The first profile is applied at once.
+		{"1", "80", 0, []string{"60"}},
+		// Above the threshold on battery, the AC profile stays.
+		{"0", "50", 0, []string{"60"}},
+		// Dropping to the threshold waits out the delay before switching.
+		{"0", "30", 0, []string{"60"}},
+		{"0", "30", 5 * time.Second, []string{"60"}},
+		{"0", "29", 10 * time.Second, []string{"60", "10"}},
+		// Plugged in at a low charge, the battery profile stays until it's charged past the hysteresis.
+		{"1", "31", time.Minute, []string{"60", "10"}},
+		{"1", "34", time.Minute, []string{"60", "10"}},
+		{"0", "34", time.Minute, []string{"60", "10"}},
+		// Charged past it, the AC profile waits out the delay, which restarts if it's unplugged meanwhile.
+		{"1", "35", 0, []string{"60", "10"}},
+		{"0", "34", 5 * time.Second, []string{"60", "10"}},
+		{"1", "35", 5 * time.Second, []string{"60", "10"}},
+		{"1", "36", 10 * time.Second, []string{"60", "10", "60"}},
+		// Unplugged above the threshold, the AC profile stays.
+		{"0", "36", time.Minute, []string{"60", "10", "60"}},
+	}
+	for i, step := range steps {
+		now = now.Add(step.elapsed)
+		setFakePowerSupply(t, sysRoot, "ACAD", map[string]string{"type": "Mains", "online": step.online})
+		status := "Discharging"
+		if step.online == "1" {
+			status = "Charging"
+		}
+		setFakePowerSupply(t, sysRoot, "BAT1", map[string]string{"type": "Battery", "capacity": step.capacity, "status": status})
+		err := watcher.step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != len(step.want) || applied[len(applied)-1] != step.want[len(step.want)-1] {
+			t.Fatalf("step %d: applied %v, want %v", i, applied, step.want)
+		}
+	}
+}
+
+func TestGameWatcherSetBaseline(t *testing.T) {
+	procRoot := t.TempDir()
+	settings := Settings{
+		Profiles:     map[string]TunableProfile{"rdr2": {"swappiness": "1"}},
+		GameProfiles: map[int]string{1174180: "rdr2"},
+	}
+	var applied TunableProfile
+	games := newGameWatcher(procRoot, settings, TunableProfile{"swappiness": "100", "hugepages": "always"},
+		getCurrentProfile, func(profile TunableProfile) error {
+			applied = profile
+			return nil
+		})
+	games.active = 1174180
+
+	err := games.setBaseline(TunableProfile{"swappiness": "60", "hugepages": "never"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied["swappiness"] != "1" || applied["hugepages"] != "never" {
+		t.Errorf("applied %v, want the game's swappiness over the new baseline", applied)
+	}
+	if games.baseline["swappiness"] != "60" {
+		t.Errorf("baseline %v, want swappiness=60 for when the game exits", games.baseline)
+	}
+}
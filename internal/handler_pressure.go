@@ -0,0 +1,159 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// PressureLine One line of a PSI file, ex: "some avg10=0.00 avg60=0.00 avg300=0.00 total=0"
+// The averages are the percentage of time stalled, total is in microseconds.
+type PressureLine struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"`
+}
+
+// PressureStats The "some" and "full" lines of a PSI file.
+type PressureStats struct {
+	Some PressureLine `json:"some"`
+	Full PressureLine `json:"full"`
+}
+
+// PressureSnapshot PSI for memory, IO and CPU at a point in time.
+type PressureSnapshot struct {
+	Time   time.Time     `json:"time"`
+	Memory PressureStats `json:"memory"`
+	IO     PressureStats `json:"io"`
+	CPU    PressureStats `json:"cpu"`
+}
+
+// Parse the contents of a /proc/pressure file.
+func parsePressure(contents string) (PressureStats, error) {
+	var stats PressureStats
+	for _, line := range strings.Split(strings.TrimSpace(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var parsed PressureLine
+		for _, field := range fields[1:] {
+			key, value, found := strings.Cut(field, "=")
+			if !found {
+				return stats, fmt.Errorf("无法解析压力数据: %s", line)
+			}
+			var err error
+			switch key {
+			case "avg10":
+				parsed.Avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				parsed.Avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				parsed.Avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				parsed.Total, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				return stats, fmt.Errorf("无法解析压力数据: %s", line)
+			}
+		}
+
+		switch fields[0] {
+		case "some":
+			stats.Some = parsed
+		case "full":
+			stats.Full = parsed
+		}
+	}
+	return stats, nil
+}
+
+// Read the PSI of a resource, ex: "memory", "io" or "cpu".
+func readPressure(procRoot string, resource string) (PressureStats, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, "pressure", resource))
+	if err != nil {
+		return PressureStats{}, err
+	}
+	return parsePressure(string(data))
+}
+
+// Get the PSI of memory, IO and CPU.
+func getPressureSnapshot(procRoot string) (PressureSnapshot, error) {
+	snapshot := PressureSnapshot{Time: time.Now()}
+	var err error
+	snapshot.Memory, err = readPressure(procRoot, "memory")
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return snapshot, fmt.Errorf("读取内存压力时出错，内核可能未启用 PSI")
+	}
+	snapshot.IO, err = readPressure(procRoot, "io")
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return snapshot, fmt.Errorf("读取 IO 压力时出错")
+	}
+	snapshot.CPU, err = readPressure(procRoot, "cpu")
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return snapshot, fmt.Errorf("读取 CPU 压力时出错")
+	}
+	return snapshot, nil
+}
+
+// Format a PSI file for display.
+func (p PressureStats) String() string {
+	return fmt.Sprintf("some %.2f%% / %.2f%% / %.2f%%  full %.2f%% / %.2f%% / %.2f%%  总停顿 %s",
+		p.Some.Avg10, p.Some.Avg60, p.Some.Avg300,
+		p.Full.Avg10, p.Full.Avg60, p.Full.Avg300,
+		(time.Duration(p.Some.Total) * time.Microsecond).Round(time.Second))
+}
+
+// MonitorCLI Print a PSI snapshot as a JSON line every interval, until cancelled.
+func MonitorCLI(ctx context.Context, interval time.Duration) error {
+	encoder := json.NewEncoder(os.Stdout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		snapshot, err := getPressureSnapshot(ProcRoot)
+		if err != nil {
+			return err
+		}
+		err = encoder.Encode(snapshot)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPressure(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     PressureStats
+		wantErr  bool
+	}{
+		{
+			name: "Some and full",
+			contents: "some avg10=1.50 avg60=0.75 avg300=0.25 total=123456\n" +
+				"full avg10=0.50 avg60=0.10 avg300=0.00 total=6543\n",
+			want: PressureStats{
+				Some: PressureLine{Avg10: 1.5, Avg60: 0.75, Avg300: 0.25, Total: 123456},
+				Full: PressureLine{Avg10: 0.5, Avg60: 0.1, Total: 6543},
+			},
+		},
+		{
+			// The CPU file had no full line before 5.13.
+			name:     "No full line",
+			contents: "some avg10=2.00 avg60=1.00 avg300=0.50 total=42\n",
+			want:     PressureStats{Some: PressureLine{Avg10: 2, Avg60: 1, Avg300: 0.5, Total: 42}},
+		},
+		{
+			name:     "Missing value",
+			contents: "some avg10 avg60=1.00 avg300=0.50 total=42\n",
+			wantErr:  true,
+		},
+		{
+			name:     "Bad number",
+			contents: "some avg10=lots avg60=1.00 avg300=0.50 total=42\n",
+			wantErr:  true,
+		},
+		{
+			name:     "Negative total",
+			contents: "full avg10=0.00 avg60=0.00 avg300=0.00 total=-1\n",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procRoot := t.TempDir()
+			err := os.Mkdir(filepath.Join(procRoot, "pressure"), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(filepath.Join(procRoot, "pressure", "memory"), []byte(tt.contents), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := readPressure(procRoot, "memory")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readPressure() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("readPressure() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := readPressure(t.TempDir(), "memory"); err == nil {
+		t.Errorf("readPressure() without PSI should fail")
+	}
+}
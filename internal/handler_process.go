@@ -0,0 +1,253 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// Environment variables Steam sets on everything it launches for a game.
+var steamAppIDVariables = []string{"SteamAppId", "STEAM_COMPAT_APP_ID"}
+
+// Shader compiles are given paths inside the game's shadercache directory.
+var shaderCacheAppIDRegex = regexp.MustCompile(`shadercache/([0-9]+)/`)
+
+// ProcessMemory The memory use of one process, sizes are in kB.
+type ProcessMemory struct {
+	PID     int    `json:"pid"`
+	Name    string `json:"name"`
+	AppID   int    `json:"app_id"`
+	Group   string `json:"group"`
+	Swap    int64  `json:"swap"`
+	RSS     int64  `json:"rss"`
+	RssAnon int64  `json:"rss_anon"`
+}
+
+// ProcessGroup The combined memory use of related processes, ex: everything belonging to one game.
+type ProcessGroup struct {
+	Name      string          `json:"name"`
+	AppID     int             `json:"app_id"`
+	Swap      int64           `json:"swap"`
+	RSS       int64           `json:"rss"`
+	RssAnon   int64           `json:"rss_anon"`
+	Processes []ProcessMemory `json:"processes"`
+}
+
+// Get the PIDs of every process in the given proc root, sorted.
+func getProcessIDs(procRoot string) ([]int, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		// Anything that isn't a number is a kernel interface like "self" or "meminfo".
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// Read a NUL-separated proc file, like cmdline or environ.
+func readProcessList(procRoot string, pid int, name string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), name))
+	if err != nil {
+		return nil, err
+	}
+	return strings.FieldsFunc(string(data), func(r rune) bool { return r == 0 }), nil
+}
+
+// Get the short name of a process.
+func getProcessName(procRoot string, pid int) string {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Find the Steam AppID a process belongs to, or 0 if it isn't part of a game.
+func getProcessAppID(procRoot string, pid int) int {
+	// Steam's reaper wraps every game, ex: "reaper SteamLaunch AppId=1174180 -- ..."
+	cmdline, err := readProcessList(procRoot, pid, "cmdline")
+	if err == nil && len(cmdline) > 0 && filepath.Base(cmdline[0]) == "reaper" {
+		for _, arg := range cmdline[1:] {
+			if arg == "--" {
+				break
+			}
+			if strings.HasPrefix(arg, "AppId=") {
+				appID, err := strconv.Atoi(strings.TrimPrefix(arg, "AppId="))
+				if err == nil && appID > 0 {
+					return appID
+				}
+			}
+		}
+	}
+
+	// Everything the game starts inherits the variables, including Proton and Wine.
+	// This fails for processes belonging to other users, which is fine since games run as the user.
+	environ, err := readProcessList(procRoot, pid, "environ")
+	if err != nil {
+		return 0
+	}
+	for _, variable := range environ {
+		key, value, found := strings.Cut(variable, "=")
+		if !found || !contains(steamAppIDVariables, key) {
+			continue
+		}
+		appID, err := strconv.Atoi(value)
+		if err == nil && appID > 0 {
+			return appID
+		}
+	}
+	return 0
+}
+
+// Get every running game, as a map of AppID to the PIDs belonging to it.
+func getRunningGames(procRoot string) (map[int][]int, error) {
+	pids, err := getProcessIDs(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	games := map[int][]int{}
+	for _, pid := range pids {
+		appID := getProcessAppID(procRoot, pid)
+		if appID != 0 {
+			games[appID] = append(games[appID], pid)
+		}
+	}
+	return games, nil
+}
+
+// Get the memory use of a process from /proc/<pid>/status.
+func getProcessMemory(procRoot string, pid int) (ProcessMemory, error) {
+	status, err := readProcKeyValues(filepath.Join(procRoot, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return ProcessMemory{}, err
+	}
+	process := ProcessMemory{
+		PID:     pid,
+		Name:    getProcessName(procRoot, pid),
+		AppID:   getProcessAppID(procRoot, pid),
+		Swap:    status["VmSwap"],
+		RSS:     status["VmRSS"],
+		RssAnon: status["RssAnon"],
+	}
+	process.Group = getProcessGroupName(procRoot, &process)
+	return process, nil
+}
+
+// Decide which group a process belongs in, filling in the AppID of shader compiles while at it.
+func getProcessGroupName(procRoot string, process *ProcessMemory) string {
+	switch process.Name {
+	case ShaderProcessName:
+		// Shader compiles don't carry the game's environment, but their arguments point into its shadercache.
+		if process.AppID == 0 {
+			cmdline, _ := readProcessList(procRoot, process.PID, "cmdline")
+			match := shaderCacheAppIDRegex.FindStringSubmatch(strings.Join(cmdline, " "))
+			if match != nil {
+				process.AppID, _ = strconv.Atoi(match[1])
+			}
+		}
+		if process.AppID != 0 {
+			return fmt.Sprintf("%d - 着色器编译", process.AppID)
+		}
+		return "着色器编译 (fossilize_replay)"
+	case "steamwebhelper":
+		return "Steam 网页助手 (steamwebhelper)"
+	}
+	if process.AppID != 0 {
+		return fmt.Sprintf("%d - 游戏", process.AppID)
+	}
+	return process.Name
+}
+
+// Get the memory use of every process, skipping any that exit while being read.
+func getProcessMemoryList(procRoot string) ([]ProcessMemory, error) {
+	pids, err := getProcessIDs(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	var processes []ProcessMemory
+	for _, pid := range pids {
+		process, err := getProcessMemory(procRoot, pid)
+		if err != nil {
+			continue
+		}
+		processes = append(processes, process)
+	}
+	return processes, nil
+}
+
+// Group processes together, sorted by how much swap each group is using.
+func groupProcessMemory(processes []ProcessMemory) []ProcessGroup {
+	groups := map[string]*ProcessGroup{}
+	var names []string
+	for _, process := range processes {
+		group, ok := groups[process.Group]
+		if !ok {
+			group = &ProcessGroup{Name: process.Group, AppID: process.AppID}
+			groups[process.Group] = group
+			names = append(names, process.Group)
+		}
+		group.Swap += process.Swap
+		group.RSS += process.RSS
+		group.RssAnon += process.RssAnon
+		group.Processes = append(group.Processes, process)
+	}
+
+	var sorted []ProcessGroup
+	for _, name := range names {
+		group := groups[name]
+		sort.SliceStable(group.Processes, func(i, j int) bool {
+			return group.Processes[i].Swap > group.Processes[j].Swap
+		})
+		sorted = append(sorted, *group)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Swap != sorted[j].Swap {
+			return sorted[i].Swap > sorted[j].Swap
+		}
+		return sorted[i].RSS > sorted[j].RSS
+	})
+	return sorted
+}
+
+// Add the game's name to a group, if it's known.
+func (g ProcessGroup) displayName(gameNames map[int]string) string {
+	if g.AppID != 0 && gameNames[g.AppID] != "" {
+		return g.Name + " (" + gameNames[g.AppID] + ")"
+	}
+	return g.Name
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestGetProcessMemory(t *testing.T) {
+	procRoot := t.TempDir()
+	// The kernel cuts comm to 15 characters, so fossilize_replay shows up as fossilize_repla.
+	makeFakeProcess(t, procRoot, 200, "fossilize_repla",
+		[]string{"fossilize_replay", "/home/deck/.local/share/Steam/steamapps/shadercache/1174180/fozpipelinesv6/steamapprun_pipeline_cache.foz"},
+		[]string{"HOME=/home/deck"})
+	makeFakeProcess(t, procRoot, 201, "fossilize_repla",
+		[]string{"fossilize_replay", "--spirv-val"},
+		[]string{"HOME=/home/deck"})
+	makeFakeProcess(t, procRoot, 202, "RDR2.exe",
+		[]string{"Z:\\RDR2.exe"},
+		[]string{"STEAM_COMPAT_APP_ID=1174180"})
+	makeFakeProcess(t, procRoot, 203, "steamwebhelper",
+		[]string{"steamwebhelper"},
+		[]string{"HOME=/home/deck"})
+	makeFakeProcess(t, procRoot, 204, "bash",
+		[]string{"bash"},
+		[]string{"HOME=/home/deck"})
+	for pid := 200; pid <= 204; pid++ {
+		status := "Name:\tignored\nVmRSS:\t  2048 kB\nRssAnon:\t  1024 kB\nVmSwap:\t   512 kB\n"
+		err := os.WriteFile(filepath.Join(procRoot, strconv.Itoa(pid), "status"), []byte(status), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pid       int
+		wantAppID int
+		wantGroup string
+	}{
+		{200, 1174180, "1174180 - 着色器编译"},
+		{201, 0, "着色器编译 (fossilize_replay)"},
+		{202, 1174180, "1174180 - 游戏"},
+		{203, 0, "Steam 网页助手 (steamwebhelper)"},
+		{204, 0, "bash"},
+	}
+	for _, tt := range tests {
+		got, err := getProcessMemory(procRoot, tt.pid)
+		if err != nil {
+			t.Errorf("getProcessMemory(%d) error = %v", tt.pid, err)
+			continue
+		}
+		if got.AppID != tt.wantAppID || got.Group != tt.wantGroup {
+			t.Errorf("getProcessMemory(%d) = AppID %d, group %q, want %d, %q", tt.pid, got.AppID, got.Group, tt.wantAppID, tt.wantGroup)
+		}
+		if got.Swap != 512 || got.RSS != 2048 || got.RssAnon != 1024 {
+			t.Errorf("getProcessMemory(%d) sizes = %d, %d, %d", tt.pid, got.Swap, got.RSS, got.RssAnon)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// TunableProfile A set of UnitMatrix parameters and the values they should be given.
+type TunableProfile map[string]string
+
+// Get the profile matching Cryo's recommendations.
+func getRecommendedProfile() TunableProfile {
+	return TunableProfile{
+		"swappiness":               RecommendedSwappiness,
+		"hugepages":                RecommendedHugePages,
+		"compaction_proactiveness": RecommendedCompactionProactiveness,
+		"defrag":                   RecommendedHugePageDefrag,
+		"page_lock_unfairness":     RecommendedPageLockUnfairness,
+		"shmem_enabled":            RecommendedShMem,
+	}
+}
+
+// Get the profile matching Valve's defaults.
+func getStockProfile() TunableProfile {
+	return TunableProfile{
+		"swappiness":               DefaultSwappiness,
+		"hugepages":                DefaultHugePages,
+		"compaction_proactiveness": DefaultCompactionProactiveness,
+		"defrag":                   DefaultHugePageDefrag,
+		"page_lock_unfairness":     DefaultPageLockUnfairness,
+		"shmem_enabled":            DefaultShMem,
+	}
+}
+
+// Get Valve's defaults for the tunables that aren't part of the stock profile, since not every kernel has them.
+func getStockOptionalProfile() TunableProfile {
+	return TunableProfile{
+		"ksm_run":             DefaultKSMRun,
+		"ksm_pages_to_scan":   DefaultKSMPagesToScan,
+		"ksm_sleep_millisecs": DefaultKSMSleepMillisecs,
+	}
+}
+
+// Get the parameters in the profile, sorted so they're always applied in the same order.
+func (p TunableProfile) params() []string {
+	var params []string
+	for param := range p {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	return params
+}
+
+// Return a human-readable "param=value" list of the profile.
+func (p TunableProfile) String() string {
+	var pairs []string
+	for _, param := range p.params() {
+		pairs = append(pairs, param+"="+p[param])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Make sure every parameter in the profile is one we know how to change.
+func (p TunableProfile) validate() error {
+	if len(p) == 0 {
+		return fmt.Errorf("配置文件中没有任何参数")
+	}
+	for _, param := range p.params() {
+		if _, ok := UnitMatrix[param]; !ok {
+			return fmt.Errorf("未知参数: %s", param)
+		}
+		if p[param] == "" {
+			return fmt.Errorf("参数 %s 没有值", param)
+		}
+		err := validateCPUValue(param, p[param])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read the live value of every parameter given.
+func getCurrentProfile(params []string) (TunableProfile, error) {
+	current := TunableProfile{}
+	for _, param := range params {
+		value, err := getUnitStatus(param)
+		if err != nil {
+			return nil, fmt.Errorf("无法获取当前的 %s 值", param)
+		}
+		current[param] = value
+	}
+	return current, nil
+}
+
+// Write every value in the profile to memory, without persisting it.
+func applyProfileLive(p TunableProfile) error {
+	err := p.validate()
+	if err != nil {
+		return err
+	}
+	// Held across the whole profile, so it's never applied half-way alongside another change.
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+	for _, param := range p.params() {
+		err = setUnitValue(param, p[param])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write or remove the unit file of every value in the profile, so it survives a reboot.
+func persistProfile(p TunableProfile) error {
+	err := p.validate()
+	if err != nil {
+		return err
+	}
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+	for _, param := range p.params() {
+		err = persistUnitValue(param, p[param])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Persist a single value, removing the unit file instead if it's the stock value. The caller holds LockTunables.
+func persistUnitValue(param string, value string) error {
+	stock, ok := getStockProfile()[param]
+	if !ok {
+		stock, ok = getStockOptionalProfile()[param]
+	}
+	if !ok {
+		stock = getStockCPUProfile()[param]
+	}
+	if value == stock {
+		return removeUnitFile(param)
+	}
+	return writeUnitFile(param, value)
+}
+
+// Parse CLI arguments into a profile. Accepts 'recommended', 'stock' or any number of 'param=value' pairs.
+func parseProfileArgs(args []string) (TunableProfile, error) {
+	if len(args) == 1 {
+		switch strings.ToLower(args[0]) {
+		case "recommended":
+			return getRecommendedProfile(), nil
+		case "stock":
+			return getStockProfile(), nil
+		}
+	}
+
+	profile := TunableProfile{}
+	for _, arg := range args {
+		param, value, found := strings.Cut(arg, "=")
+		if !found {
+			return nil, fmt.Errorf("无效的参数: %s", arg)
+		}
+		profile[strings.TrimSpace(param)] = strings.TrimSpace(value)
+	}
+	err := profile.validate()
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
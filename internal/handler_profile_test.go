@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProfileArgs(t *testing.T) {
+	type args struct {
+		args []string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    TunableProfile
+		wantErr bool
+	}{
+		{
+			name: "Recommended",
+			args: args{
+				args: []string{"recommended"},
+			},
+			want: getRecommendedProfile(),
+		},
+		{
+			name: "Stock",
+			args: args{
+				args: []string{"STOCK"},
+			},
+			want: getStockProfile(),
+		},
+		{
+			name: "Pairs",
+			args: args{
+				args: []string{"swappiness=60", "hugepages = madvise"},
+			},
+			want: TunableProfile{"swappiness": "60", "hugepages": "madvise"},
+		},
+		{
+			name: "Unknown parameter",
+			args: args{
+				args: []string{"nr_hugepages=10"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Missing value",
+			args: args{
+				args: []string{"swappiness"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProfileArgs(tt.args.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProfileArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProfileArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// SafeApplyState Everything needed to undo a safe-apply, kept on disk until it's confirmed or reverted.
+type SafeApplyState struct {
+	PID      int            `json:"pid"`
+	BootID   string         `json:"boot_id"`
+	Deadline time.Time      `json:"deadline"`
+	Previous TunableProfile `json:"previous"`
+	Applied  TunableProfile `json:"applied"`
+}
+
+// SafeApply A live change that is reverted automatically unless it's confirmed before the deadline.
+type SafeApply struct {
+	state     SafeApplyState
+	isUI      bool
+	timer     *time.Timer
+	done      chan struct{}
+	mu        sync.Mutex
+	finished  bool
+	confirmed bool
+	err       error
+}
+
+// StartSafeApply Apply a profile to memory only, reverting it after the duration unless Confirm is called.
+func StartSafeApply(p TunableProfile, duration time.Duration, isUI bool) (*SafeApply, error) {
+	err := p.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one trial can be pending at a time, otherwise the "previous" values would be the trial values.
+	pending, err := loadSafeApplyState()
+	if err == nil && pending.PID != os.Getpid() && isProcessRunning(pending.PID) {
+		return nil, fmt.Errorf("已有试用设置正在等待确认 (PID %d)", pending.PID)
+	}
+
+	if isUI {
+		renewSudoAuth()
+	}
+	previous, err := getCurrentProfile(p.params())
+	if err != nil {
+		return nil, err
+	}
+
+	state := SafeApplyState{
+		PID:      os.Getpid(),
+		BootID:   getBootID(),
+		Deadline: time.Now().Add(duration),
+		Previous: previous,
+		Applied:  p,
+	}
+	// Save the state before touching anything, so a crash mid-apply can still be recovered.
+	err = saveSafeApplyState(state)
+	if err != nil {
+		return nil, err
+	}
+
+	CryoUtils.InfoLog.Println("试用设置", p.String(), "，", duration, "后未确认将恢复为", previous.String())
+	err = applyProfileLive(p)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		_ = applyProfileLive(previous)
+		_ = removeSafeApplyState()
+		return nil, err
+	}
+
+	s := &SafeApply{
+		state: state,
+		isUI:  isUI,
+		done:  make(chan struct{}),
+	}
+	s.timer = time.AfterFunc(duration, func() {
+		CryoUtils.InfoLog.Println("试用时间已到，恢复之前的设置...")
+		err := s.Revert()
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+		}
+	})
+	return s, nil
+}
+
+// Deadline The time at which the change will be reverted.
+func (s *SafeApply) Deadline() time.Time {
+	return s.state.Deadline
+}
+
+// Done Closed once the change has been confirmed or reverted.
+func (s *SafeApply) Done() <-chan struct{} {
+	return s.done
+}
+
+// Confirmed Whether the change was kept, only meaningful once Done is closed.
+func (s *SafeApply) Confirmed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.confirmed
+}
+
+// Err The error from confirming or reverting, only meaningful once Done is closed.
+func (s *SafeApply) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Confirm Keep the change, writing the unit files so it survives a reboot.
+func (s *SafeApply) Confirm() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return fmt.Errorf("试用已结束，无法再确认")
+	}
+	s.timer.Stop()
+
+	CryoUtils.InfoLog.Println("已确认试用设置，正在保存...")
+	if s.isUI {
+		renewSudoAuth()
+	}
+	err := persistProfile(s.state.Applied)
+	s.confirmed = err == nil
+	s.finish(err)
+	return err
+}
+
+// Revert Put the previous values back in memory, nothing was persisted so there's nothing else to undo.
+func (s *SafeApply) Revert() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return nil
+	}
+	s.timer.Stop()
+
+	CryoUtils.InfoLog.Println("恢复试用前的设置", s.state.Previous.String())
+	if s.isUI {
+		renewSudoAuth()
+	}
+	err := applyProfileLive(s.state.Previous)
+	s.finish(err)
+	return err
+}
+
+func (s *SafeApply) finish(err error) {
+	s.finished = true
+	s.err = err
+	rmErr := removeSafeApplyState()
+	if rmErr != nil {
+		CryoUtils.ErrorLog.Println(rmErr)
+	}
+	close(s.done)
+}
+
+// RecoverSafeApply Undo a safe-apply left pending by an instance that crashed or was killed.
+func RecoverSafeApply() error {
+	state, err := loadSafeApplyState()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		CryoUtils.ErrorLog.Println("无法读取试用状态，丢弃:", err)
+		return removeSafeApplyState()
+	}
+
+	// The unit files are only written on confirmation, so a reboot has already restored the old values.
+	if state.BootID != getBootID() {
+		CryoUtils.InfoLog.Println("试用期间已重启，之前的设置已恢复。")
+		return removeSafeApplyState()
+	}
+
+	// Still waiting on the instance that started it.
+	if state.PID != os.Getpid() && isProcessRunning(state.PID) {
+		return nil
+	}
+
+	CryoUtils.InfoLog.Println("发现未确认的试用设置，恢复为", state.Previous.String())
+	err = applyProfileLive(state.Previous)
+	if err != nil {
+		return err
+	}
+	return removeSafeApplyState()
+}
+
+// RecoverExpiredSafeApply Revert a trial once its deadline has passed without the process that started it,
+// for the watch daemon, which outlives the GUI and the CLI. A trial whose process is still running is left to it.
+func RecoverExpiredSafeApply() error {
+	state, err := loadSafeApplyState()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err == nil && time.Now().Before(state.Deadline) {
+		return nil
+	}
+	return RecoverSafeApply()
+}
+
+func loadSafeApplyState() (SafeApplyState, error) {
+	var state SafeApplyState
+	data, err := os.ReadFile(SafeApplyStatePath)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func saveSafeApplyState(state SafeApplyState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeSharedFile(SafeApplyStatePath, data)
+}
+
+func removeSafeApplyState() error {
+	err := os.Remove(SafeApplyStatePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Get the ID of the current boot, which changes every time the Deck restarts.
+func getBootID() string {
+	data, err := os.ReadFile(BootIDPath)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Check whether a process exists, even if it belongs to another user.
+func isProcessRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := unix.Kill(pid, 0)
+	return err == nil || errors.Is(err, unix.EPERM)
+}
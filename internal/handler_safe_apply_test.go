@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func useSafeApplyState(t *testing.T) {
+	oldStatePath := SafeApplyStatePath
+	SafeApplyStatePath = filepath.Join(t.TempDir(), "safe_apply.json")
+	t.Cleanup(func() { SafeApplyStatePath = oldStatePath })
+}
+
+func TestStartSafeApplyRollback(t *testing.T) {
+	fake := useFakeExecutor(t, map[string]string{
+		UnitMatrix["swappiness"]: "100\n",
+		UnitMatrix["hugepages"]:  "always [madvise] never\n",
+	})
+	useSafeApplyState(t)
+	fake.Errs = map[string]error{"write " + UnitMatrix["hugepages"]: errors.New("denied")}
+
+	_, err := StartSafeApply(TunableProfile{"swappiness": "1", "hugepages": "always"}, time.Minute, false)
+	if err == nil {
+		t.Fatal("StartSafeApply() should fail when a value can't be written")
+	}
+	if got := fake.Files[UnitMatrix["swappiness"]]; got != "100\n" {
+		t.Errorf("swappiness after the failed apply = %q, want it rolled back to %q", got, "100\n")
+	}
+	if _, err = os.Stat(SafeApplyStatePath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("safe-apply state left behind: %v", err)
+	}
+}
+
+func TestSafeApplyRevertFails(t *testing.T) {
+	fake := useFakeExecutor(t, map[string]string{UnitMatrix["swappiness"]: "100\n"})
+	useSafeApplyState(t)
+
+	s, err := StartSafeApply(TunableProfile{"swappiness": "1"}, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Err = errors.New("denied")
+	if err = s.Revert(); err == nil {
+		t.Errorf("Revert() should fail when the executor does")
+	}
+	<-s.Done()
+	if s.Err() == nil || s.Confirmed() {
+		t.Errorf("after a failed revert Err() = %v, Confirmed() = %v", s.Err(), s.Confirmed())
+	}
+}
+
+func TestRecoverExpiredSafeApply(t *testing.T) {
+	fake := useFakeExecutor(t, map[string]string{UnitMatrix["swappiness"]: "1\n"})
+	useSafeApplyState(t)
+	state := SafeApplyState{
+		// Nothing runs with this PID, the process that started the trial died.
+		PID:      999999999,
+		BootID:   getBootID(),
+		Deadline: time.Now().Add(time.Minute),
+		Previous: TunableProfile{"swappiness": "100"},
+		Applied:  TunableProfile{"swappiness": "1"},
+	}
+	if err := saveSafeApplyState(state); err != nil {
+		t.Fatal(err)
+	}
+
+	// Before the deadline the trial is left alone.
+	if err := RecoverExpiredSafeApply(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Files[UnitMatrix["swappiness"]]; got != "1\n" {
+		t.Errorf("swappiness before the deadline = %q, want the trial value", got)
+	}
+
+	state.Deadline = time.Now().Add(-time.Second)
+	if err := saveSafeApplyState(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecoverExpiredSafeApply(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Files[UnitMatrix["swappiness"]]; got != "100\n" {
+		t.Errorf("swappiness after the deadline = %q, want it reverted to %q", got, "100\n")
+	}
+	if _, err := os.Stat(SafeApplyStatePath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("safe-apply state left behind: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// Settings User choices that need to survive between runs.
+type Settings struct {
+	Profiles     map[string]TunableProfile `json:"profiles"`
+	GameProfiles map[int]string            `json:"game_profiles"`
+	OOMGuard     OOMGuardSettings          `json:"oom_guard"`
+	Cgroup       CgroupSettings            `json:"cgroup"`
+	Power        PowerSettings             `json:"power"`
+	BlockDevices []BlockDeviceSettings     `json:"block_devices"`
+	// Lower the priority of shader compiles while a game is running.
+	DeprioritizeShaders bool `json:"deprioritize_shaders"`
+}
+
+// CgroupSettings Whether the watch daemon protects the game's memory, and by how much.
+// Sizes are in the kernel's format, ex: "6G" or "max".
+type CgroupSettings struct {
+	Enabled        bool   `json:"enabled"`
+	GameLow        string `json:"game_low"`
+	GameMin        string `json:"game_min"`
+	BackgroundHigh string `json:"background_high"`
+}
+
+// PowerSettings Which profile the watch daemon uses on AC and on battery.
+// With a BatteryThreshold, the battery profile waits until the charge drops to it, and keeps going while
+// charging until the charge is Hysteresis percent above it.
+type PowerSettings struct {
+	ACProfile        string `json:"ac_profile"`
+	BatteryProfile   string `json:"battery_profile"`
+	BatteryThreshold int    `json:"battery_threshold"`
+	Hysteresis       int    `json:"hysteresis"`
+	DelaySeconds     int    `json:"delay_seconds"`
+}
+
+// BlockDeviceSettings Queue settings for one device, found by its model and serial since names change
+// when cards are swapped. ModelAttr is the sysfs attribute holding the model, "model" or "name" for SD cards.
+// Empty values are left as the kernel sets them.
+type BlockDeviceSettings struct {
+	ModelAttr   string `json:"model_attr"`
+	Model       string `json:"model"`
+	Serial      string `json:"serial"`
+	Scheduler   string `json:"scheduler"`
+	ReadAheadKB string `json:"read_ahead_kb"`
+	NrRequests  string `json:"nr_requests"`
+}
+
+// OOMGuardSettings When the OOM guard steps in, and what it must never kill.
+type OOMGuardSettings struct {
+	FullAvg10           float64 `json:"full_avg10"`
+	MinAvailablePercent float64 `json:"min_available_percent"`
+	MinSwapFreePercent  float64 `json:"min_swap_free_percent"`
+	GraceSeconds        int     `json:"grace_seconds"`
+	ProtectedAppIDs     []int   `json:"protected_app_ids"`
+}
+
+// Load the settings file, an absent file is treated as empty settings.
+func loadSettings() (Settings, error) {
+	var settings Settings
+	data, err := os.ReadFile(SettingsFilePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return settings, err
+	} else if err == nil {
+		err = json.Unmarshal(data, &settings)
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+			return settings, fmt.Errorf("解析设置文件时出错 %s", SettingsFilePath)
+		}
+	}
+
+	if settings.Profiles == nil {
+		settings.Profiles = map[string]TunableProfile{}
+	}
+	if settings.GameProfiles == nil {
+		settings.GameProfiles = map[int]string{}
+	}
+	if settings.Cgroup.GameLow == "" {
+		settings.Cgroup.GameLow = DefaultCgroupGameLow
+	}
+	if settings.Cgroup.GameMin == "" {
+		settings.Cgroup.GameMin = DefaultCgroupGameMin
+	}
+	if settings.Cgroup.BackgroundHigh == "" {
+		settings.Cgroup.BackgroundHigh = DefaultCgroupBackgroundHigh
+	}
+	if settings.Power.ACProfile == "" {
+		settings.Power.ACProfile = DefaultPowerACProfile
+	}
+	if settings.Power.BatteryProfile == "" {
+		settings.Power.BatteryProfile = DefaultPowerBatteryProfile
+	}
+	if settings.Power.Hysteresis == 0 {
+		settings.Power.Hysteresis = DefaultPowerHysteresis
+	}
+	if settings.Power.DelaySeconds == 0 {
+		settings.Power.DelaySeconds = DefaultPowerDelaySeconds
+	}
+	if settings.OOMGuard.FullAvg10 == 0 {
+		settings.OOMGuard.FullAvg10 = DefaultOOMGuardFullAvg10
+	}
+	if settings.OOMGuard.MinAvailablePercent == 0 {
+		settings.OOMGuard.MinAvailablePercent = DefaultOOMGuardMinAvailablePercent
+	}
+	if settings.OOMGuard.MinSwapFreePercent == 0 {
+		settings.OOMGuard.MinSwapFreePercent = DefaultOOMGuardMinSwapFreePercent
+	}
+	if settings.OOMGuard.GraceSeconds == 0 {
+		settings.OOMGuard.GraceSeconds = DefaultOOMGuardGraceSeconds
+	}
+	return settings, nil
+}
+
+// Save the settings file.
+func saveSettings(settings Settings) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeSharedFile(SettingsFilePath, data)
+}
+
+// settingsCache The settings for something that reads them often, like the watch daemon. The file is only
+// read again once its modification time or size changes, a damaged one keeps the last good settings.
+type settingsCache struct {
+	settings Settings
+	modTime  time.Time
+	size     int64
+	loaded   bool
+}
+
+// Get the settings, loading them again if the file changed since the last call.
+func (c *settingsCache) get() (Settings, error) {
+	var modTime time.Time
+	size := int64(-1)
+	info, err := os.Stat(SettingsFilePath)
+	if err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return c.settings, err
+	}
+	if c.loaded && modTime.Equal(c.modTime) && size == c.size {
+		return c.settings, nil
+	}
+
+	// Remembered even when it fails, so a damaged file is only reported once.
+	c.modTime, c.size, c.loaded = modTime, size, true
+	settings, err := loadSettings()
+	if err != nil {
+		return c.settings, err
+	}
+	c.settings = settings
+	return settings, nil
+}
+
+// Look up a profile by name, 'recommended' and 'stock' are always available.
+func (s Settings) getProfile(name string) (TunableProfile, error) {
+	switch strings.ToLower(name) {
+	case "recommended":
+		return getRecommendedProfile(), nil
+	case "stock":
+		return getStockProfile(), nil
+	}
+	profile, ok := s.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("找不到配置文件: %s", name)
+	}
+	return profile, nil
+}
+
+// Get a sorted list of profile names, including the built-in ones.
+func (s Settings) getProfileNames() []string {
+	names := []string{"recommended", "stock"}
+	var custom []string
+	for name := range s.Profiles {
+		custom = append(custom, name)
+	}
+	sort.Strings(custom)
+	return append(names, custom...)
+}
+
+// Get every parameter touched by a game profile, so it can be captured before any are applied.
+func (s Settings) getGameProfileParams() []string {
+	seen := map[string]bool{}
+	var params []string
+	for _, name := range s.GameProfiles {
+		profile, err := s.getProfile(name)
+		if err != nil {
+			continue
+		}
+		for _, param := range profile.params() {
+			if !seen[param] {
+				seen[param] = true
+				params = append(params, param)
+			}
+		}
+	}
+	sort.Strings(params)
+	return params
+}
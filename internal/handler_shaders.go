@@ -0,0 +1,285 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// From linux/ioprio.h, x/sys/unix doesn't have these.
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassIdle  = 3
+)
+
+// ThreadPriority How a thread is scheduled, what gets lowered and later put back. Both can be raised
+// again without root, unlike nice.
+type ThreadPriority struct {
+	IOPrio   int
+	Affinity unix.CPUSet
+}
+
+// ShaderLimiter Lowers the priority of Steam's shader compiles while a game is running. The CPU share is
+// lowered with a cgroup's cpu.weight, the I/O priority and CPUs thread by thread.
+type ShaderLimiter struct {
+	procRoot   string
+	cgroupRoot string
+	// The cgroup shader compiles are moved into relative to cgroupRoot, "" when cgroups can't be used.
+	cgroup      string
+	cgroupReady bool
+	// The original priority of every thread that was lowered, by thread ID.
+	lowered map[int]ThreadPriority
+	// The cgroup every moved process came from, by PID.
+	moved map[int]string
+	get   func(tid int) (ThreadPriority, error)
+	set   func(tid int, priority ThreadPriority) error
+}
+
+func newShaderLimiter(procRoot string, cgroupRoot string, cgroup string,
+	get func(tid int) (ThreadPriority, error), set func(tid int, priority ThreadPriority) error) *ShaderLimiter {
+	return &ShaderLimiter{
+		procRoot:   procRoot,
+		cgroupRoot: cgroupRoot,
+		cgroup:     cgroup,
+		lowered:    map[int]ThreadPriority{},
+		moved:      map[int]string{},
+		get:        get,
+		set:        set,
+	}
+}
+
+// Get the priority a shader compile thread should run at while a game is running.
+func limitedPriority(original ThreadPriority) ThreadPriority {
+	limited := original
+	limited.IOPrio = ioprioClassIdle << ioprioClassShift
+
+	// Keep the last CPUs of the ones it was allowed, the game's threads tend to start from the first.
+	if original.Affinity.Count() > ShaderCPUs {
+		limited.Affinity.Zero()
+		kept := 0
+		for cpu := len(original.Affinity)*64 - 1; cpu >= 0 && kept < ShaderCPUs; cpu-- {
+			if original.Affinity.IsSet(cpu) {
+				limited.Affinity.Set(cpu)
+				kept++
+			}
+		}
+	}
+	return limited
+}
+
+// Find every shader compile process.
+func getShaderProcesses(procRoot string) ([]int, error) {
+	pids, err := getProcessIDs(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	var shaders []int
+	for _, pid := range pids {
+		if getProcessName(procRoot, pid) == ShaderProcessName {
+			shaders = append(shaders, pid)
+		}
+	}
+	return shaders, nil
+}
+
+// Find every thread of a process, ionice and the CPUs only apply to a single thread.
+func getProcessThreads(procRoot string, pid int) []int {
+	entries, err := os.ReadDir(filepath.Join(procRoot, strconv.Itoa(pid), "task"))
+	if err != nil {
+		// The process has exited.
+		return nil
+	}
+	var tids []int
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids
+}
+
+// Create the shader cgroup with its low CPU weight, giving up on cgroups if that isn't possible.
+func (l *ShaderLimiter) setupCgroup() bool {
+	if l.cgroup == "" || l.cgroupReady {
+		return l.cgroupReady
+	}
+	path := filepath.Join(l.cgroupRoot, l.cgroup)
+	// The cpu controller may not be handed down yet, systemd delegates it to the user's instance.
+	_ = writeCgroupFile(filepath.Join(filepath.Dir(path), "cgroup.subtree_control"), "+cpu")
+	err := os.Mkdir(path, 0755)
+	if err == nil || errors.Is(err, os.ErrExist) {
+		err = writeCgroupFile(filepath.Join(path, "cpu.weight"), strconv.Itoa(ShaderCPUWeight))
+	}
+	if err != nil {
+		CryoUtils.ErrorLog.Println("无法创建着色器编译的 cgroup，只降低 I/O 优先级和可用 CPU:", err)
+		l.cgroup = ""
+		return false
+	}
+	l.cgroupReady = true
+	return true
+}
+
+// Move a shader compile into the low weight cgroup, remembering where it came from.
+func (l *ShaderLimiter) moveProcess(pid int) {
+	if _, ok := l.moved[pid]; ok || !l.setupCgroup() {
+		return
+	}
+	original, err := getProcessCgroup(l.procRoot, strconv.Itoa(pid))
+	if err != nil {
+		// The process has exited.
+		return
+	}
+	err = writeCgroupFile(filepath.Join(l.cgroupRoot, l.cgroup, "cgroup.procs"), strconv.Itoa(pid))
+	if err != nil {
+		CryoUtils.ErrorLog.Println("无法将着色器编译进程", pid, "移入 cgroup:", err)
+		return
+	}
+	l.moved[pid] = original
+}
+
+// Lower any new shader compiles while a game runs, and restore them all once it exits.
+func (l *ShaderLimiter) step(gameRunning bool) error {
+	if !gameRunning {
+		return l.restore()
+	}
+	pids, err := getShaderProcesses(l.procRoot)
+	if err != nil {
+		return err
+	}
+
+	currentPIDs := map[int]bool{}
+	currentTIDs := map[int]bool{}
+	count := 0
+	for _, pid := range pids {
+		currentPIDs[pid] = true
+		l.moveProcess(pid)
+		for _, tid := range getProcessThreads(l.procRoot, pid) {
+			currentTIDs[tid] = true
+			if _, ok := l.lowered[tid]; ok {
+				continue
+			}
+			original, err := l.get(tid)
+			if err != nil {
+				// The thread has exited.
+				continue
+			}
+			err = l.set(tid, limitedPriority(original))
+			if err != nil {
+				CryoUtils.ErrorLog.Println("无法降低着色器编译线程", tid, "的优先级:", err)
+				continue
+			}
+			l.lowered[tid] = original
+			count++
+		}
+	}
+	// Forget processes and threads that have finished, their IDs could be reused.
+	for tid := range l.lowered {
+		if !currentTIDs[tid] {
+			delete(l.lowered, tid)
+		}
+	}
+	for pid := range l.moved {
+		if !currentPIDs[pid] {
+			delete(l.moved, pid)
+		}
+	}
+	if count > 0 {
+		CryoUtils.InfoLog.Println("游戏运行中，已降低", count, "个着色器编译线程的优先级")
+	}
+	return nil
+}
+
+// Put every moved process and lowered thread back how it was, and remove the cgroup.
+func (l *ShaderLimiter) restore() error {
+	if len(l.lowered) == 0 && len(l.moved) == 0 && !l.cgroupReady {
+		return nil
+	}
+	failed := 0
+	for pid, original := range l.moved {
+		delete(l.moved, pid)
+		if !doesFileExist(filepath.Join(l.procRoot, strconv.Itoa(pid))) {
+			continue
+		}
+		err := writeCgroupFile(filepath.Join(l.cgroupRoot, original, "cgroup.procs"), strconv.Itoa(pid))
+		if err != nil {
+			CryoUtils.ErrorLog.Println("无法将着色器编译进程", pid, "移回", original, err)
+			failed++
+		}
+	}
+	for tid, original := range l.lowered {
+		delete(l.lowered, tid)
+		if !doesFileExist(filepath.Join(l.procRoot, strconv.Itoa(tid))) {
+			continue
+		}
+		err := l.set(tid, original)
+		if err != nil {
+			CryoUtils.ErrorLog.Println("无法恢复着色器编译线程", tid, "的优先级:", err)
+			failed++
+		}
+	}
+	if l.cgroupReady {
+		err := os.Remove(filepath.Join(l.cgroupRoot, l.cgroup))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			CryoUtils.ErrorLog.Println(err)
+			failed++
+		}
+		l.cgroupReady = false
+	}
+	if failed > 0 {
+		return fmt.Errorf("无法恢复 %d 个着色器编译进程或线程的优先级", failed)
+	}
+	CryoUtils.InfoLog.Println("游戏已退出，已恢复着色器编译的优先级")
+	return nil
+}
+
+// Read the scheduling of a thread.
+func getThreadPriority(tid int) (ThreadPriority, error) {
+	var priority ThreadPriority
+	ioprio, _, errno := unix.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+	if errno != 0 {
+		return priority, errno
+	}
+	priority.IOPrio = int(ioprio)
+	err := unix.SchedGetaffinity(tid, &priority.Affinity)
+	return priority, err
+}
+
+// Change the scheduling of a thread.
+func setThreadPriority(tid int, priority ThreadPriority) error {
+	err := unix.SchedSetaffinity(tid, &priority.Affinity)
+	if err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(priority.IOPrio))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func makeFakeThreads(t *testing.T, procRoot string, pid int, tids ...int) {
+	t.Helper()
+	for _, tid := range tids {
+		err := os.MkdirAll(filepath.Join(procRoot, strconv.Itoa(pid), "task", strconv.Itoa(tid)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLimitedPriority(t *testing.T) {
+	var all unix.CPUSet
+	for cpu := 0; cpu < 8; cpu++ {
+		all.Set(cpu)
+	}
+	var last unix.CPUSet
+	last.Set(7)
+	var single unix.CPUSet
+	single.Set(2)
+
+	tests := []struct {
+		name     string
+		original ThreadPriority
+		want     ThreadPriority
+	}{
+		{"lowered", ThreadPriority{IOPrio: 0, Affinity: all},
+			ThreadPriority{IOPrio: ioprioClassIdle << ioprioClassShift, Affinity: last}},
+		{"single CPU", ThreadPriority{IOPrio: 4, Affinity: single},
+			ThreadPriority{IOPrio: ioprioClassIdle << ioprioClassShift, Affinity: single}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := limitedPriority(tt.original); got != tt.want {
+				t.Errorf("limitedPriority() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShaderLimiter(t *testing.T) {
+	procRoot := t.TempDir()
+	cgroupRoot := t.TempDir()
+	base := "/user.slice/user-1000.slice/user@1000.service"
+	appScope := base + "/app.slice/app-steam.scope"
+	shaderCgroup := filepath.Join(base, ShaderCgroupName)
+	makeFakeCgroup(t, cgroupRoot, appScope)
+	// The kernel creates the control files along with the directory.
+	makeFakeCgroup(t, cgroupRoot, shaderCgroup)
+
+	makeFakeProcess(t, procRoot, 100, ShaderProcessName, []string{"fossilize_replay"}, nil)
+	makeFakeThreads(t, procRoot, 100, 100, 101)
+	setFakeProcessCgroup(t, procRoot, "100", appScope)
+	makeFakeProcess(t, procRoot, 200, "steam", []string{"steam"}, nil)
+	makeFakeThreads(t, procRoot, 200, 200)
+	setFakeProcessCgroup(t, procRoot, "200", appScope)
+
+	priorities := map[int]ThreadPriority{100: {}, 101: {IOPrio: 4}, 200: {}}
+	get := func(tid int) (ThreadPriority, error) {
+		return priorities[tid], nil
+	}
+	set := func(tid int, priority ThreadPriority) error {
+		priorities[tid] = priority
+		return nil
+	}
+	limiter := newShaderLimiter(procRoot, cgroupRoot, shaderCgroup, get, set)
+	idle := ioprioClassIdle << ioprioClassShift
+
+	err := limiter.step(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priorities[100].IOPrio != 0 || len(limiter.moved) != 0 {
+		t.Errorf("shader compile lowered without a game")
+	}
+
+	// Lowering happens once, a second step mustn't save the lowered values as the originals.
+	for i := 0; i < 2; i++ {
+		err = limiter.step(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tid := range []int{100, 101} {
+		if priorities[tid].IOPrio != idle {
+			t.Errorf("thread %d ioprio = %d, want %d", tid, priorities[tid].IOPrio, idle)
+		}
+	}
+	if got := readFakeCgroupFile(t, filepath.Join(cgroupRoot, shaderCgroup, "cgroup.procs")); strings.Join(got, " ") != "100" {
+		t.Errorf("shader cgroup.procs = %v, want [100]", got)
+	}
+	if got := readFakeCgroupFile(t, filepath.Join(cgroupRoot, shaderCgroup, "cpu.weight")); strings.Join(got, " ") != strconv.Itoa(ShaderCPUWeight) {
+		t.Errorf("cpu.weight = %v, want %d", got, ShaderCPUWeight)
+	}
+	if priorities[200].IOPrio != 0 {
+		t.Errorf("steam lowered, only shader compiles should be")
+	}
+
+	// The kernel also shows threads at the top level of /proc.
+	err = os.Mkdir(filepath.Join(procRoot, "101"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A real cgroup can be removed once it's empty, the fake one has files in it.
+	err = os.RemoveAll(filepath.Join(cgroupRoot, shaderCgroup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = limiter.step(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priorities[100].IOPrio != 0 || priorities[101].IOPrio != 4 {
+		t.Errorf("ioprio after the game = %d, %d, want 0, 4", priorities[100].IOPrio, priorities[101].IOPrio)
+	}
+	if got := readFakeCgroupFile(t, filepath.Join(cgroupRoot, appScope, "cgroup.procs")); strings.Join(got, " ") != "100" {
+		t.Errorf("app cgroup.procs after the game = %v, want [100]", got)
+	}
+	if len(limiter.lowered) != 0 || len(limiter.moved) != 0 {
+		t.Errorf("lowered = %v, moved = %v, want nothing after the game", limiter.lowered, limiter.moved)
+	}
+}
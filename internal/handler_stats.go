@@ -0,0 +1,230 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// ThpCounterNames The /proc/vmstat counters that show whether THP and compaction are helping.
+var ThpCounterNames = []string{
+	"thp_fault_alloc",
+	"thp_fault_fallback",
+	"thp_collapse_alloc",
+	"compact_stall",
+	"compact_fail",
+	"compact_success",
+	"pgmajfault",
+}
+
+// VMStatSnapshot A set of /proc/vmstat counters at a point in time.
+type VMStatSnapshot struct {
+	Time     time.Time        `json:"time"`
+	BootID   string           `json:"boot_id"`
+	Counters map[string]int64 `json:"counters"`
+}
+
+// ThpReport How the THP and compaction counters changed over a window.
+type ThpReport struct {
+	Start               time.Time        `json:"start"`
+	End                 time.Time        `json:"end"`
+	Settings            TunableProfile   `json:"settings"`
+	Deltas              map[string]int64 `json:"deltas"`
+	FallbackRatio       float64          `json:"fallback_ratio"`
+	CompactFailRatio    float64          `json:"compact_fail_ratio"`
+	CompactStallsPerMin float64          `json:"compact_stalls_per_min"`
+	MajorFaultsPerMin   float64          `json:"major_faults_per_min"`
+	Findings            []string         `json:"findings"`
+}
+
+// Take a snapshot of the given vmstat counters.
+func takeVMStatSnapshot(procRoot string, names []string) (VMStatSnapshot, error) {
+	snapshot := VMStatSnapshot{
+		Time:     time.Now(),
+		BootID:   getBootID(),
+		Counters: map[string]int64{},
+	}
+	vmstat, err := readVMStat(procRoot)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return snapshot, fmt.Errorf("读取虚拟内存统计时出错")
+	}
+	for _, name := range names {
+		snapshot.Counters[name] = vmstat[name]
+	}
+	return snapshot, nil
+}
+
+// Compare two snapshots and judge whether the current settings are helping.
+func newThpReport(start VMStatSnapshot, end VMStatSnapshot, settings TunableProfile) ThpReport {
+	report := ThpReport{
+		Start:    start.Time,
+		End:      end.Time,
+		Settings: settings,
+		Deltas:   map[string]int64{},
+	}
+	for _, name := range ThpCounterNames {
+		report.Deltas[name] = end.Counters[name] - start.Counters[name]
+	}
+
+	faults := report.Deltas["thp_fault_alloc"] + report.Deltas["thp_fault_fallback"]
+	if faults > 0 {
+		report.FallbackRatio = float64(report.Deltas["thp_fault_fallback"]) / float64(faults)
+	}
+	compactions := report.Deltas["compact_success"] + report.Deltas["compact_fail"]
+	if compactions > 0 {
+		report.CompactFailRatio = float64(report.Deltas["compact_fail"]) / float64(compactions)
+	}
+	minutes := end.Time.Sub(start.Time).Minutes()
+	if minutes > 0 {
+		report.CompactStallsPerMin = float64(report.Deltas["compact_stall"]) / minutes
+		report.MajorFaultsPerMin = float64(report.Deltas["pgmajfault"]) / minutes
+	}
+
+	report.Findings = report.judge(faults)
+	return report
+}
+
+func (r ThpReport) judge(faults int64) []string {
+	var findings []string
+	if r.Settings["hugepages"] == "never" {
+		findings = append(findings, "大页面 (THP) 已禁用，THP 计数器不会变化。")
+	} else if faults == 0 {
+		findings = append(findings, "此期间没有 THP 缺页，无法判断大页面是否有帮助，请在游戏时记录。")
+	} else if r.FallbackRatio >= ThpFallbackWarningRatio {
+		findings = append(findings, fmt.Sprintf("%.0f%% 的大页面分配回退到了普通页面，内存碎片较多，"+
+			"大页面效果有限；可以考虑恢复默认的主动压缩。", r.FallbackRatio*100))
+	} else {
+		findings = append(findings, fmt.Sprintf("%.0f%% 的 THP 缺页得到了大页面，大页面设置有帮助。",
+			(1-r.FallbackRatio)*100))
+	}
+
+	if r.CompactStallsPerMin >= CompactStallWarningPerMinute {
+		findings = append(findings, fmt.Sprintf("每分钟 %.1f 次直接压缩停顿，分配时在等待压缩，"+
+			"这可能导致卡顿；可以考虑禁用大页面碎片整理。", r.CompactStallsPerMin))
+	} else if r.Settings["compaction_proactiveness"] == "0" && r.Deltas["compact_stall"] == 0 {
+		findings = append(findings, "没有直接压缩停顿，主动压缩为 0 没有带来问题。")
+	}
+	if r.CompactFailRatio >= ThpFallbackWarningRatio {
+		findings = append(findings, fmt.Sprintf("%.0f%% 的压缩失败了。", r.CompactFailRatio*100))
+	}
+
+	if r.MajorFaultsPerMin >= MajorFaultWarningPerMinute {
+		findings = append(findings, fmt.Sprintf("每分钟 %.0f 次主要缺页，内存页正在从交换或磁盘读回；"+
+			"可以考虑更大的交换文件或更低的交换性。", r.MajorFaultsPerMin))
+	}
+	return findings
+}
+
+// Format the report for display.
+func (r ThpReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "统计时长: %s\n", r.End.Sub(r.Start).Round(time.Second))
+	fmt.Fprintf(&b, "当前设置: %s\n", r.Settings)
+	for _, name := range ThpCounterNames {
+		fmt.Fprintf(&b, "%s: %d\n", name, r.Deltas[name])
+	}
+	for _, finding := range r.Findings {
+		fmt.Fprintf(&b, "- %s\n", finding)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// Get the current THP-related settings, so the report can say what was tested.
+func getThpSettings() TunableProfile {
+	settings, err := getCurrentProfile([]string{"hugepages", "compaction_proactiveness", "defrag", "shmem_enabled"})
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return TunableProfile{}
+	}
+	return settings
+}
+
+// StartThpStats Save a snapshot to compare against later, ex: at the start of a gaming session.
+func StartThpStats() error {
+	snapshot, err := takeVMStatSnapshot(ProcRoot, ThpCounterNames)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	CryoUtils.InfoLog.Println("开始记录 THP 统计数据")
+	return writeSharedFile(ThpSnapshotPath, data)
+}
+
+// GetThpStatsSinceStart Report on everything since StartThpStats was called.
+func GetThpStatsSinceStart() (ThpReport, error) {
+	var start VMStatSnapshot
+	data, err := os.ReadFile(ThpSnapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return ThpReport{}, fmt.Errorf("还没有开始记录 THP 统计数据")
+	} else if err != nil {
+		return ThpReport{}, err
+	}
+	err = json.Unmarshal(data, &start)
+	if err != nil {
+		return ThpReport{}, err
+	}
+	// Counters reset on boot, so comparing across one would be meaningless.
+	if start.BootID != getBootID() {
+		return ThpReport{}, fmt.Errorf("记录开始后设备已重启，请重新开始记录")
+	}
+
+	end, err := takeVMStatSnapshot(ProcRoot, ThpCounterNames)
+	if err != nil {
+		return ThpReport{}, err
+	}
+	return newThpReport(start, end, getThpSettings()), nil
+}
+
+// StopThpStats Report on everything since StartThpStats was called, and stop recording.
+func StopThpStats() (ThpReport, error) {
+	report, err := GetThpStatsSinceStart()
+	if err != nil {
+		return report, err
+	}
+	return report, os.Remove(ThpSnapshotPath)
+}
+
+// GetThpStatsOverWindow Report on a fixed window starting now, returning early if the channel closes.
+func GetThpStatsOverWindow(window time.Duration, stop <-chan struct{}) (ThpReport, error) {
+	start, err := takeVMStatSnapshot(ProcRoot, ThpCounterNames)
+	if err != nil {
+		return ThpReport{}, err
+	}
+	select {
+	case <-time.After(window):
+	case <-stop:
+	}
+	end, err := takeVMStatSnapshot(ProcRoot, ThpCounterNames)
+	if err != nil {
+		return ThpReport{}, err
+	}
+	return newThpReport(start, end, getThpSettings()), nil
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewThpReport(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	snapshot := func(minutes int, counters map[string]int64) VMStatSnapshot {
+		return VMStatSnapshot{Time: start.Add(time.Duration(minutes) * time.Minute), Counters: counters}
+	}
+	tests := []struct {
+		name         string
+		end          VMStatSnapshot
+		settings     TunableProfile
+		wantFallback float64
+		wantStalls   float64
+		wantFindings []string
+	}{
+		{
+			name:         "THP disabled",
+			end:          snapshot(10, map[string]int64{}),
+			settings:     TunableProfile{"hugepages": "never"},
+			wantFindings: []string{"已禁用"},
+		},
+		{
+			name:         "No faults",
+			end:          snapshot(10, map[string]int64{}),
+			settings:     TunableProfile{"hugepages": "always", "compaction_proactiveness": "0"},
+			wantFindings: []string{"没有 THP 缺页", "主动压缩为 0 没有带来问题"},
+		},
+		{
+			name:         "Helping",
+			end:          snapshot(10, map[string]int64{"thp_fault_alloc": 90, "thp_fault_fallback": 10}),
+			settings:     TunableProfile{"hugepages": "always"},
+			wantFallback: 0.1,
+			wantFindings: []string{"90% 的 THP 缺页得到了大页面"},
+		},
+		{
+			name: "Fragmented and stalling",
+			end: snapshot(10, map[string]int64{"thp_fault_alloc": 20, "thp_fault_fallback": 80,
+				"compact_stall": 200, "compact_fail": 60, "compact_success": 40, "pgmajfault": 20000}),
+			settings:     TunableProfile{"hugepages": "always"},
+			wantFallback: 0.8,
+			wantStalls:   20,
+			wantFindings: []string{"80% 的大页面分配回退", "每分钟 20.0 次直接压缩停顿", "60% 的压缩失败了", "每分钟 2000 次主要缺页"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newThpReport(snapshot(0, map[string]int64{}), tt.end, tt.settings)
+			if got.FallbackRatio != tt.wantFallback || got.CompactStallsPerMin != tt.wantStalls {
+				t.Errorf("newThpReport() ratios = %v, %v, want %v, %v", got.FallbackRatio, got.CompactStallsPerMin,
+					tt.wantFallback, tt.wantStalls)
+			}
+			if len(got.Findings) != len(tt.wantFindings) {
+				t.Fatalf("newThpReport() findings = %q, want %d", got.Findings, len(tt.wantFindings))
+			}
+			for i, want := range tt.wantFindings {
+				if !strings.Contains(got.Findings[i], want) {
+					t.Errorf("finding %d = %q, want it to mention %q", i, got.Findings[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// SupportBundleEntry One file in a support bundle, as listed in its manifest.
+type SupportBundleEntry struct {
+	Name   string `json:"name"`
+	Source string `json:"source,omitempty"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Error Why the file couldn't be collected, it's left out of the bundle then.
+	Error string `json:"error,omitempty"`
+}
+
+// SupportBundleManifest What's in a support bundle, saved in it as manifest.json.
+type SupportBundleManifest struct {
+	Version  string               `json:"version"`
+	Created  time.Time            `json:"created"`
+	Redacted []string             `json:"redacted"`
+	Entries  []SupportBundleEntry `json:"entries"`
+}
+
+// Redactor Takes home paths, usernames, the hostname and Steam IDs out of text.
+type Redactor struct {
+	patterns     []*regexp.Regexp
+	replacements []string
+}
+
+// Make a redactor for the given home directory, usernames and hostname. Empty ones are skipped.
+func newRedactor(home string, usernames []string, hostname string) *Redactor {
+	r := &Redactor{}
+	add := func(pattern string, replacement string) {
+		r.patterns = append(r.patterns, regexp.MustCompile(pattern))
+		r.replacements = append(r.replacements, replacement)
+	}
+	if home != "" && home != "/" {
+		add(regexp.QuoteMeta(home)+`\b`, "~")
+	}
+	add(`/home/[^/\s"':]+`, "/home/USER")
+	add(`\b7656119\d{10}\b`, "STEAMID64")
+	add(`userdata/\d+`, "userdata/STEAMID")
+	add(`(?i)("(?:steamid|accountid)"\s+")\d+"`, `${1}STEAMID"`)
+	for _, username := range usernames {
+		// Too short to tell from ordinary words.
+		if len(username) >= 3 && username != "root" {
+			add(`\b`+regexp.QuoteMeta(username)+`\b`, "USER")
+		}
+	}
+	if len(hostname) >= 3 && hostname != "localhost" {
+		add(`\b`+regexp.QuoteMeta(hostname)+`\b`, "HOSTNAME")
+	}
+	return r
+}
+
+// Redact everything identifying from text.
+func (r *Redactor) redact(text string) string {
+	for i, pattern := range r.patterns {
+		text = pattern.ReplaceAllString(text, r.replacements[i])
+	}
+	return text
+}
+
+// Get a redactor for this machine, covering the user who ran sudo too.
+func getSupportRedactor() *Redactor {
+	var usernames []string
+	if current, err := user.Current(); err == nil {
+		usernames = append(usernames, current.Username)
+	}
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		usernames = append(usernames, sudoUser)
+	}
+	hostname, _ := os.Hostname()
+	return newRedactor(HomeDirectory, usernames, hostname)
+}
+
+// Get the files to collect, by their name in the bundle.
+func getSupportBundleSources() map[string]string {
+	sources := map[string]string{
+		"proc/swaps":               filepath.Join(ProcRoot, "swaps"),
+		"proc/meminfo":             filepath.Join(ProcRoot, "meminfo"),
+		"proc/mountinfo":           filepath.Join(ProcRoot, "self/mountinfo"),
+		"proc/cmdline":             filepath.Join(ProcRoot, "cmdline"),
+		"etc/os-release":           "/etc/os-release",
+		"steam/libraryfolders":     LibraryVDFLocation,
+		"cryoutilities/settings":   SettingsFilePath,
+		"cryoutilities/helper.log": HelperLogPath,
+		"udev/blockdev.rules":      BlockDeviceRulesPath,
+	}
+	units, _ := filepath.Glob(filepath.Join(TmpFilesRoot, "*.conf"))
+	for _, unit := range units {
+		sources["tmpfiles.d/"+filepath.Base(unit)] = unit
+	}
+	for _, logFile := range append(getLogFiles(LogFilePath), getLogFiles(WatchLogFilePath)...) {
+		sources["logs/"+filepath.Base(logFile)] = logFile
+	}
+	audits, _ := filepath.Glob(AuditLogPath + "*")
+	for _, audit := range audits {
+		sources["logs/"+filepath.Base(audit)] = audit
+	}
+	return sources
+}
+
+// Describe the current state: tunables, swap, CPU, block devices and how root is reached.
+func getSupportSnapshot() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CryoUtilities %s\n", CurrentVersionNumber)
+	fmt.Fprintf(&b, "时间: %s\n", time.Now().Format(time.RFC3339))
+	var uname unix.Utsname
+	if unix.Uname(&uname) == nil {
+		fmt.Fprintf(&b, "内核: %s\n", unix.ByteSliceToString(uname.Release[:]))
+	}
+
+	b.WriteString("\n== 参数 ==\n")
+	var params []string
+	for param := range UnitMatrix {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	for _, param := range params {
+		// Read directly, collecting shouldn't ask for a password.
+		data, err := os.ReadFile(UnitMatrix[param])
+		if err != nil {
+			fmt.Fprintf(&b, "%s: 错误: %v\n", param, err)
+			continue
+		}
+		persisted := ""
+		if doesFileExist(filepath.Join(TmpFilesRoot, param+".conf")) {
+			persisted = " (已保存)"
+		}
+		fmt.Fprintf(&b, "%s: %s%s\n", param, parseUnitValue(string(data)), persisted)
+	}
+
+	b.WriteString("\n== 交换 ==\n")
+	location, err := getSwapFileLocation()
+	if err != nil {
+		fmt.Fprintf(&b, "交换文件: 错误: %v\n", err)
+	} else {
+		fmt.Fprintf(&b, "交换文件: %s\n", location)
+	}
+	if info, err := os.Stat(location); err == nil {
+		fmt.Fprintf(&b, "大小: %d 字节\n", info.Size())
+	}
+
+	b.WriteString("\n== 处理器 ==\n")
+	status, err := getCPUStatus(SysRoot)
+	if err != nil {
+		fmt.Fprintf(&b, "错误: %v\n", err)
+	} else {
+		b.WriteString(status + "\n")
+	}
+
+	b.WriteString("\n== 块设备 ==\n")
+	devices, err := getBlockDevices(SysRoot)
+	if err != nil {
+		fmt.Fprintf(&b, "错误: %v\n", err)
+	}
+	for _, device := range devices {
+		b.WriteString(device.String() + "\n")
+	}
+
+	b.WriteString("\n== 验证 ==\n")
+	b.WriteString(getAuthState().String() + "\n")
+	return b.String()
+}
+
+// CreateSupportBundle Collect logs, settings and the state of the system into a redacted tar.gz
+// for bug reports. An empty path saves it in SupportBundleDirectory.
+func CreateSupportBundle(path string) (string, error) {
+	now := time.Now()
+	name := "cryoutilities-support-" + now.Format("20060102-150405")
+	if path == "" {
+		path = filepath.Join(SupportBundleDirectory, name+".tar.gz")
+	}
+	redactor := getSupportRedactor()
+	manifest := SupportBundleManifest{
+		Version:  CurrentVersionNumber,
+		Created:  now,
+		Redacted: []string{"home paths", "usernames", "hostname", "Steam IDs"},
+	}
+
+	contents := map[string][]byte{"state.txt": []byte(redactor.redact(getSupportSnapshot()))}
+	manifest.Entries = append(manifest.Entries, newSupportBundleEntry("state.txt", "", contents["state.txt"]))
+	sources := getSupportBundleSources()
+	var names []string
+	for entry := range sources {
+		names = append(names, entry)
+	}
+	sort.Strings(names)
+	for _, entry := range names {
+		data, err := os.ReadFile(sources[entry])
+		if err != nil {
+			manifest.Entries = append(manifest.Entries, SupportBundleEntry{Name: entry,
+				Source: redactor.redact(sources[entry]), Error: redactor.redact(err.Error())})
+			continue
+		}
+		data = []byte(redactor.redact(string(data)))
+		contents[entry] = data
+		manifest.Entries = append(manifest.Entries, newSupportBundleEntry(entry, redactor.redact(sources[entry]), data))
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	err = writeSupportBundle(path, name, manifestData, manifest.Entries, contents, now)
+	if err != nil {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("无法创建支持包: %v", err)
+	}
+	CryoUtils.InfoLog.Println("已创建支持包", path)
+	return path, nil
+}
+
+func newSupportBundleEntry(name string, source string, data []byte) SupportBundleEntry {
+	sum := sha256.Sum256(data)
+	return SupportBundleEntry{Name: name, Source: source, Size: len(data), SHA256: hex.EncodeToString(sum[:])}
+}
+
+// Write the manifest and every collected file into a tar.gz, all under one directory.
+func writeSupportBundle(path string, name string, manifest []byte, entries []SupportBundleEntry,
+	contents map[string][]byte, now time.Time) error {
+	// Only the user should read it until they choose to share it.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	add := func(entry string, data []byte) error {
+		err := tw.WriteHeader(&tar.Header{Name: name + "/" + entry, Mode: 0644, Size: int64(len(data)),
+			ModTime: now, Typeflag: tar.TypeReg})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+	err = add("manifest.json", manifest)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		data, ok := contents[entry.Name]
+		if !ok {
+			continue
+		}
+		err = add(entry.Name, data)
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	err = gz.Close()
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// SupportBundleCLI Create a support bundle, at the given path or in the home directory.
+func SupportBundleCLI(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("参数过多")
+	}
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	}
+	path, err := CreateSupportBundle(path)
+	if err != nil {
+		return err
+	}
+	fmt.Println("已创建支持包:", path)
+	fmt.Println("家目录路径、用户名、主机名和 Steam ID 已被隐去，分享前仍请检查内容。")
+	return nil
+}
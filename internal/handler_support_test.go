@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	r := newRedactor("/home/deck", []string{"deck", "ab"}, "steamdeck")
+	tests := []struct {
+		in, want string
+	}{
+		{"/home/deck/.cryo_utilities/cryoutilities.log", "~/.cryo_utilities/cryoutilities.log"},
+		{"/home/deckard/games", "/home/USER/games"},
+		{"/run/media/deck/SD card", "/run/media/USER/SD card"},
+		{"/home/deck/.local/share/Steam/userdata/12345678/config", "~/.local/share/Steam/userdata/STEAMID/config"},
+		{"owner 76561198012345678 logged in", "owner STEAMID64 logged in"},
+		{`"SteamID"		"12345678"`, `"SteamID"		"STEAMID"`},
+		{"kernel: steamdeck login", "kernel: HOSTNAME login"},
+		{"a lab test", "a lab test"},
+		{"/proc/sys/vm/swappiness", "/proc/sys/vm/swappiness"},
+	}
+	for _, tt := range tests {
+		if got := r.redact(tt.in); got != tt.want {
+			t.Errorf("redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCreateSupportBundle(t *testing.T) {
+	dir := t.TempDir()
+	oldLog, oldAudit, oldSettings, oldVDF := LogFilePath, AuditLogPath, SettingsFilePath, LibraryVDFLocation
+	LogFilePath = filepath.Join(dir, "cryoutilities.log")
+	AuditLogPath = filepath.Join(dir, "audit.jsonl")
+	SettingsFilePath = filepath.Join(dir, "settings.json")
+	LibraryVDFLocation = filepath.Join(dir, "missing.vdf")
+	t.Cleanup(func() {
+		LogFilePath, AuditLogPath, SettingsFilePath, LibraryVDFLocation = oldLog, oldAudit, oldSettings, oldVDF
+	})
+	if err := os.WriteFile(LogFilePath, []byte("INFO 正在写入 /home/someone/swapfile 76561198012345678\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := CreateSupportBundle(filepath.Join(dir, "bundle.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		_, name, _ := strings.Cut(header.Name, "/")
+		files[name] = string(data)
+	}
+
+	if got := files["logs/cryoutilities.log"]; got != "INFO 正在写入 /home/USER/swapfile STEAMID64\n" {
+		t.Errorf("log in bundle = %q, want it redacted", got)
+	}
+	if !strings.Contains(files["state.txt"], "swappiness") {
+		t.Errorf("state.txt has no tunables: %q", files["state.txt"])
+	}
+	var manifest SupportBundleManifest
+	if err = json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]SupportBundleEntry{}
+	for _, entry := range manifest.Entries {
+		entries[entry.Name] = entry
+	}
+	if entry := entries["logs/cryoutilities.log"]; entry.SHA256 == "" || entry.Size != len(files["logs/cryoutilities.log"]) {
+		t.Errorf("manifest entry for the log = %+v", entry)
+	}
+	if entry := entries["steam/libraryfolders"]; entry.Error == "" {
+		t.Errorf("missing file should be listed with an error, got %+v", entry)
+	}
+	if _, ok := files["steam/libraryfolders"]; ok {
+		t.Errorf("missing file was added to the bundle")
+	}
+}
@@ -18,9 +18,9 @@ package internal
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 )
@@ -66,9 +66,9 @@ func getSwapFileLocation() (string, error) {
 
 // Get the current swap and swappiness values
 func getSwappinessValue() (int, error) {
-	cmd, err := exec.Command("sysctl", "vm.swappiness").Output()
+	cmd, err := runCommand("sysctl", "vm.swappiness")
 	if err != nil {
-		return 100, fmt.Errorf("获取当前交换度时出错")
+		return 100, withCommandOp("获取当前交换度", err)
 	}
 	output := strings.Fields(string(cmd))
 	CryoUtils.InfoLog.Println("找到交换度", output[2])
@@ -127,23 +127,23 @@ func getAvailableSwapSizes() ([]string, error) {
 // Disable swapping completely
 func disableSwap() error {
 	CryoUtils.InfoLog.Println("暂时禁用交换...")
-	_, err := exec.Command("sudo", "swapoff", "-a").Output()
+	_, err := getExecutor().Run(context.Background(), "swapoff", "-a")
 	if err != nil {
-		return fmt.Errorf("禁用交换时出错")
+		return withCommandOp("禁用交换", err)
 	}
-	return err
+	return nil
 }
 
-// Resize the swap file to the provided size, in GB.
-func resizeSwapFile(size int) error {
+// Resize the swap file to the provided size, in GB. Cancelling ctx stops dd part-way.
+func resizeSwapFile(ctx context.Context, size int) error {
 	locationArg := fmt.Sprintf("of=%s", CryoUtils.SwapFileLocation)
 	countArg := fmt.Sprintf("count=%d", size)
 
 	CryoUtils.InfoLog.Println("将交换大小调整为", size, "GB...")
 	// Use dd to write zeroes, reevaluate using Go directly in the future
-	_, err := exec.Command("sudo", "dd", "if=/dev/zero", locationArg, "bs=1G", countArg, "status=progress").Output()
+	_, err := getExecutor().Run(ctx, "dd", "if=/dev/zero", locationArg, "bs=1G", countArg, "status=progress")
 	if err != nil {
-		return fmt.Errorf("调整大小时出错 %s", CryoUtils.SwapFileLocation)
+		return withCommandOp("调整交换文件大小", err)
 	}
 	return nil
 }
@@ -151,9 +151,9 @@ func resizeSwapFile(size int) error {
 // Set swap permissions to a valid value.
 func setSwapPermissions() error {
 	CryoUtils.InfoLog.Println("设置权限", CryoUtils.SwapFileLocation, "to 0600...")
-	_, err := exec.Command("sudo", "chmod", "600", CryoUtils.SwapFileLocation).Output()
+	_, err := getExecutor().Run(context.Background(), "chmod", "600", CryoUtils.SwapFileLocation)
 	if err != nil {
-		return fmt.Errorf("设置权限时出错 %s", CryoUtils.SwapFileLocation)
+		return withCommandOp("设置交换文件权限", err)
 	}
 	return nil
 }
@@ -161,23 +161,60 @@ func setSwapPermissions() error {
 // Enable swapping on the newly resized file.
 func initNewSwapFile() error {
 	CryoUtils.InfoLog.Println("启用交换", CryoUtils.SwapFileLocation, "...")
-	_, err := exec.Command("sudo", "mkswap", CryoUtils.SwapFileLocation).Output()
+	_, err := getExecutor().Run(context.Background(), "mkswap", CryoUtils.SwapFileLocation)
 	if err != nil {
-		return fmt.Errorf("创建交换时出错 %s", CryoUtils.SwapFileLocation)
+		return withCommandOp("创建交换", err)
 	}
-	_, err = exec.Command("sudo", "swapon", CryoUtils.SwapFileLocation).Output()
+	_, err = getExecutor().Run(context.Background(), "swapon", CryoUtils.SwapFileLocation)
 	if err != nil {
-		return fmt.Errorf("启用交换时出错 %s", CryoUtils.SwapFileLocation)
+		return withCommandOp("启用交换", err)
 	}
 	return nil
 }
 
+// Get the swap file's size in GB, rounded up, so a cancelled resize can put it back.
+func getSwapFileSizeGB() int {
+	info, err := os.Stat(CryoUtils.SwapFileLocation)
+	if err != nil || info.Size() == 0 {
+		return DefaultSwapSize
+	}
+	return int((info.Size() + int64(GigabyteMultiplier) - 1) / int64(GigabyteMultiplier))
+}
+
+// Turn swap back on after a resize was cancelled. dd truncates the file as it starts, so once it has
+// run the file is written again at its previous size, in GB. previous is 0 when dd never ran.
+func rollbackSwapResize(cause error, previous int) error {
+	CryoUtils.InfoLog.Println("回滚，重新启用交换文件", CryoUtils.SwapFileLocation)
+	var err error
+	if previous > 0 {
+		// Not cancellable, swap has to come back.
+		err = resizeSwapFile(context.Background(), previous)
+	}
+	if err == nil {
+		err = setSwapPermissions()
+	}
+	if err == nil {
+		err = initNewSwapFile()
+	}
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return fmt.Errorf("%w，重新启用交换文件失败: %v", cause, err)
+	}
+	return cause
+}
+
 // ChangeSwappiness Set swappiness to the provided integer.
 func ChangeSwappiness(value string) error {
+	release, err := acquireLock(LockTunables)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	CryoUtils.InfoLog.Println("设置交换性...")
 	// Remove old swappiness file while we're at it
 	_ = removeFile(OldSwappinessUnitFile)
-	err := setUnitValue("swappiness", value)
+	err = setUnitValue("swappiness", value)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,272 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// SwapWriteSample Cumulative swap writes at a point in time, the counters reset on every boot.
+type SwapWriteSample struct {
+	Time            time.Time `json:"time"`
+	BootID          string    `json:"boot_id"`
+	PagesSwappedOut int64     `json:"pswpout"`
+	Device          string    `json:"device"`
+	SectorsWritten  int64     `json:"sectors_written"`
+}
+
+// SwapWriteReport How much has been written to swap, in GB.
+type SwapWriteReport struct {
+	Device        string        `json:"device"`
+	Session       float64       `json:"session"`
+	DeviceSession float64       `json:"device_session"`
+	Total         float64       `json:"total"`
+	PerDay        float64       `json:"per_day"`
+	DevicePerDay  float64       `json:"device_per_day"`
+	Span          time.Duration `json:"span"`
+	Samples       int           `json:"samples"`
+	Warning       bool          `json:"warning"`
+}
+
+// Find the block device (usually a partition) holding the swap file.
+func getSwapBlockDevice(sysRoot string, swapFile string) (string, error) {
+	var stat unix.Stat_t
+	err := unix.Stat(swapFile, &stat)
+	if err != nil {
+		return "", err
+	}
+	link := filepath.Join(sysRoot, "dev/block", fmt.Sprintf("%d:%d", unix.Major(stat.Dev), unix.Minor(stat.Dev)))
+	resolved, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+// Read the sectors written to a block device, from the 7th field of its stat file.
+func getSectorsWritten(deviceDirectory string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(deviceDirectory, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 7 {
+		return 0, fmt.Errorf("无法解析块设备统计: %s", deviceDirectory)
+	}
+	return strconv.ParseInt(fields[6], 10, 64)
+}
+
+// Take a sample of the swap writes so far this boot.
+func takeSwapWriteSample(procRoot string, sysRoot string) (SwapWriteSample, error) {
+	sample := SwapWriteSample{
+		Time:   time.Now(),
+		BootID: getBootID(),
+	}
+	vmstat, err := readVMStat(procRoot)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return sample, fmt.Errorf("读取虚拟内存统计时出错")
+	}
+	sample.PagesSwappedOut = vmstat["pswpout"]
+
+	// The device is a bonus, the swap counter alone is enough for an estimate.
+	location, err := getSwapFileLocation()
+	if err == nil {
+		device, err := getSwapBlockDevice(sysRoot, location)
+		if err == nil {
+			sample.Device = filepath.Base(device)
+			sample.SectorsWritten, err = getSectorsWritten(device)
+		}
+		if err != nil {
+			CryoUtils.ErrorLog.Println("无法读取交换设备的写入量:", err)
+		}
+	}
+	return sample, nil
+}
+
+// Load every saved sample, oldest first.
+func loadSwapWriteSamples() ([]SwapWriteSample, error) {
+	file, err := os.Open(SwapWriteHistoryPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var samples []SwapWriteSample
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var sample SwapWriteSample
+		// Skip damaged lines rather than losing the whole history.
+		if json.Unmarshal(scanner.Bytes(), &sample) == nil {
+			samples = append(samples, sample)
+		}
+	}
+	return samples, scanner.Err()
+}
+
+// Save a sample, dropping any older than the history limit. The GUI and the watch daemon both sample, so
+// the history is locked while it's rewritten, waiting for the other if needed since it only takes a moment.
+func saveSwapWriteSample(sample SwapWriteSample) error {
+	release, err := acquireLockWaiting(LockSwapWrites, -1)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	samples, err := loadSwapWriteSamples()
+	if err != nil {
+		return err
+	}
+	samples = append(samples, sample)
+
+	cutoff := sample.Time.Add(-SwapWriteHistoryLength)
+	var b strings.Builder
+	for _, s := range samples {
+		if s.Time.Before(cutoff) {
+			continue
+		}
+		line, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		b.Write(line)
+		b.WriteString("\n")
+	}
+
+	tempPath := SwapWriteHistoryPath + ".tmp"
+	err = writeSharedFile(tempPath, []byte(b.String()))
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, SwapWriteHistoryPath)
+}
+
+// RecordSwapWriteSample Take a sample and add it to the history.
+func RecordSwapWriteSample() error {
+	sample, err := takeSwapWriteSample(ProcRoot, SysRoot)
+	if err != nil {
+		return err
+	}
+	return saveSwapWriteSample(sample)
+}
+
+// Add up the writes across samples, the counters start from 0 after every reboot.
+func newSwapWriteReport(samples []SwapWriteSample, current SwapWriteSample) SwapWriteReport {
+	pageSize := float64(os.Getpagesize())
+	report := SwapWriteReport{
+		Device:        current.Device,
+		Session:       float64(current.PagesSwappedOut) * pageSize / float64(GigabyteMultiplier),
+		DeviceSession: float64(current.SectorsWritten) * 512 / float64(GigabyteMultiplier),
+	}
+
+	samples = append(samples, current)
+	report.Samples = len(samples)
+	var pages, sectors int64
+	for i := 1; i < len(samples); i++ {
+		previous, next := samples[i-1], samples[i]
+		if next.BootID != previous.BootID {
+			// Everything since the reboot, a little from before the last sample is lost.
+			pages += next.PagesSwappedOut
+			sectors += next.SectorsWritten
+			continue
+		}
+		if next.PagesSwappedOut >= previous.PagesSwappedOut {
+			pages += next.PagesSwappedOut - previous.PagesSwappedOut
+		}
+		if next.Device == previous.Device && next.SectorsWritten >= previous.SectorsWritten {
+			sectors += next.SectorsWritten - previous.SectorsWritten
+		}
+	}
+
+	report.Total = float64(pages) * pageSize / float64(GigabyteMultiplier)
+	report.Span = samples[len(samples)-1].Time.Sub(samples[0].Time)
+	if report.Span >= SwapWriteMinimumSpan {
+		days := report.Span.Hours() / 24
+		report.PerDay = report.Total / days
+		report.DevicePerDay = float64(sectors) * 512 / float64(GigabyteMultiplier) / days
+		report.Warning = report.PerDay >= SwapWriteWarningGBPerDay
+	}
+	return report
+}
+
+// GetSwapWriteReport Report on the swap writes in the saved history, up to now.
+func GetSwapWriteReport() (SwapWriteReport, error) {
+	samples, err := loadSwapWriteSamples()
+	if err != nil {
+		return SwapWriteReport{}, err
+	}
+	current, err := takeSwapWriteSample(ProcRoot, SysRoot)
+	if err != nil {
+		return SwapWriteReport{}, err
+	}
+	return newSwapWriteReport(samples, current), nil
+}
+
+// Format the report for display.
+func (r SwapWriteReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "本次启动写入交换: %.2fGB", r.Session)
+	if r.Device != "" {
+		fmt.Fprintf(&b, " (%s 总写入 %.2fGB)", r.Device, r.DeviceSession)
+	}
+	if r.Span < SwapWriteMinimumSpan {
+		fmt.Fprintf(&b, "\n每天平均: 数据不足，需要至少 %s 的记录", SwapWriteMinimumSpan)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "\n过去 %.1f 天写入交换: %.2fGB，每天平均 %.2fGB", r.Span.Hours()/24, r.Total, r.PerDay)
+	if r.Device != "" {
+		fmt.Fprintf(&b, " (%s 每天 %.2fGB)", r.Device, r.DevicePerDay)
+	}
+	if r.Warning {
+		fmt.Fprintf(&b, "\n警告: 每天写入交换超过 %.0fGB，可以考虑降低交换性或增加内存使用余量。", SwapWriteWarningGBPerDay)
+	}
+	return b.String()
+}
+
+// Keep recording samples until the channel closes.
+func runSwapWriteSampler(stop <-chan struct{}) {
+	ticker := time.NewTicker(SwapWriteSampleInterval)
+	defer ticker.Stop()
+	for {
+		err := RecordSwapWriteSample()
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
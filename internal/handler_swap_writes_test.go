@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestNewSwapWriteReport(t *testing.T) {
+	// One GB worth of pages, so the expected values are easy to read.
+	gb := int64(GigabyteMultiplier / os.Getpagesize())
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(hours int, bootID string, pages int64) SwapWriteSample {
+		return SwapWriteSample{Time: start.Add(time.Duration(hours) * time.Hour), BootID: bootID, PagesSwappedOut: pages}
+	}
+
+	tests := []struct {
+		name        string
+		samples     []SwapWriteSample
+		current     SwapWriteSample
+		wantTotal   float64
+		wantPerDay  float64
+		wantSession float64
+	}{
+		{
+			name:        "No history",
+			current:     sample(0, "a", 2*gb),
+			wantSession: 2,
+		},
+		{
+			name:        "Too little history",
+			samples:     []SwapWriteSample{sample(0, "a", gb)},
+			current:     sample(0, "a", 2*gb),
+			wantTotal:   1,
+			wantSession: 2,
+		},
+		{
+			name:        "Same boot",
+			samples:     []SwapWriteSample{sample(0, "a", gb), sample(12, "a", 3*gb)},
+			current:     sample(24, "a", 5*gb),
+			wantTotal:   4,
+			wantPerDay:  4,
+			wantSession: 5,
+		},
+		{
+			name:        "Across a reboot",
+			samples:     []SwapWriteSample{sample(0, "a", gb), sample(24, "a", 3*gb)},
+			current:     sample(48, "b", 2*gb),
+			wantTotal:   4,
+			wantPerDay:  2,
+			wantSession: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newSwapWriteReport(tt.samples, tt.current)
+			if math.Abs(got.Total-tt.wantTotal) > 0.001 {
+				t.Errorf("Total = %v, want %v", got.Total, tt.wantTotal)
+			}
+			if math.Abs(got.PerDay-tt.wantPerDay) > 0.001 {
+				t.Errorf("PerDay = %v, want %v", got.PerDay, tt.wantPerDay)
+			}
+			if math.Abs(got.Session-tt.wantSession) > 0.001 {
+				t.Errorf("Session = %v, want %v", got.Session, tt.wantSession)
+			}
+		})
+	}
+}
+
+func TestSaveSwapWriteSampleWaitsForLock(t *testing.T) {
+	useLockDirectory(t, 0)
+	oldHistoryPath := SwapWriteHistoryPath
+	SwapWriteHistoryPath = filepath.Join(t.TempDir(), "swap_writes.jsonl")
+	t.Cleanup(func() { SwapWriteHistoryPath = oldHistoryPath })
+
+	// The watch daemon is in the middle of rewriting the history.
+	file := holdLock(t, LockSwapWrites, `{"pid":1,"caller":"watch"}`)
+	saved := make(chan error)
+	go func() { saved <- saveSwapWriteSample(SwapWriteSample{Time: time.Now(), PagesSwappedOut: 2}) }()
+	select {
+	case err := <-saved:
+		t.Fatalf("saveSwapWriteSample() didn't wait for the lock: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	line, _ := json.Marshal(SwapWriteSample{Time: time.Now(), PagesSwappedOut: 1})
+	err := os.WriteFile(SwapWriteHistoryPath, append(line, '\n'), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Truncate(0)
+	_ = unix.Flock(int(file.Fd()), unix.LOCK_UN)
+
+	if err = <-saved; err != nil {
+		t.Fatalf("saveSwapWriteSample() = %v", err)
+	}
+	samples, err := loadSwapWriteSamples()
+	if err != nil || len(samples) != 2 {
+		t.Errorf("loadSwapWriteSamples() = %+v, %v, want both samples", samples, err)
+	}
+}
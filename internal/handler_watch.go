@@ -0,0 +1,404 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// WatchOptions Which parts of the watch daemon to run.
+type WatchOptions struct {
+	Interval time.Duration
+	// Lower the priority of shader compiles while a game is running, whatever the settings say.
+	DeprioritizeShaders bool
+	// Switch between the AC and battery profiles as the power source changes.
+	Power bool
+}
+
+// GameWatcher Applies a game's profile while it's running, and restores the baseline after it exits.
+type GameWatcher struct {
+	procRoot string
+	settings Settings
+	baseline TunableProfile
+	active   int
+	read     func([]string) (TunableProfile, error)
+	apply    func(TunableProfile) error
+	// The games seen by the last step, shared with the rest of the daemon.
+	running map[int][]int
+}
+
+func newGameWatcher(procRoot string, settings Settings, baseline TunableProfile,
+	read func([]string) (TunableProfile, error), apply func(TunableProfile) error) *GameWatcher {
+	return &GameWatcher{
+		procRoot: procRoot,
+		settings: settings,
+		baseline: baseline,
+		read:     read,
+		apply:    apply,
+	}
+}
+
+// Pick the game whose profile should be live, preferring the one already applied.
+func (w *GameWatcher) chooseGame(games map[int][]int) int {
+	if _, running := games[w.active]; running && w.active != 0 {
+		return w.active
+	}
+
+	var appIDs []int
+	for appID := range games {
+		appIDs = append(appIDs, appID)
+	}
+	sort.Ints(appIDs)
+	for _, appID := range appIDs {
+		if _, ok := w.settings.GameProfiles[appID]; ok {
+			return appID
+		}
+	}
+	return 0
+}
+
+// Get the values that should be live for a game, or the baseline if appID is 0.
+func (w *GameWatcher) desired(appID int) (TunableProfile, error) {
+	desired := TunableProfile{}
+	for param, value := range w.baseline {
+		desired[param] = value
+	}
+	if appID == 0 {
+		return desired, nil
+	}
+
+	profile, err := w.settings.getProfile(w.settings.GameProfiles[appID])
+	if err != nil {
+		return nil, err
+	}
+	for param, value := range profile {
+		desired[param] = value
+	}
+	return desired, nil
+}
+
+// Check the running games once, applying or restoring profiles as needed.
+func (w *GameWatcher) step() error {
+	games, err := getRunningGames(w.procRoot)
+	if err != nil {
+		return err
+	}
+	w.running = games
+
+	// While no game's profile is live, what's set is what to go back to, ex: changed in the GUI since the start.
+	if w.active == 0 && len(w.baseline) != 0 {
+		current, err := w.read(w.baseline.params())
+		if err != nil {
+			return err
+		}
+		w.baseline = current
+	}
+
+	next := w.chooseGame(games)
+	if next == w.active {
+		return nil
+	}
+
+	desired, err := w.desired(next)
+	if err != nil {
+		return err
+	}
+	if next == 0 {
+		CryoUtils.InfoLog.Println("游戏", w.active, "已退出，恢复基准设置", desired.String())
+	} else {
+		CryoUtils.InfoLog.Println("检测到游戏", next, "，应用配置文件", w.settings.GameProfiles[next], desired.String())
+	}
+	if len(desired) != 0 {
+		err = w.apply(desired)
+		if err != nil {
+			return err
+		}
+	}
+	w.active = next
+	return nil
+}
+
+// Change the baseline, applying it at once unless a game's profile overrides a value.
+func (w *GameWatcher) setBaseline(profile TunableProfile) error {
+	for param, value := range profile {
+		w.baseline[param] = value
+	}
+	desired, err := w.desired(w.active)
+	if err != nil {
+		return err
+	}
+	return w.apply(desired)
+}
+
+// Put the baseline back, used when the watcher stops while a game is still running.
+func (w *GameWatcher) restore() error {
+	if w.active == 0 || len(w.baseline) == 0 {
+		return nil
+	}
+	CryoUtils.InfoLog.Println("监视器停止，恢复基准设置", w.baseline.String())
+	err := w.apply(w.baseline)
+	if err != nil {
+		return err
+	}
+	w.active = 0
+	return nil
+}
+
+// Watch Run the watch daemon until the context is cancelled.
+func Watch(ctx context.Context, opts WatchOptions) error {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultWatchInterval
+	}
+	// Nobody sees the daemon's errors, so it waits its turn for the GUI or CLI instead.
+	if CryoUtils.LockWait == 0 {
+		CryoUtils.LockWait = -1
+	}
+
+	err := startWatchHelper()
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+	}
+
+	var watched settingsCache
+	settings, err := watched.get()
+	if err != nil {
+		return err
+	}
+	params := settings.getGameProfileParams()
+	if opts.Power {
+		powerParams, err := settings.getPowerProfileParams()
+		if err != nil {
+			return err
+		}
+		params = append(params, powerParams...)
+	}
+	// Capture the values before any game touches them, that's what gets restored on exit.
+	baseline, err := getCurrentProfile(params)
+	if err != nil {
+		return err
+	}
+	games := newGameWatcher(ProcRoot, settings, baseline, getCurrentProfile, applyProfileLive)
+	CryoUtils.InfoLog.Println("开始监视游戏，已配置", len(settings.GameProfiles), "个游戏，基准设置", baseline.String())
+
+	// The power profile becomes the baseline, so a game's profile still wins while it runs.
+	var power *PowerWatcher
+	if opts.Power {
+		original := TunableProfile{}
+		for param, value := range baseline {
+			original[param] = value
+		}
+		power = newPowerWatcher(SysRoot, settings, original, games.setBaseline)
+		CryoUtils.InfoLog.Println("开始监视电源:", settings.Power.String())
+	}
+
+	// The daemon runs all the time, so it's the best place to keep the swap write history going.
+	go runSwapWriteSampler(ctx.Done())
+
+	var cgroups *CgroupManager
+	shaderCgroup := ""
+	if base, err := getDelegatedCgroup(ProcRoot); err == nil {
+		shaderCgroup = filepath.Join(base, ShaderCgroupName)
+	} else {
+		CryoUtils.ErrorLog.Println(err)
+	}
+	shaders := newShaderLimiter(ProcRoot, CgroupRoot, shaderCgroup, getThreadPriority, setThreadPriority)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		// The helper may have been started by the GUI, and stopped with it.
+		if _, ok := currentExecutor().(HelperExecutor); ok {
+			err = startWatchHelper()
+			if err != nil {
+				CryoUtils.ErrorLog.Println(err)
+			}
+		}
+		// A trial from the GUI or the CLI that died is reverted here, on time.
+		err = RecoverExpiredSafeApply()
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+		}
+		if power != nil {
+			err = power.step()
+			if err != nil {
+				CryoUtils.ErrorLog.Println(err)
+			}
+		}
+		err = games.step()
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+		}
+		// The cgroup and shader settings can be changed while running.
+		settings, err = watched.get()
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+		}
+		cgroups = stepCgroups(cgroups, games.running, settings)
+		err = shaders.step(len(games.running) > 0 && (opts.DeprioritizeShaders || settings.DeprioritizeShaders))
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			err = shaders.restore()
+			if err != nil {
+				CryoUtils.ErrorLog.Println(err)
+			}
+			if cgroups != nil {
+				err = cgroups.restore()
+				if err != nil {
+					CryoUtils.ErrorLog.Println(err)
+				}
+			}
+			err = games.restore()
+			if power != nil {
+				if err != nil {
+					CryoUtils.ErrorLog.Println(err)
+				}
+				err = power.restore()
+			}
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// Get root for the daemon. It runs as a systemd user service, so it starts the privileged helper through
+// the sudoers rule, which never asks for a password.
+func startWatchHelper() error {
+	if os.Geteuid() == 0 {
+		setExecutor(RootExecutor{})
+		return nil
+	}
+	err := StartHelper("")
+	if err != nil {
+		return fmt.Errorf("监视守护进程无法获得 root 权限，请先用 sudo 运行 'auth sudoers install': %v", err)
+	}
+	setExecutor(HelperExecutor{})
+	return nil
+}
+
+// Start, run or stop game memory protection, following the setting so it can be toggled while running.
+func stepCgroups(cgroups *CgroupManager, running map[int][]int, settings Settings) *CgroupManager {
+	// Start over whenever the limits change, the cgroups are only set up once.
+	if cgroups != nil && (!settings.Cgroup.Enabled || cgroups.settings != settings.Cgroup) {
+		err := cgroups.restore()
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+		}
+		cgroups = nil
+	}
+	if !settings.Cgroup.Enabled {
+		return nil
+	}
+	if cgroups == nil {
+		base, err := getDelegatedCgroup(ProcRoot)
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+			return nil
+		}
+		cgroups = newCgroupManager(CgroupRoot, base, ProcRoot, settings.Cgroup)
+		err = cgroups.setup()
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+			return nil
+		}
+	}
+
+	err := cgroups.step(running)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+	}
+	return cgroups
+}
+
+// InstallWatchService Install and start a systemd user service running the watch daemon.
+func InstallWatchService(args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var quoted []string
+	for _, arg := range append([]string{executable, "watch"}, args...) {
+		quoted = append(quoted, quoteSystemdArg(arg))
+	}
+	execStart := strings.Join(quoted, " ")
+	contents := strings.ReplaceAll(WatchServiceTemplate, "EXEC_START", execStart)
+
+	path := filepath.Join(SystemdUserDirectory, WatchServiceName)
+	CryoUtils.InfoLog.Println("正在写入", path)
+	err = os.MkdirAll(SystemdUserDirectory, 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = runCommand("systemctl", "--user", "daemon-reload")
+	if err != nil {
+		return withCommandOp("重新加载 systemd 用户服务", err)
+	}
+	_, err = runCommand("systemctl", "--user", "enable", "--now", WatchServiceName)
+	if err != nil {
+		return withCommandOp("启用监视服务", err)
+	}
+	// The service runs as the user, it can only change settings through a helper it can start unattended.
+	state := getAuthState()
+	if !state.HelperNoPassword || state.HelperCopyOutdated {
+		CryoUtils.ErrorLog.Println("监视服务无法在没有密码的情况下启动特权助手，只能做不需要 root 的事。" +
+			"请运行 'sudo " + executable + " auth sudoers install'")
+	}
+	return nil
+}
+
+// Quote an argument for ExecStart, where spaces split arguments, % starts a specifier and $ a variable.
+func quoteSystemdArg(arg string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "%", "%%", "$", "$$")
+	return `"` + replacer.Replace(arg) + `"`
+}
+
+// UninstallWatchService Stop and remove the watch daemon's systemd user service.
+func UninstallWatchService() error {
+	_, err := runCommand("systemctl", "--user", "disable", "--now", WatchServiceName)
+	if err != nil {
+		CryoUtils.ErrorLog.Println("无法禁用", WatchServiceName, ", 可能未安装。", err)
+	}
+	path := filepath.Join(SystemdUserDirectory, WatchServiceName)
+	CryoUtils.InfoLog.Println("删除中", path)
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err = runCommand("systemctl", "--user", "daemon-reload")
+	if err != nil {
+		return withCommandOp("重新加载 systemd 用户服务", err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Create a fake process in a fake proc root.
+func makeFakeProcess(t *testing.T, procRoot string, pid int, comm string, cmdline []string, environ []string) {
+	t.Helper()
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"comm":    comm + "\n",
+		"cmdline": strings.Join(cmdline, "\x00") + "\x00",
+		"environ": strings.Join(environ, "\x00") + "\x00",
+	}
+	for name, contents := range files {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetRunningGames(t *testing.T) {
+	procRoot := t.TempDir()
+	makeFakeProcess(t, procRoot, 100, "reaper",
+		[]string{"/home/deck/.local/share/Steam/ubuntu12_32/reaper", "SteamLaunch", "AppId=1174180", "--", "proton"},
+		[]string{"HOME=/home/deck"})
+	makeFakeProcess(t, procRoot, 101, "RDR2.exe",
+		[]string{"Z:\\RDR2.exe"},
+		[]string{"HOME=/home/deck", "STEAM_COMPAT_APP_ID=1174180"})
+	makeFakeProcess(t, procRoot, 102, "game.exe",
+		[]string{"game.exe"},
+		[]string{"SteamAppId=620"})
+	makeFakeProcess(t, procRoot, 103, "steam",
+		[]string{"steam"},
+		[]string{"SteamAppId=0"})
+	makeFakeProcess(t, procRoot, 104, "bash",
+		[]string{"bash", "AppId=5"},
+		[]string{"HOME=/home/deck"})
+	// Kernel interfaces that aren't processes should be ignored
+	_ = os.MkdirAll(filepath.Join(procRoot, "self"), 0755)
+	_ = os.WriteFile(filepath.Join(procRoot, "meminfo"), []byte(""), 0644)
+
+	got, err := getRunningGames(procRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int][]int{1174180: {100, 101}, 620: {102}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getRunningGames() = %v, want %v", got, want)
+	}
+}
+
+func TestGameWatcherStep(t *testing.T) {
+	procRoot := t.TempDir()
+	settings := Settings{
+		Profiles: map[string]TunableProfile{
+			"rdr2": {"swappiness": "60", "hugepages": "madvise"},
+		},
+		GameProfiles: map[int]string{1174180: "rdr2"},
+	}
+	baseline := TunableProfile{"swappiness": "1", "hugepages": "always"}
+	changed := TunableProfile{"swappiness": "10", "hugepages": "always"}
+
+	live := baseline
+	var applied []TunableProfile
+	w := newGameWatcher(procRoot, settings, baseline, func([]string) (TunableProfile, error) {
+		return live, nil
+	}, func(p TunableProfile) error {
+		applied = append(applied, p)
+		return nil
+	})
+
+	steps := []struct {
+		name  string
+		setup func()
+		want  []TunableProfile
+	}{
+		{
+			name:  "Nothing running",
+			setup: func() {},
+			want:  nil,
+		},
+		{
+			name: "Game without a profile",
+			setup: func() {
+				makeFakeProcess(t, procRoot, 200, "game.exe", []string{"game.exe"}, []string{"SteamAppId=620"})
+			},
+			want: nil,
+		},
+		{
+			name: "Changed while no game runs",
+			setup: func() {
+				live = changed
+			},
+			want: nil,
+		},
+		{
+			name: "Game with a profile",
+			setup: func() {
+				makeFakeProcess(t, procRoot, 300, "RDR2.exe", []string{"RDR2.exe"}, []string{"SteamAppId=1174180"})
+			},
+			want: []TunableProfile{{"swappiness": "60", "hugepages": "madvise"}},
+		},
+		{
+			name:  "Game still running",
+			setup: func() {},
+			want:  []TunableProfile{{"swappiness": "60", "hugepages": "madvise"}},
+		},
+		{
+			name: "Game exited",
+			setup: func() {
+				_ = os.RemoveAll(filepath.Join(procRoot, "300"))
+			},
+			want: []TunableProfile{{"swappiness": "60", "hugepages": "madvise"}, changed},
+		},
+	}
+
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup()
+			err := w.step()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(applied, tt.want) {
+				t.Errorf("applied = %v, want %v", applied, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteSystemdArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"watch", `"watch"`},
+		{"/home/deck/My Apps/cryo_utilities", `"/home/deck/My Apps/cryo_utilities"`},
+		{`say "hi"\now`, `"say \"hi\"\\now"`},
+		{"100%", `"100%%"`},
+		{"$HOME", `"$$HOME"`},
+	}
+	for _, tt := range tests {
+		if got := quoteSystemdArg(tt.arg); got != tt.want {
+			t.Errorf("quoteSystemdArg(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestSettingsCache(t *testing.T) {
+	oldPath := SettingsFilePath
+	SettingsFilePath = filepath.Join(t.TempDir(), "settings.json")
+	t.Cleanup(func() { SettingsFilePath = oldPath })
+
+	var cache settingsCache
+	settings, err := cache.get()
+	if err != nil || settings.DeprioritizeShaders {
+		t.Fatalf("get() without a file = %+v, %v", settings, err)
+	}
+	settings.DeprioritizeShaders = true
+	if err = saveSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings, err = cache.get(); err != nil || !settings.DeprioritizeShaders {
+		t.Errorf("get() after saving = %v, %v, want the change", settings.DeprioritizeShaders, err)
+	}
+
+	// Loaded again only when the file changes, a damaged one keeps the last good settings.
+	if err = os.WriteFile(SettingsFilePath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if settings, err = cache.get(); err == nil || !settings.DeprioritizeShaders {
+		t.Errorf("get() of a damaged file = %v, %v, want an error and the last settings", settings.DeprioritizeShaders, err)
+	}
+	if settings, err = cache.get(); err != nil || !settings.DeprioritizeShaders {
+		t.Errorf("get() of the same damaged file = %v, %v, want no error again", settings.DeprioritizeShaders, err)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"os/exec"
 	"time"
 
+	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
 
@@ -33,6 +34,10 @@ func init() {
 
 // Renews sudo auth for GUI mode
 func renewSudoAuth() {
+	// With the privileged helper running there's no password to renew with, and no need.
+	if CryoUtils.UserPassword == "" {
+		return
+	}
 	// Do a really basic command to renew sudo auth
 	cmd := exec.Command("sudo", "-S", "--", "echo")
 	//Sudo will exit immediately if it's the correct password, but will hang for a moment if it isn't.
@@ -93,3 +98,102 @@ func testAuth(password string) error {
 	}
 	return nil
 }
+
+// Check the password, start the privileged helper with it and continue to the main UI.
+// Leaves the user where they are if the password is wrong.
+func (app *Config) authenticate(password string, installDropIn bool) {
+	CryoUtils.InfoLog.Println("检测密码...")
+	err := testAuth(password)
+	if err != nil {
+		CryoUtils.InfoLog.Println("密码无效，请重新输入...")
+		dialog.ShowInformation("密码错误", "密码错误，请重试。",
+			CryoUtils.MainWindow)
+		return
+	}
+	CryoUtils.InfoLog.Println("密码有效，继续...")
+	continueToMainUI := func() {
+		if installDropIn {
+			err := InstallSudoersDropIn(password)
+			if err != nil {
+				presentErrorInUI(err, CryoUtils.MainWindow)
+			}
+		}
+		app.mainUI()
+	}
+	// The helper does everything that needs root from now on, so the password can be forgotten.
+	err = StartHelper(password)
+	if err == nil {
+		setExecutor(HelperExecutor{})
+		continueToMainUI()
+		return
+	}
+
+	// Keeping the password is what the helper is there to avoid, so only do it if the user agrees.
+	CryoUtils.ErrorLog.Println("无法启动特权助手:", err)
+	dialog.ShowConfirm("无法启动特权助手",
+		"无法启动特权助手: "+err.Error()+"\n\n"+
+			"可以改为直接使用 sudo，但密码会保存在内存中，直到 CryoUtilities 退出。\n"+
+			"是否继续？",
+		func(b bool) {
+			if !b {
+				CryoUtils.InfoLog.Println("未启动特权助手，用户拒绝保存密码")
+				return
+			}
+			CryoUtils.InfoLog.Println("未启动特权助手，用户同意在内存中保存密码并使用 sudo")
+			CryoUtils.UserPassword = password
+			setExecutor(CommandExecutor{Command: []string{"sudo"}})
+			continueToMainUI()
+		}, CryoUtils.MainWindow)
+}
+
+// Get the check box offering the sudoers drop-in, so the password is only needed once.
+func (app *Config) dropInCheck() *widget.Check {
+	check := widget.NewCheck("以后不再询问密码（安装 sudoers 规则）", func(checked bool) {
+		if checked {
+			dialog.ShowInformation("sudoers 规则",
+				"此规则只允许无需密码启动 CryoUtilities 的特权助手。\n"+
+					"规则指向 "+HelperInstallPath+" 中只有 root 能修改的副本，\n"+
+					"更新 CryoUtilities 后需要重新安装，否则会再次询问密码。\n"+
+					"可随时用 'auth sudoers remove' 命令删除。",
+				app.MainWindow)
+		}
+	})
+	return check
+}
+
+// Let the user set a password, SteamOS ships without one and sudo can't be used until there is.
+func (app *Config) setPasswordUI() {
+	passwordEntry := widget.NewPasswordEntry()
+	passwordEntry.SetPlaceHolder("新密码")
+	confirmEntry := widget.NewPasswordEntry()
+	confirmEntry.SetPlaceHolder("确认新密码")
+	dropInCheck := app.dropInCheck()
+	submit := func() {
+		err := validateNewPassword(passwordEntry.Text, confirmEntry.Text)
+		if err == nil {
+			err = SetUserPassword(passwordEntry.Text)
+		}
+		if err != nil {
+			presentErrorInUI(err, app.MainWindow)
+			return
+		}
+		app.authenticate(passwordEntry.Text, dropInCheck.Checked)
+	}
+	confirmEntry.OnSubmitted = func(string) { submit() }
+	setButton := widget.NewButton("设置密码", submit)
+	info := widget.NewLabel("此账户还没有密码，SteamOS 默认不设置密码，因此无法使用 sudo。\n" +
+		"在这里设置的密码以后也用于 sudo 和 CryoUtilities，请记住它。")
+	passwordVBox := container.NewVBox(info, passwordEntry, confirmEntry, dropInCheck, setButton)
+	passwordCard := widget.NewCard("设置 deck 密码", "CryoUtilities 需要 root 权限", passwordVBox)
+	app.MainWindow.SetContent(passwordCard)
+}
+
+// Tell the user their password is locked, setting a new one needs root so there's nothing to offer here.
+func (app *Config) lockedAccountUI() {
+	info := widget.NewLabel("此账户的密码已被锁定，无法使用 sudo，CryoUtilities 也无法设置新密码。\n" +
+		"请在终端中以 root 运行 passwd -u deck 解锁，或用 passwd deck 设置新密码，然后重新打开 CryoUtilities。")
+	quitButton := widget.NewButton("退出", func() { app.MainWindow.Close() })
+	lockedCard := widget.NewCard("deck 账户已锁定", "CryoUtilities 需要 root 权限",
+		container.NewVBox(info, quitButton))
+	app.MainWindow.SetContent(lockedCard)
+}
@@ -18,6 +18,7 @@ package internal
 
 import (
 	"errors"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -51,7 +52,30 @@ func InitUI() {
 }
 
 func (app *Config) makeUI() {
-	app.authUI()
+	state := getAuthState()
+	CryoUtils.InfoLog.Println("验证状态:", strings.ReplaceAll(state.String(), "\n", ", "))
+	switch {
+	// There's nothing to authenticate when already root.
+	case state.Root:
+		setExecutor(RootExecutor{})
+		app.mainUI()
+	case state.HelperRunning:
+		setExecutor(HelperExecutor{})
+		app.mainUI()
+	case state.HelperNoPassword && !state.HelperCopyOutdated && StartHelper("") == nil:
+		setExecutor(HelperExecutor{})
+		app.mainUI()
+	case !state.SudoInstalled:
+		// doas or run0 ask for the password themselves, when they're needed.
+		setExecutor(detectExecutor())
+		app.mainUI()
+	case state.PasswordLocked:
+		app.lockedAccountUI()
+	case !state.PasswordSet:
+		app.setPasswordUI()
+	default:
+		app.authUI()
+	}
 
 	// Show a disclaimer that I'm not responsible for damage.
 	dialog.ShowConfirm("免责声明",
@@ -78,11 +102,18 @@ func (app *Config) makeUI() {
 }
 
 func (app *Config) mainUI() {
+	// Undo any trial change that a previous run never confirmed or reverted.
+	err := RecoverSafeApply()
+	if err != nil {
+		presentErrorInUI(err, app.MainWindow)
+	}
+
 	// Create heading section
 	tabs := container.NewAppTabs(
 		container.NewTabItemWithIcon("主页", theme.HomeIcon(), app.homeTab()),
 		container.NewTabItemWithIcon("交换文件", theme.MailReplyAllIcon(), app.swapTab()),
 		container.NewTabItemWithIcon("内存", theme.ComputerIcon(), app.memoryTab()),
+		container.NewTabItemWithIcon("处理器", theme.SettingsIcon(), app.cpuTab()),
 		container.NewTabItemWithIcon("存储", theme.StorageIcon(), app.storageTab()),
 		container.NewTabItemWithIcon("显存", theme.ViewFullScreenIcon(), app.vramTab()),
 	)
@@ -93,35 +124,16 @@ func (app *Config) mainUI() {
 }
 
 func (app *Config) authUI() {
-	// Refactor this, duplicated code.
 	passwordEntry := widget.NewPasswordEntry()
-	passwordEntry.OnSubmitted = func(s string) {
-		CryoUtils.InfoLog.Println("检测密码...")
-		err := testAuth(s)
-		if err != nil {
-			CryoUtils.InfoLog.Println("密码无效，请重新输入...")
-			dialog.ShowInformation("密码错误", "密码错误，请重试。",
-				CryoUtils.MainWindow)
-		} else {
-			CryoUtils.InfoLog.Println("密码有效，继续...")
-			CryoUtils.UserPassword = s
-			app.mainUI()
-		}
+	dropInCheck := app.dropInCheck()
+	submit := func(password string) {
+		app.authenticate(password, dropInCheck.Checked)
 	}
+	passwordEntry.OnSubmitted = submit
 	passwordButton := widget.NewButton("提交", func() {
-		CryoUtils.InfoLog.Println("检测密码...")
-		err := testAuth(passwordEntry.Text)
-		if err != nil {
-			CryoUtils.InfoLog.Println("密码无效，请重新输入...")
-			dialog.ShowInformation("密码错误", "密码错误，请重试。",
-				CryoUtils.MainWindow)
-		} else {
-			CryoUtils.InfoLog.Println("密码有效，继续...")
-			CryoUtils.UserPassword = passwordEntry.Text
-			app.mainUI()
-		}
+		submit(passwordEntry.Text)
 	})
-	passwordVBox := container.NewVBox(passwordEntry, passwordButton)
+	passwordVBox := container.NewVBox(passwordEntry, dropInCheck, passwordButton)
 	passwordContainer := widget.NewCard("输入你的 sudo/deck 密码", "输入你的 sudo/deck 密码", passwordVBox)
 
 	//  Add container to window
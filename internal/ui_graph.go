@@ -0,0 +1,163 @@
+// CryoUtilities
+// Copyright (C) 2023 CryoByte33 and contributors to the CryoUtilities project
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"image"
+	"image/color"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+
+	"os"
+)
+
+func init() {
+	//设置中文字体
+	os.Setenv("FYNE_FONT", "/home/deck/.cryo_utilities/font.ttf")
+}
+
+// RollingGraph A line graph of the most recent samples of one or more series, newest on the right.
+type RollingGraph struct {
+	raster  *canvas.Raster
+	colors  []color.Color
+	samples [][]float64
+	size    int
+	max     float64
+	mu      sync.Mutex
+}
+
+// Create a graph holding size samples per series. A max of 0 scales to the largest value shown.
+func newRollingGraph(size int, max float64, colors ...color.Color) *RollingGraph {
+	if size < 2 {
+		size = 2
+	}
+	g := &RollingGraph{
+		colors:  colors,
+		samples: make([][]float64, len(colors)),
+		size:    size,
+		max:     max,
+	}
+	g.raster = canvas.NewRaster(g.draw)
+	g.raster.SetMinSize(fyne.NewSize(300, 80))
+	return g
+}
+
+// Add one sample to each series, in the same order as the colors were given.
+func (g *RollingGraph) Add(values ...float64) {
+	g.mu.Lock()
+	for i := range g.samples {
+		var value float64
+		if i < len(values) {
+			value = values[i]
+		}
+		g.samples[i] = append(g.samples[i], value)
+		if len(g.samples[i]) > g.size {
+			g.samples[i] = g.samples[i][len(g.samples[i])-g.size:]
+		}
+	}
+	g.mu.Unlock()
+	g.raster.Refresh()
+}
+
+// Get the graph for placing in a container.
+func (g *RollingGraph) CanvasObject() fyne.CanvasObject {
+	return g.raster
+}
+
+func (g *RollingGraph) draw(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	if w < 2 || h < 2 {
+		return img
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Axis along the bottom
+	for x := 0; x < w; x++ {
+		img.Set(x, h-1, Gray)
+	}
+
+	top := g.max
+	if top <= 0 {
+		for _, series := range g.samples {
+			for _, value := range series {
+				if value > top {
+					top = value
+				}
+			}
+		}
+	}
+	if top <= 0 {
+		return img
+	}
+
+	step := float64(w-1) / float64(g.size-1)
+	for i, series := range g.samples {
+		// Right-align the series, so the newest sample is always at the edge.
+		offset := g.size - len(series)
+		lastX, lastY := -1, -1
+		for j, value := range series {
+			if value > top {
+				value = top
+			}
+			x := int(float64(offset+j) * step)
+			y := h - 1 - int(value/top*float64(h-1))
+			if lastX >= 0 {
+				drawLine(img, lastX, lastY, x, y, g.colors[i])
+			}
+			lastX, lastY = x, y
+		}
+	}
+	return img
+}
+
+// Draw a line between two points, using Bresenham's algorithm.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx := x1 - x0
+	if dx < 0 {
+		dx = -dx
+	}
+	dy := y1 - y0
+	if dy < 0 {
+		dy = -dy
+	}
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx - dy
+	for {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 > -dy {
+			err -= dy
+			x0 += sx
+		}
+		if e2 < dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
@@ -17,6 +17,7 @@
 package internal
 
 import (
+	"fmt"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
@@ -65,43 +66,45 @@ func (app *Config) homeTab() *fyne.Container {
 			"大页面中的共享内存: 启用")
 
 	recommendedButton := widget.NewButton("推荐设置", func() {
-		progressGroup := container.NewVBox(
+		ctx, done := showCancellableProgress(CryoUtils.MainWindow,
 			canvas.NewText("正在应用推荐设置...", White),
 			actionText,
 			widget.NewProgressBarInfinite())
-		modal := widget.NewModalPopUp(progressGroup, CryoUtils.MainWindow.Canvas())
-		modal.Show()
-		renewSudoAuth()
-		err := UseRecommendedSettings()
-		if err != nil {
-			presentErrorInUI(err, CryoUtils.MainWindow)
-		}
-		modal.Hide()
-		app.refreshAllContent()
-		dialog.ShowInformation(
-			"成功!",
-			"应用推荐设置!",
-			CryoUtils.MainWindow,
-		)
+		go func() {
+			renewSudoAuth()
+			err := UseRecommendedSettings(ctx)
+			done()
+			app.refreshAllContent()
+			if err != nil {
+				presentErrorInUI(err, CryoUtils.MainWindow)
+				return
+			}
+			dialog.ShowInformation(
+				"成功!",
+				"应用推荐设置!",
+				CryoUtils.MainWindow,
+			)
+		}()
 	})
 	stockButton := widget.NewButton("恢复默认", func() {
 		progressText := canvas.NewText("恢复到默认设置...", White)
 		progressBar := widget.NewProgressBarInfinite()
-		progressGroup := container.NewVBox(progressText, progressBar)
-		modal := widget.NewModalPopUp(progressGroup, CryoUtils.MainWindow.Canvas())
-		modal.Show()
-		renewSudoAuth()
-		err := UseStockSettings()
-		if err != nil {
-			presentErrorInUI(err, CryoUtils.MainWindow)
-		}
-		modal.Hide()
-		app.refreshAllContent()
-		dialog.ShowInformation(
-			"成功!",
-			"已恢复到默认设置!",
-			CryoUtils.MainWindow,
-		)
+		ctx, done := showCancellableProgress(CryoUtils.MainWindow, progressText, progressBar)
+		go func() {
+			renewSudoAuth()
+			err := UseStockSettings(ctx)
+			done()
+			app.refreshAllContent()
+			if err != nil {
+				presentErrorInUI(err, CryoUtils.MainWindow)
+				return
+			}
+			dialog.ShowInformation(
+				"成功!",
+				"已恢复到默认设置!",
+				CryoUtils.MainWindow,
+			)
+		}()
 	})
 
 	recommendedSettings := widget.NewCard("推荐设置", "将所有设置设置为 "+
@@ -109,11 +112,25 @@ func (app *Config) homeTab() *fyne.Container {
 	stockSettings := widget.NewCard("默认设置", "将所有设置重置为 V社 默认值，不包含 "+
 		"“游戏数据” 选项卡/位置。", stockButton)
 
+	logButton := widget.NewButton("查看日志", func() {
+		logViewerWindow()
+	})
+	supportButton := widget.NewButton("生成支持包", func() {
+		path, err := CreateSupportBundle("")
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+			return
+		}
+		dialog.ShowInformation("支持包", "已保存到:\n"+path+"\n\n"+
+			"家目录路径、用户名、主机名和 Steam ID 已被隐去，分享前仍请检查内容。", CryoUtils.MainWindow)
+	})
+
 	homeVBox := container.NewVBox(
 		welcomeText,
 		subheadingText,
 		recommendedSettings,
 		stockSettings,
+		container.NewGridWithColumns(2, logButton, supportButton),
 	)
 	app.HomeContainer = homeVBox
 
@@ -137,6 +154,18 @@ func (app *Config) swapTab() *fyne.Container {
 	swapCard := widget.NewCard("交换文件", "调整交换文件的大小。", swapResizeButton)
 	swappinessCard := widget.NewCard("交换性", "调整交换值。", swappinessChangeButton)
 
+	app.MemoryUsageText = widget.NewLabel("内存使用: 未知")
+	processSwapButton := widget.NewButton("查看进程", func() {
+		processSwapWindow()
+	})
+	memoryUsageCard := widget.NewCard("内存使用情况", "内存和交换的实时使用情况。",
+		container.NewVBox(app.MemoryUsageText, processSwapButton))
+	app.startMemoryUsageMonitor()
+
+	app.SwapWriteText = widget.NewLabel("交换写入量: 未知")
+	swapWriteCard := widget.NewCard("交换写入量", "估算交换对 SSD 的写入磨损。", app.SwapWriteText)
+	app.startSwapWriteMonitor()
+
 	// Swap info gathering
 	app.refreshSwapContent()
 	app.refreshSwappinessContent()
@@ -154,9 +183,12 @@ func (app *Config) swapTab() *fyne.Container {
 	swapVBox := container.NewVBox(
 		swapCard,
 		swappinessCard,
+		memoryUsageCard,
+		swapWriteCard,
 	)
+	scroll := container.NewScroll(swapVBox)
 
-	full := container.NewBorder(topBar, nil, nil, nil, swapVBox)
+	full := container.NewBorder(topBar, nil, nil, nil, scroll)
 
 	return full
 }
@@ -188,9 +220,70 @@ func (app *Config) storageTab() *fyne.Container {
 	cleanStaleData := widget.NewCard("删除游戏数据", "删除选定游戏的前缀和着色器。",
 		cleanupDataButton)
 
+	// Queue settings for one device at a time, picked from /sys/block.
+	blockDeviceText := widget.NewLabel("选择一个设备")
+	schedulerSelect := widget.NewSelect(nil, nil)
+	readAheadSelect := widget.NewSelect(AvailableReadAheadKB, nil)
+	nrRequestsSelect := widget.NewSelect(AvailableNrRequests, nil)
+	var blockDeviceNames []string
+	if devices, err := getBlockDevices(SysRoot); err == nil {
+		for _, device := range devices {
+			blockDeviceNames = append(blockDeviceNames, device.Name)
+		}
+	}
+	blockDeviceSelect := widget.NewSelect(blockDeviceNames, nil)
+	showBlockDevice := func(name string) {
+		device, err := getBlockDevice(SysRoot, name)
+		if err != nil {
+			blockDeviceText.SetText(err.Error())
+			return
+		}
+		blockDeviceText.SetText(device.String())
+		schedulerSelect.Options = device.Schedulers
+		schedulerSelect.Selected = device.Scheduler
+		schedulerSelect.Refresh()
+		readAheadSelect.Selected = device.ReadAheadKB
+		readAheadSelect.Refresh()
+		nrRequestsSelect.Selected = device.NrRequests
+		nrRequestsSelect.Refresh()
+	}
+	blockDeviceSelect.OnChanged = showBlockDevice
+	blockDeviceApplyButton := widget.NewButton("应用", func() {
+		if blockDeviceSelect.Selected == "" {
+			return
+		}
+		renewSudoAuth()
+		err := SetBlockDeviceQueue(blockDeviceSelect.Selected, BlockDeviceSettings{
+			Scheduler:   schedulerSelect.Selected,
+			ReadAheadKB: readAheadSelect.Selected,
+			NrRequests:  nrRequestsSelect.Selected,
+		})
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		}
+		showBlockDevice(blockDeviceSelect.Selected)
+	})
+	blockDeviceResetButton := widget.NewButton("重置", func() {
+		if blockDeviceSelect.Selected == "" {
+			return
+		}
+		renewSudoAuth()
+		err := ResetBlockDevice(blockDeviceSelect.Selected)
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		}
+		showBlockDevice(blockDeviceSelect.Selected)
+	})
+	blockDeviceCard := widget.NewCard("存储队列", "每个设备的 I/O 调度器、预读大小 (kB) 和队列深度。"+
+		"设置按设备型号和序列号保存为 udev 规则，更换 microSD 卡后仍然有效。",
+		container.NewVBox(blockDeviceSelect, blockDeviceText,
+			container.NewGridWithColumns(3, schedulerSelect, readAheadSelect, nrRequestsSelect),
+			container.NewGridWithColumns(2, blockDeviceApplyButton, blockDeviceResetButton)))
+
 	gameDataVBox := container.NewVBox(
 		syncData,
 		cleanStaleData,
+		blockDeviceCard,
 	)
 	app.GameDataContainer = gameDataVBox
 
@@ -206,6 +299,10 @@ func (app *Config) memoryTab() *fyne.Container {
 	app.PageLockUnfairnessText = canvas.NewText("页面锁不公平", Red)
 
 	CryoUtils.HugePagesButton = widget.NewButton("启用大页面", func() {
+		if app.SafeApplyEnabled {
+			app.tryToggleFromUI("hugepages", app.refreshHugePagesContent)
+			return
+		}
 		renewSudoAuth()
 		err := ToggleHugePages()
 		if err != nil {
@@ -215,6 +312,10 @@ func (app *Config) memoryTab() *fyne.Container {
 	})
 
 	CryoUtils.ShMemButton = widget.NewButton("在 THP 中启用共享内存", func() {
+		if app.SafeApplyEnabled {
+			app.tryToggleFromUI("shmem_enabled", app.refreshShMemContent)
+			return
+		}
 		renewSudoAuth()
 		err := ToggleShMem()
 		if err != nil {
@@ -224,6 +325,10 @@ func (app *Config) memoryTab() *fyne.Container {
 	})
 
 	CryoUtils.CompactionProactivenessButton = widget.NewButton("设置压缩主动性", func() {
+		if app.SafeApplyEnabled {
+			app.tryToggleFromUI("compaction_proactiveness", app.refreshCompactionProactivenessContent)
+			return
+		}
 		renewSudoAuth()
 		err := ToggleCompactionProactiveness()
 		if err != nil {
@@ -233,6 +338,10 @@ func (app *Config) memoryTab() *fyne.Container {
 	})
 
 	CryoUtils.DefragButton = widget.NewButton("禁用大页面碎片整理", func() {
+		if app.SafeApplyEnabled {
+			app.tryToggleFromUI("defrag", app.refreshDefragContent)
+			return
+		}
 		renewSudoAuth()
 		err := ToggleDefrag()
 		if err != nil {
@@ -242,6 +351,10 @@ func (app *Config) memoryTab() *fyne.Container {
 	})
 
 	CryoUtils.PageLockUnfairnessButton = widget.NewButton("设置页面锁定不公平", func() {
+		if app.SafeApplyEnabled {
+			app.tryToggleFromUI("page_lock_unfairness", app.refreshPageLockUnfairnessContent)
+			return
+		}
 		renewSudoAuth()
 		err := TogglePageLockUnfairness()
 		if err != nil {
@@ -250,11 +363,100 @@ func (app *Config) memoryTab() *fyne.Container {
 		app.refreshPageLockUnfairnessContent()
 	})
 
+	app.SafeApplyMinutes = DefaultSafeApplyMinutes
+	safeApplyMinutes := widget.NewSelect(AvailableSafeApplyMinutes, func(s string) {
+		app.SafeApplyMinutes, _ = strconv.Atoi(s)
+	})
+	safeApplyMinutes.SetSelected(strconv.Itoa(DefaultSafeApplyMinutes))
+	safeApplyCheck := widget.NewCheck("先试用，未确认时自动恢复", func(b bool) {
+		app.SafeApplyEnabled = b
+	})
+	safeApplyCheck.SetChecked(app.SafeApplyEnabled)
+
+	app.ThpStatsText = widget.NewLabel("开始记录后玩一段游戏，然后查看结果。")
+	thpStartButton := widget.NewButton("开始记录", func() {
+		err := StartThpStats()
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+			return
+		}
+		app.ThpStatsText.SetText("记录中，玩一段游戏后查看结果。")
+	})
+	thpShowButton := widget.NewButton("查看结果", func() {
+		report, err := GetThpStatsSinceStart()
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+			return
+		}
+		app.ThpStatsText.SetText(report.String())
+	})
+
+	app.MemoryActionText = widget.NewLabel("可用大页面块 (2MB): 未知")
+	if blocks, err := getFreeHugePageBlocks(ProcRoot); err == nil {
+		app.MemoryActionText.SetText(fmt.Sprintf("可用大页面块 (2MB): %d", blocks))
+	}
+	compactButton := widget.NewButton("立即压缩内存", func() {
+		app.runMemoryActionFromUI("正在压缩内存...", CompactMemory)
+	})
+	dropCachesButton := widget.NewButton("清除页面缓存", func() {
+		app.runMemoryActionFromUI("正在清除页面缓存...", DropCaches)
+	})
+
+	app.KSMText = widget.NewLabel("KSM: 未知")
+	app.KSMButton = widget.NewButton("启用 KSM", func() {
+		if app.SafeApplyEnabled {
+			target := "1"
+			if getKSMStatus() {
+				target = DefaultKSMRun
+			}
+			app.tryProfileFromUI(TunableProfile{"ksm_run": target}, app.refreshKSMContent)
+			return
+		}
+		renewSudoAuth()
+		err := ToggleKSM()
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		}
+		app.refreshKSMContent()
+	})
+	ksmPagesToScan := widget.NewSelect(AvailableKSMPagesToScan, nil)
+	ksmSleepMillisecs := widget.NewSelect(AvailableKSMSleepMillisecs, nil)
+	if stats, err := getKSMStats(SysRoot); err == nil {
+		ksmPagesToScan.Selected = stats.PagesToScan
+		ksmSleepMillisecs.Selected = stats.SleepMillisecs
+	}
+	// Set the callbacks after the current values, so showing them doesn't write them.
+	ksmPagesToScan.OnChanged = func(s string) {
+		renewSudoAuth()
+		err := SetKSMTuning(s, "")
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		}
+		app.refreshKSMContent()
+	}
+	ksmSleepMillisecs.OnChanged = func(s string) {
+		renewSudoAuth()
+		err := SetKSMTuning("", s)
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		}
+		app.refreshKSMContent()
+	}
+	ksmRefreshButton := widget.NewButton("刷新", func() {
+		app.refreshKSMContent()
+	})
+
+	app.PressureText = widget.NewLabel("压力数据: 未知")
+	app.PressureGraph = newRollingGraph(PressureGraphSamples, 0, Green, Red)
+
 	app.refreshHugePagesContent()
 	app.refreshCompactionProactivenessContent()
 	app.refreshShMemContent()
 	app.refreshDefragContent()
 	app.refreshPageLockUnfairnessContent()
+	app.refreshKSMContent()
+	_ = app.refreshPressureContent()
+	app.startPressureMonitor()
 
 	app.MemoryBar = container.NewGridWithColumns(5,
 		container.NewCenter(app.HugePagesText),
@@ -274,12 +476,76 @@ func (app *Config) memoryTab() *fyne.Container {
 	defragCard := widget.NewCard("大页面碎片整理", "切换大页面碎片整理", app.DefragButton)
 	pageLockUnfairnessCard := widget.NewCard("页面锁不公平", "设置页面锁定不公平", app.PageLockUnfairnessButton)
 
+	safeApplyCard := widget.NewCard("安全试用", "更改立即生效，确认后才会保存（试用分钟数）",
+		container.NewGridWithColumns(2, safeApplyCheck, safeApplyMinutes))
+
+	pressureCard := widget.NewCard("内存压力 (PSI)", "10秒 / 60秒 / 300秒 内的停顿时间比例，图表: 内存 some (绿) 和 full (红)",
+		container.NewVBox(app.PressureText, app.PressureGraph.CanvasObject()))
+
+	thpStatsCard := widget.NewCard("大页面和压缩效果", "比较一段时间内的 THP 和压缩计数器，判断当前设置是否有帮助。",
+		container.NewVBox(app.ThpStatsText, container.NewGridWithColumns(2, thpStartButton, thpShowButton)))
+
+	memoryActionCard := widget.NewCard("内存整理", "主动压缩为 0 时，长时间游戏后内存碎片会增多，可以在启动大型游戏前整理。",
+		container.NewVBox(app.MemoryActionText, container.NewGridWithColumns(2, compactButton, dropCachesButton)))
+
+	ksmCard := widget.NewCard("相同页面合并 (KSM)", "合并内容相同的内存页面，例如多个 Proton 进程加载的相同 DLL（每次扫描页面 / 扫描间隔毫秒）",
+		container.NewVBox(app.KSMText,
+			container.NewGridWithColumns(2, app.KSMButton, ksmRefreshButton),
+			container.NewGridWithColumns(2, ksmPagesToScan, ksmSleepMillisecs)))
+
+	cgroupCheck := widget.NewCheck("保护游戏内存，限制 Steam 后台进程", nil)
+	if settings, err := loadSettings(); err == nil {
+		cgroupCheck.Checked = settings.Cgroup.Enabled
+	}
+	cgroupCheck.OnChanged = func(b bool) {
+		settings, err := loadSettings()
+		if err == nil {
+			settings.Cgroup.Enabled = b
+			err = saveSettings(settings)
+		}
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		}
+	}
+	cgroupCard := widget.NewCard("游戏内存保护 (cgroup)", "游戏运行时由监视守护进程 (watch) 将其移入受 memory.low 保护的 cgroup，"+
+		"并为 Steam 后台进程设置 memory.high。大小可用 'cgroup' 命令调整。", cgroupCheck)
+
+	shaderCheck := widget.NewCheck("游戏运行时降低着色器编译的优先级", nil)
+	if settings, err := loadSettings(); err == nil {
+		shaderCheck.Checked = settings.DeprioritizeShaders
+	}
+	shaderCheck.OnChanged = func(b bool) {
+		settings, err := loadSettings()
+		if err == nil {
+			settings.DeprioritizeShaders = b
+			err = saveSettings(settings)
+		}
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		}
+	}
+	shaderCard := widget.NewCard("着色器编译 (fossilize_replay)", "游戏运行时由监视守护进程 (watch) 降低 Steam 后台着色器编译的 CPU 和 I/O 优先级，"+
+		"并限制其使用的 CPU，游戏退出后恢复。", shaderCheck)
+
+	benchButton := widget.NewButton("对比配置文件", func() {
+		benchCompareWindow()
+	})
+	benchCard := widget.NewCard("内存基准测试", "在两个配置文件下运行内存延迟测试，用数据比较设置。", benchButton)
+
 	memoryVBox := container.NewVBox(
+		safeApplyCard,
+		pressureCard,
+		thpStatsCard,
+		memoryActionCard,
+		cgroupCard,
+		shaderCard,
+		benchCard,
 		hugePagesCard,
 		shMemCard,
 		compactionProactivenessCard,
 		defragCard,
 		pageLockUnfairnessCard,
+		ksmCard,
 	)
 	scroll := container.NewScroll(memoryVBox)
 	full := container.NewBorder(topBar, nil, nil, nil, scroll)
@@ -287,6 +553,125 @@ func (app *Config) memoryTab() *fyne.Container {
 	return full
 }
 
+// CPU tab to change the frequency governor, energy preference, frequency range and SMT.
+func (app *Config) cpuTab() *fyne.Container {
+	app.CPUText = widget.NewLabel("CPU: 未知")
+	app.refreshCPUContent()
+
+	// Apply a value to every policy, the way the CLI does.
+	setAll := func(setting string, value string) {
+		renewSudoAuth()
+		profile, err := getAllPoliciesProfile(SysRoot, setting, value)
+		if err == nil {
+			err = SetCPUProfile(profile)
+		}
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		}
+		app.refreshCPUContent()
+	}
+
+	policies, _ := getCPUPolicies(SysRoot)
+	var policy CPUPolicy
+	if len(policies) > 0 {
+		policy = policies[0]
+	}
+
+	governorSelect := widget.NewSelect(policy.Governors, nil)
+	governorSelect.Selected = policy.Governor
+	preferenceSelect := widget.NewSelect(policy.Preferences, nil)
+	preferenceSelect.Selected = policy.Preference
+	// Set the callbacks after the current values, so showing them doesn't write them.
+	governorSelect.OnChanged = func(s string) {
+		setAll("governor", s)
+	}
+	preferenceSelect.OnChanged = func(s string) {
+		setAll("preference", s)
+	}
+	if len(policy.Governors) == 0 {
+		governorSelect.Disable()
+	}
+	if len(policy.Preferences) == 0 {
+		preferenceSelect.Disable()
+	}
+
+	minFreqEntry := widget.NewEntry()
+	minFreqEntry.SetPlaceHolder(fmt.Sprintf("最低 MHz (%d)", policy.HardwareMin/1000))
+	maxFreqEntry := widget.NewEntry()
+	maxFreqEntry.SetPlaceHolder(fmt.Sprintf("最高 MHz (%d)", policy.HardwareMax/1000))
+	freqButton := widget.NewButton("应用频率", func() {
+		for _, field := range []struct {
+			setting string
+			entry   *widget.Entry
+		}{{"max_freq", maxFreqEntry}, {"min_freq", minFreqEntry}} {
+			if field.entry.Text == "" {
+				continue
+			}
+			mhz, err := strconv.Atoi(field.entry.Text)
+			if err != nil {
+				presentErrorInUI(fmt.Errorf("无效的频率: %s", field.entry.Text), CryoUtils.MainWindow)
+				return
+			}
+			setAll(field.setting, strconv.Itoa(mhz*1000))
+		}
+	})
+
+	smtState := getSMTState(SysRoot)
+	smtCheck := widget.NewCheck("启用 SMT (超线程)", nil)
+	smtCheck.Checked = smtState == "on"
+	smtCheck.OnChanged = func(b bool) {
+		renewSudoAuth()
+		value := "off"
+		if b {
+			value = "on"
+		}
+		err := SetCPUProfile(TunableProfile{"smt": value})
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		}
+		app.refreshCPUContent()
+	}
+	if smtState == "" {
+		smtCheck.Disable()
+	}
+
+	recommendedButton := widget.NewButton("推荐设置", func() {
+		renewSudoAuth()
+		err := SetCPUProfile(getRecommendedCPUProfile())
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		}
+		app.refreshCPUContent()
+	})
+	stockButton := widget.NewButton("默认设置", func() {
+		renewSudoAuth()
+		err := RevertCPU()
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		}
+		app.refreshCPUContent()
+	})
+
+	statusCard := widget.NewCard("当前状态", "每个 cpufreq 策略的设置。", app.CPUText)
+	governorCard := widget.NewCard("调速器和能耗偏好", "应用到所有策略。能耗偏好只在驱动支持时可用 (amd-pstate-epp)。",
+		container.NewGridWithColumns(2, governorSelect, preferenceSelect))
+	freqCard := widget.NewCard("频率范围", "限制最低和最高频率，留空则不变。",
+		container.NewVBox(container.NewGridWithColumns(2, minFreqEntry, maxFreqEntry), freqButton))
+	smtCard := widget.NewCard("SMT", "关闭后每个核心只运行一个线程。", smtCheck)
+	profileCard := widget.NewCard("推荐和默认", "推荐设置使用完整频率范围和性能优先的能耗偏好。",
+		container.NewGridWithColumns(2, recommendedButton, stockButton))
+
+	cpuVBox := container.NewVBox(
+		statusCard,
+		profileCard,
+		governorCard,
+		freqCard,
+		smtCard,
+	)
+	scroll := container.NewScroll(cpuVBox)
+	return container.NewBorder(nil, nil, nil, nil, scroll)
+}
+
 func (app *Config) vramTab() *fyne.Container {
 	app.VRAMText = canvas.NewText("当前显存大小: 未知", Gray)
 
@@ -17,13 +17,19 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"sync"
+	"time"
 	
 	"os"
 )
@@ -40,8 +46,145 @@ type GameStatus struct {
 
 // Show an error message over the main window.
 func presentErrorInUI(err error, win fyne.Window) {
+	// Cancelling is the user's choice, not something that went wrong.
+	if errors.Is(err, context.Canceled) {
+		CryoUtils.InfoLog.Println(err)
+		dialog.ShowInformation("已取消", err.Error(), win)
+		return
+	}
 	CryoUtils.ErrorLog.Println(err)
-	dialog.ShowError(err, win)
+	details := ErrorDetails(err)
+	if details == "" {
+		dialog.ShowError(err, win)
+		return
+	}
+	CryoUtils.Log.Info("命令失败的详细信息", "details", details)
+
+	// What the command printed is folded away, with a button to copy it all for a bug report.
+	message := widget.NewLabel(err.Error())
+	message.Wrapping = fyne.TextWrapWord
+	detailsText := widget.NewLabel(details)
+	detailsText.TextStyle = fyne.TextStyle{Monospace: true}
+	detailsScroll := container.NewScroll(detailsText)
+	detailsScroll.SetMinSize(fyne.NewSize(450, 150))
+	var copyButton *widget.Button
+	copyButton = widget.NewButton("复制到剪贴板", func() {
+		win.Clipboard().SetContent(err.Error() + "\n\n" + details)
+		copyButton.SetText("已复制")
+	})
+	detailsItem := widget.NewAccordionItem("详细信息", container.NewBorder(nil, copyButton, nil, nil, detailsScroll))
+	d := dialog.NewCustom("错误", "确定", container.NewVBox(message, widget.NewAccordion(detailsItem)), win)
+	d.Resize(fyne.NewSize(500, 200))
+	d.Show()
+}
+
+// Show a progress popup with a cancel button over a window. The context is cancelled when the button is
+// pressed, and done hides the popup. The work must run in its own goroutine, or the button can't be pressed.
+func showCancellableProgress(window fyne.Window, objects ...fyne.CanvasObject) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var cancelButton *widget.Button
+	cancelButton = widget.NewButton("取消", func() {
+		CryoUtils.InfoLog.Println("已请求取消，等待当前步骤完成")
+		cancelButton.SetText("正在取消，等待当前步骤完成...")
+		cancelButton.Disable()
+		cancel()
+	})
+	modal := widget.NewModalPopUp(container.NewVBox(append(objects, cancelButton)...), window.Canvas())
+	modal.Show()
+	return ctx, func() {
+		cancel()
+		modal.Hide()
+	}
+}
+
+// Flip a tunable between its recommended and stock value as a trial, rather than persisting it straight away.
+func (app *Config) tryToggleFromUI(param string, refresh func()) {
+	renewSudoAuth()
+	status, err := getUnitStatus(param)
+	if err != nil {
+		presentErrorInUI(err, app.MainWindow)
+		return
+	}
+	target := getRecommendedProfile()[param]
+	if status == target {
+		target = getStockProfile()[param]
+	}
+	app.tryProfileFromUI(TunableProfile{param: target}, refresh)
+}
+
+// Apply a profile as a trial and ask the user whether to keep it.
+func (app *Config) tryProfileFromUI(p TunableProfile, refresh func()) {
+	minutes := app.SafeApplyMinutes
+	if minutes <= 0 {
+		minutes = DefaultSafeApplyMinutes
+	}
+	s, err := StartSafeApply(p, time.Duration(minutes)*time.Minute, true)
+	if err != nil {
+		presentErrorInUI(err, app.MainWindow)
+		return
+	}
+	refresh()
+	app.showSafeApplyDialog(s, refresh)
+}
+
+// Show a countdown asking to keep a trial change, like a display mode confirmation.
+func (app *Config) showSafeApplyDialog(s *SafeApply, refresh func()) {
+	countdown := widget.NewLabel("")
+	updateCountdown := func() {
+		remaining := time.Until(s.Deadline()).Round(time.Second)
+		if remaining < 0 {
+			remaining = 0
+		}
+		countdown.SetText(fmt.Sprintf("如果不确认，将在 %s 后自动恢复之前的设置。", remaining))
+	}
+	updateCountdown()
+
+	var once sync.Once
+	answered := make(chan struct{})
+	d := dialog.NewCustomConfirm("保留这些设置？", "保留", "恢复",
+		container.NewVBox(widget.NewLabel("新设置已生效，但尚未保存。"), countdown),
+		func(keep bool) {
+			once.Do(func() { close(answered) })
+			var err error
+			if keep {
+				err = s.Confirm()
+			} else {
+				err = s.Revert()
+			}
+			if err != nil {
+				presentErrorInUI(err, app.MainWindow)
+			}
+			refresh()
+		}, app.MainWindow)
+	d.Show()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-answered:
+				return
+			case <-s.Done():
+				select {
+				case <-answered:
+					return
+				default:
+				}
+				// The timer reverted the change, so close the prompt and say why.
+				d.Hide()
+				refresh()
+				if err := s.Err(); err != nil {
+					presentErrorInUI(err, app.MainWindow)
+					return
+				}
+				dialog.ShowInformation("设置已恢复", "试用时间已到，之前的设置已恢复。", app.MainWindow)
+				return
+			case <-ticker.C:
+				updateCountdown()
+			}
+		}
+	}()
 }
 
 // Create a CheckGroup of game data to allow for selection.
@@ -263,6 +406,32 @@ func (app *Config) refreshHugePagesContent() {
 	app.HugePagesText.Refresh()
 }
 
+func (app *Config) refreshKSMContent() {
+	app.InfoLog.Println("正在刷新 KSM 数据...")
+	stats, err := getKSMStats(SysRoot)
+	if err != nil {
+		app.KSMText.SetText(err.Error())
+		app.KSMButton.Disable()
+		return
+	}
+	if stats.Run == "1" {
+		app.KSMButton.SetText("禁用 KSM")
+	} else {
+		app.KSMButton.SetText("启用 KSM")
+	}
+	app.KSMText.SetText(stats.String())
+}
+
+func (app *Config) refreshCPUContent() {
+	app.InfoLog.Println("正在刷新 CPU 调频数据...")
+	status, err := getCPUStatus(SysRoot)
+	if err != nil {
+		app.CPUText.SetText(err.Error())
+		return
+	}
+	app.CPUText.SetText(status)
+}
+
 func (app *Config) refreshShMemContent() {
 	app.InfoLog.Println("正在刷新共享内存 shmem 数据...")
 	if getShMemStatus() {
@@ -338,6 +507,122 @@ func (app *Config) refreshVRAMContent() {
 	app.VRAMText.Refresh()
 }
 
+func (app *Config) refreshPressureContent() error {
+	snapshot, err := getPressureSnapshot(ProcRoot)
+	if err != nil {
+		app.PressureText.SetText("压力数据: 未知")
+		return err
+	}
+	app.PressureText.SetText("内存: " + snapshot.Memory.String() + "\n" +
+		"IO: " + snapshot.IO.String() + "\n" +
+		"CPU: " + snapshot.CPU.String())
+	app.PressureGraph.Add(snapshot.Memory.Some.Avg10, snapshot.Memory.Full.Avg10)
+	return nil
+}
+
+// Keep the pressure panel up to date in the background, without blocking the UI.
+func (app *Config) startPressureMonitor() {
+	go func() {
+		ticker := time.NewTicker(PressureRefreshInterval)
+		defer ticker.Stop()
+		// A kernel without PSI will never have it, anything else may clear up on the next read.
+		if !doesFileExist(filepath.Join(ProcRoot, "pressure")) {
+			CryoUtils.ErrorLog.Println("内核未启用 PSI，停止刷新压力数据")
+			return
+		}
+		var lastErr string
+		for range ticker.C {
+			err := app.refreshPressureContent()
+			switch {
+			case err != nil && err.Error() != lastErr:
+				// Once per failure, not on every refresh.
+				CryoUtils.ErrorLog.Println(err)
+				lastErr = err.Error()
+			case err == nil && lastErr != "":
+				CryoUtils.InfoLog.Println("压力数据已恢复")
+				lastErr = ""
+			}
+		}
+	}()
+}
+
+// Keep the memory usage breakdown up to date in the background, without blocking the UI.
+func (app *Config) startMemoryUsageMonitor() {
+	sampler := newMemoryUsageSampler(ProcRoot)
+	refresh := func() error {
+		usage, err := sampler.sample()
+		if err != nil {
+			app.MemoryUsageText.SetText("内存使用: 未知")
+			return err
+		}
+		app.MemoryUsageText.SetText(usage.String())
+		return nil
+	}
+
+	go func() {
+		err := refresh()
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+			return
+		}
+		ticker := time.NewTicker(MemoryUsageRefreshInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			err = refresh()
+			if err != nil {
+				CryoUtils.ErrorLog.Println(err)
+				return
+			}
+		}
+	}()
+}
+
+// Record swap writes while the UI is open, and show the estimate after every sample.
+func (app *Config) startSwapWriteMonitor() {
+	refresh := func() {
+		report, err := GetSwapWriteReport()
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+			app.SwapWriteText.SetText("交换写入量: 未知")
+			return
+		}
+		app.SwapWriteText.SetText(report.String())
+	}
+
+	go func() {
+		ticker := time.NewTicker(SwapWriteSampleInterval)
+		defer ticker.Stop()
+		for {
+			err := RecordSwapWriteSample()
+			if err != nil {
+				CryoUtils.ErrorLog.Println(err)
+			}
+			refresh()
+			<-ticker.C
+		}
+	}()
+}
+
+// Run a compaction or cache drop behind a progress popup, and show what it achieved.
+func (app *Config) runMemoryActionFromUI(message string, action func() (MemoryActionResult, error)) {
+	progressText := canvas.NewText(message, White)
+	progressBar := widget.NewProgressBarInfinite()
+	progressGroup := container.NewVBox(progressText, progressBar)
+	modal := widget.NewModalPopUp(progressGroup, app.MainWindow.Canvas())
+	modal.Show()
+	// Compaction and sync can take a while, run them off the UI thread so the modal gets painted.
+	go func() {
+		renewSudoAuth()
+		result, err := action()
+		modal.Hide()
+		if err != nil {
+			presentErrorInUI(err, app.MainWindow)
+			return
+		}
+		app.MemoryActionText.SetText(result.String())
+	}()
+}
+
 func (app *Config) refreshAllContent() {
 	app.refreshSwapContent()
 	app.refreshSwappinessContent()
@@ -346,5 +631,7 @@ func (app *Config) refreshAllContent() {
 	app.refreshShMemContent()
 	app.refreshDefragContent()
 	app.refreshPageLockUnfairnessContent()
+	app.refreshKSMContent()
+	app.refreshCPUContent()
 	app.refreshVRAMContent()
 }
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
@@ -101,24 +102,34 @@ func syncGameDataWindow() {
 }
 
 func populateGameDataWindow(w fyne.Window, left string, right string) {
-	var leftCard, rightCard *widget.Card
-	var syncDataButton *widget.Button
 	var data DataToMove
 
 	p := widget.NewProgressBarInfinite()
-	d := dialog.NewCustom("寻找要移动的数据...", "解除", p, w)
-	d.Show()
+	ctx, done := showCancellableProgress(w, canvas.NewText("寻找要移动的数据...", nil), p)
+	go func() {
+		// Get a list of data to move
+		err := data.getDataToMove(left, right)
+		if err != nil {
+			CryoUtils.ErrorLog.Println(err)
+			presentErrorInUI(err, w)
+		}
 
-	// Get a list of data to move
-	err := data.getDataToMove(left, right)
-	if err != nil {
-		CryoUtils.ErrorLog.Println(err)
-		d.Hide()
-		presentErrorInUI(err, w)
-	}
+		// Get the storage totals necessary for each side, walking every directory can take a while
+		err = data.getSpaceNeeded(ctx, left, right)
+		done()
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+			w.Close()
+			return
+		}
+		showGameDataToMove(w, data, left, right)
+	}()
+}
 
-	// Get the storage totals necessary for each side
-	data.getSpaceNeeded(left, right)
+// Show what will move in each direction, with a button to start the move.
+func showGameDataToMove(w fyne.Window, data DataToMove, left string, right string) {
+	var leftCard, rightCard *widget.Card
+	var syncDataButton *widget.Button
 
 	leftSpaceAvailable, err := getFreeSpace(left)
 	if err != nil {
@@ -197,25 +208,27 @@ func populateGameDataWindow(w fyne.Window, left string, right string) {
 			progress := widget.NewProgressBar()
 			CryoUtils.MoveDataProgressBar = progress
 			progress.Resize(fyne.NewSize(500, 50))
-			tempVBox := container.NewVBox(canvas.NewText("正在同步，请稍候...", nil), progress)
-			widget.ShowModalPopUp(tempVBox, w.Canvas())
-			err = moveGameData(data, left, right)
-			if err != nil {
-				presentErrorInUI(err, w)
-			} else {
-				_, err := data.confirmDirectoryStatus(left, right)
+			ctx, done := showCancellableProgress(w, canvas.NewText("正在同步，请稍候...", nil), progress)
+			go func() {
+				err := moveGameData(ctx, data, left, right)
+				done()
 				if err != nil {
 					presentErrorInUI(err, w)
 				} else {
-					CryoUtils.InfoLog.Println("所有数据移动正常，复制成功！")
-					dialog.ShowInformation(
-						"成功!",
-						"数据移动完成，所有游戏数据同步到相应设备。",
-						CryoUtils.MainWindow,
-					)
-					w.Close()
+					_, err := data.confirmDirectoryStatus(left, right)
+					if err != nil {
+						presentErrorInUI(err, w)
+					} else {
+						CryoUtils.InfoLog.Println("所有数据移动正常，复制成功！")
+						dialog.ShowInformation(
+							"成功!",
+							"数据移动完成，所有游戏数据同步到相应设备。",
+							CryoUtils.MainWindow,
+						)
+						w.Close()
+					}
 				}
-			}
+			}()
 		})
 	} else {
 		// Otherwise, provide a button to close the window
@@ -227,8 +240,6 @@ func populateGameDataWindow(w fyne.Window, left string, right string) {
 		w.Close()
 	})
 
-	d.Hide()
-
 	// Format the window
 	syncMain := container.NewGridWithColumns(1, leftCard, rightCard)
 	syncButtonBorder := container.NewGridWithColumns(2, cancelButton, syncDataButton)
@@ -282,14 +293,7 @@ func cleanupDataWindow() {
 						presentErrorInUI(err, CryoUtils.MainWindow)
 					}
 
-					removeGameData(removeList, possibleLocations)
-
-					dialog.ShowInformation(
-						"Success!",
-						"Process completed!",
-						CryoUtils.MainWindow,
-					)
-					w.Close()
+					removeGameDataFromUI(w, removeList, possibleLocations)
 				} else {
 					w.Close()
 				}
@@ -303,6 +307,7 @@ func cleanupDataWindow() {
 			func(b bool) {
 				if !b {
 					w.Close()
+					return
 				}
 
 				locations, err := getListOfDataAllDataLocations()
@@ -311,14 +316,7 @@ func cleanupDataWindow() {
 					presentErrorInUI(err, CryoUtils.MainWindow)
 				}
 
-				removeGameData(getUninstalledGamesData(), locations)
-
-				dialog.ShowInformation(
-					"成功!",
-					"操作完成!",
-					CryoUtils.MainWindow,
-				)
-				w.Close()
+				removeGameDataFromUI(w, getUninstalledGamesData(), locations)
 
 			}, w)
 
@@ -364,26 +362,24 @@ func swapSizeWindow() {
 	// Provide a button to submit the choice
 	swapResizeButton := widget.NewButton("调整交换文件大小", func() {
 		progress := widget.NewProgressBarInfinite()
-		d := dialog.NewCustom("正在调整交换文件大小，请耐心等待..."+
-			"(这最多可能需要 30 分钟)", "退出", progress,
-			w,
-		)
-		d.Show()
-		err = changeSwapSizeGUI(chosenSize)
-		if err != nil {
-			d.Hide()
-			presentErrorInUI(err, w)
-		} else {
-			d.Hide()
-			dialog.ShowInformation(
-				"成功!",
-				"操作完成！你可以验证文件是否已调整大小\n"+
-					"在终端中运行 “ls -lash /home/swapfile” 或 “swapon -s”",
-				CryoUtils.MainWindow,
-			)
-			CryoUtils.refreshSwapContent()
-			w.Close()
-		}
+		ctx, done := showCancellableProgress(w, canvas.NewText("正在调整交换文件大小，请耐心等待..."+
+			"(这最多可能需要 30 分钟)", nil), progress)
+		go func() {
+			err := ChangeSwapSizeCLI(ctx, chosenSize, true)
+			done()
+			if err != nil {
+				presentErrorInUI(err, w)
+			} else {
+				dialog.ShowInformation(
+					"成功!",
+					"操作完成！你可以验证文件是否已调整大小\n"+
+						"在终端中运行 “ls -lash /home/swapfile” 或 “swapon -s”",
+					CryoUtils.MainWindow,
+				)
+				CryoUtils.refreshSwapContent()
+				w.Close()
+			}
+		}()
 	})
 
 	// Make a progress bar and hide it
@@ -401,33 +397,23 @@ func swapSizeWindow() {
 }
 
 // Note: Having a separate function for this is hacky, but necessary for progress bar functionality
-func changeSwapSizeGUI(size int) error {
-	// Disable swap temporarily
-	renewSudoAuth()
-	CryoUtils.InfoLog.Println("暂时禁用交换...")
-	err := disableSwap()
-	if err != nil {
-		return err
-	}
-	// Resize the file
-	renewSudoAuth()
-	err = resizeSwapFile(size)
-	if err != nil {
-		return err
-	}
-	// Set permissions on file
-	renewSudoAuth()
-	err = setSwapPermissions()
-	if err != nil {
-		return err
-	}
-	// Initialize new swap file
-	renewSudoAuth()
-	err = initNewSwapFile()
-	if err != nil {
-		return err
-	}
-	return nil
+// Remove game data behind a progress popup that can stop it between games, then close the window.
+func removeGameDataFromUI(w fyne.Window, removeList []string, locations []string) {
+	ctx, done := showCancellableProgress(w, canvas.NewText("正在删除游戏数据...", nil), widget.NewProgressBarInfinite())
+	go func() {
+		err := removeGameData(ctx, removeList, locations)
+		done()
+		if err != nil {
+			presentErrorInUI(err, CryoUtils.MainWindow)
+		} else {
+			dialog.ShowInformation(
+				"成功!",
+				"操作完成!",
+				CryoUtils.MainWindow,
+			)
+		}
+		w.Close()
+	}()
 }
 
 func swappinessWindow() {
@@ -446,6 +432,11 @@ func swappinessWindow() {
 
 	// Provide a button to submit the choice
 	swappinessChangeButton := widget.NewButton("改变交换性", func() {
+		if CryoUtils.SafeApplyEnabled {
+			w.Close()
+			CryoUtils.tryProfileFromUI(TunableProfile{"swappiness": chosenSwappiness}, CryoUtils.refreshSwappinessContent)
+			return
+		}
 		renewSudoAuth()
 		err := ChangeSwappiness(chosenSwappiness)
 		if err != nil {
@@ -468,3 +459,273 @@ func swappinessWindow() {
 	w.RequestFocus()
 	w.Show()
 }
+
+// Show which processes own the memory in swap, grouped by game where possible.
+func processSwapWindow() {
+	w := CryoUtils.App.NewWindow("进程交换使用情况")
+
+	type row struct {
+		cells [5]string
+	}
+	var rows []row
+	load := func() {
+		rows = nil
+		processes, err := getProcessMemoryList(ProcRoot)
+		if err != nil {
+			presentErrorInUI(err, w)
+			return
+		}
+		for _, group := range groupProcessMemory(processes) {
+			rows = append(rows, row{[5]string{group.displayName(CryoUtils.SteamAPIResponse), "",
+				getHumanMemorySize(group.Swap), getHumanMemorySize(group.RSS), getHumanMemorySize(group.RssAnon)}})
+			for _, process := range group.Processes {
+				if process.Swap == 0 && len(group.Processes) > 1 {
+					continue
+				}
+				rows = append(rows, row{[5]string{"    " + process.Name, strconv.Itoa(process.PID),
+					getHumanMemorySize(process.Swap), getHumanMemorySize(process.RSS), getHumanMemorySize(process.RssAnon)}})
+			}
+		}
+	}
+	load()
+
+	headers := [5]string{"分组 / 进程", "PID", "交换", "RSS", "匿名 RSS"}
+	table := widget.NewTable(
+		func() (int, int) {
+			return len(rows) + 1, len(headers)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("")
+		},
+		func(id widget.TableCellID, o fyne.CanvasObject) {
+			label := o.(*widget.Label)
+			if id.Row == 0 {
+				label.TextStyle = fyne.TextStyle{Bold: true}
+				label.SetText(headers[id.Col])
+				return
+			}
+			label.TextStyle = fyne.TextStyle{}
+			label.SetText(rows[id.Row-1].cells[id.Col])
+		})
+	table.SetColumnWidth(0, 320)
+	for col := 1; col < len(headers); col++ {
+		table.SetColumnWidth(col, 90)
+	}
+
+	refreshButton := widget.NewButton("刷新", func() {
+		load()
+		table.Refresh()
+	})
+	closeButton := widget.NewButton("关闭", func() {
+		w.Close()
+	})
+
+	buttons := container.NewGridWithColumns(2, closeButton, refreshButton)
+	w.SetContent(container.NewBorder(nil, buttons, nil, nil, table))
+	w.Resize(fyne.NewSize(700, 450))
+	w.CenterOnScreen()
+	w.RequestFocus()
+	w.Show()
+}
+
+func benchCompareWindow() {
+	w := CryoUtils.App.NewWindow("内存基准测试对比")
+
+	settings, err := loadSettings()
+	if err != nil {
+		presentErrorInUI(err, CryoUtils.MainWindow)
+		return
+	}
+	currentSettings := "当前设置"
+	profiles := append([]string{currentSettings}, settings.getProfileNames()...)
+	firstProfile := widget.NewSelect(profiles, nil)
+	firstProfile.SetSelected("stock")
+	secondProfile := widget.NewSelect(profiles, nil)
+	secondProfile.SetSelected("recommended")
+	sizes := append([]string{"自动"}, AvailableBenchWorkingSets...)
+	sizeSelect := widget.NewSelect(sizes, nil)
+	sizeSelect.SetSelected("自动")
+
+	// Written by the benchmark goroutine once it's done, read by the table.
+	var resultsMutex sync.Mutex
+	var reports [2]BenchReport
+	var rows [2][][2]string
+	table := widget.NewTable(
+		func() (int, int) {
+			resultsMutex.Lock()
+			defer resultsMutex.Unlock()
+			return len(rows[0]) + 1, 3
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("")
+		},
+		func(id widget.TableCellID, o fyne.CanvasObject) {
+			label := o.(*widget.Label)
+			resultsMutex.Lock()
+			defer resultsMutex.Unlock()
+			if id.Row == 0 {
+				label.TextStyle = fyne.TextStyle{Bold: true}
+				headers := [3]string{"指标", reports[0].displayName(), reports[1].displayName()}
+				label.SetText(headers[id.Col])
+				return
+			}
+			label.TextStyle = fyne.TextStyle{}
+			if id.Col == 0 {
+				label.SetText(rows[0][id.Row-1][0])
+				return
+			}
+			label.SetText(rows[id.Col-1][id.Row-1][1])
+		})
+	table.SetColumnWidth(0, 160)
+	table.SetColumnWidth(1, 200)
+	table.SetColumnWidth(2, 200)
+
+	runButton := widget.NewButton("运行对比", func() {
+		opts := BenchOptions{}
+		if sizeSelect.Selected != "自动" {
+			size, _ := strconv.Atoi(sizeSelect.Selected)
+			opts.WorkingSet = int64(size) * int64(GigabyteMultiplier)
+		}
+		names := [2]string{firstProfile.Selected, secondProfile.Selected}
+
+		progressText := canvas.NewText("正在运行基准测试，可能需要几分钟...", White)
+		progressBar := widget.NewProgressBarInfinite()
+		ctx, done := showCancellableProgress(w, progressText, progressBar)
+		go func() {
+			defer done()
+			var results [2]BenchReport
+			for i, name := range names {
+				if name == currentSettings {
+					name = ""
+				}
+				progressText.Text = fmt.Sprintf("正在运行基准测试 %d/2: %s", i+1, names[i])
+				progressText.Refresh()
+				// Applying a profile needs root, and the whole run can outlast the cached credentials.
+				renewSudoAuth()
+				opts.Profile = name
+				report, err := RunMemoryBenchmark(ctx, opts)
+				if err != nil {
+					presentErrorInUI(err, w)
+					return
+				}
+				results[i] = report
+			}
+			resultsMutex.Lock()
+			reports = results
+			rows = [2][][2]string{results[0].rows(), results[1].rows()}
+			resultsMutex.Unlock()
+			table.Refresh()
+		}()
+	})
+	closeButton := widget.NewButton("关闭", func() {
+		w.Close()
+	})
+
+	options := widget.NewForm(
+		widget.NewFormItem("配置文件 A", firstProfile),
+		widget.NewFormItem("配置文件 B", secondProfile),
+		widget.NewFormItem("工作集 (GB)", sizeSelect),
+	)
+	buttons := container.NewGridWithColumns(2, closeButton, runButton)
+	w.SetContent(container.NewBorder(options, buttons, nil, nil, table))
+	w.Resize(fyne.NewSize(600, 550))
+	w.CenterOnScreen()
+	w.RequestFocus()
+	w.Show()
+}
+
+func logViewerWindow() {
+	w := CryoUtils.App.NewWindow("日志")
+
+	levelNames := []string{"调试", "信息", "警告", "错误"}
+	level := LevelInfo
+	var files []string
+	var runNames []string
+	file := LogFilePath
+	var lines []LogLine
+	load := func() {
+		var err error
+		lines, err = readLogLines(file, level, 1000)
+		if err != nil {
+			presentErrorInUI(err, w)
+		}
+	}
+
+	list := widget.NewList(
+		func() int {
+			return len(lines)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("")
+		},
+		func(id widget.ListItemID, o fyne.CanvasObject) {
+			label := o.(*widget.Label)
+			label.TextStyle = fyne.TextStyle{Bold: lines[id].Level >= LevelWarn}
+			label.SetText(lines[id].Text)
+		})
+
+	for i, path := range getLogFiles(LogFilePath) {
+		files = append(files, path)
+		if i == 0 {
+			runNames = append(runNames, "本次运行")
+		} else {
+			runNames = append(runNames, fmt.Sprintf("之前第 %d 次运行", i))
+		}
+	}
+	for i, path := range getLogFiles(WatchLogFilePath) {
+		files = append(files, path)
+		if i == 0 {
+			runNames = append(runNames, "监视守护进程")
+		} else {
+			runNames = append(runNames, fmt.Sprintf("监视守护进程，之前第 %d 次运行", i))
+		}
+	}
+	runSelect := widget.NewSelect(runNames, func(value string) {
+		for i, name := range runNames {
+			if name == value {
+				file = files[i]
+			}
+		}
+		load()
+		list.Refresh()
+		list.ScrollToBottom()
+	})
+	levelSelect := widget.NewSelect(levelNames, func(value string) {
+		for i, name := range levelNames {
+			if name == value {
+				level = LogLevel(i)
+			}
+		}
+		load()
+		list.Refresh()
+		list.ScrollToBottom()
+	})
+	if len(runNames) > 0 {
+		runSelect.SetSelected(runNames[0])
+	}
+	levelSelect.SetSelected(levelNames[LevelInfo])
+
+	refreshButton := widget.NewButton("刷新", func() {
+		load()
+		list.Refresh()
+		list.ScrollToBottom()
+	})
+	copyButton := widget.NewButton("复制全部", func() {
+		var b strings.Builder
+		for _, line := range lines {
+			b.WriteString(line.Text + "\n")
+		}
+		w.Clipboard().SetContent(b.String())
+	})
+	closeButton := widget.NewButton("关闭", func() {
+		w.Close()
+	})
+
+	filters := container.NewGridWithColumns(2, runSelect, levelSelect)
+	buttons := container.NewGridWithColumns(3, closeButton, copyButton, refreshButton)
+	w.SetContent(container.NewBorder(filters, buttons, nil, nil, list))
+	w.Resize(fyne.NewSize(900, 500))
+	w.CenterOnScreen()
+	w.RequestFocus()
+	w.Show()
+}
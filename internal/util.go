@@ -17,14 +17,14 @@
 package internal
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"os/exec"
+	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -43,6 +43,7 @@ func init() {
 
 type Config struct {
 	App                           fyne.App
+	Log                           *Logger
 	InfoLog                       *log.Logger
 	ErrorLog                      *log.Logger
 	SwapText                      *canvas.Text
@@ -69,7 +70,21 @@ type Config struct {
 	PageLockUnfairnessButton      *widget.Button
 	VRAMButton                    *widget.Button
 	UserPassword                  string
+	Executor                      Executor
+	Caller                        string
+	LockWait                      time.Duration
 	SwapFileLocation              string
+	SafeApplyEnabled              bool
+	SafeApplyMinutes              int
+	PressureText                  *widget.Label
+	PressureGraph                 *RollingGraph
+	MemoryUsageText               *widget.Label
+	ThpStatsText                  *widget.Label
+	SwapWriteText                 *widget.Label
+	MemoryActionText              *widget.Label
+	KSMText                       *widget.Label
+	KSMButton                     *widget.Button
+	CPUText                       *widget.Label
 }
 
 var CryoUtils Config
@@ -85,15 +100,29 @@ func getFreeSpace(path string) (int64, error) {
 	return int64(stat.Bfree * uint64(stat.Bsize)), nil
 }
 
-func getDirectorySize(path string) int64 {
+// Add up the size of every file under a directory, stopping early if cancelled.
+func getDirectorySize(ctx context.Context, path string) (int64, error) {
 	var size int64
-	_ = filepath.Walk(path, func(_ string, info os.FileInfo, _ error) error {
-		if !info.IsDir() {
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if cancelErr := checkCancelled(ctx); cancelErr != nil {
+			return cancelErr
+		}
+		// Unreadable entries are skipped, they can't be moved either.
+		if err == nil && !info.IsDir() {
 			size += info.Size()
 		}
 		return nil
 	})
-	return size
+	return size, err
+}
+
+// Return an error if the operation has been cancelled. Long operations check this wherever stopping
+// leaves nothing half-done, ex: between games or after the current file.
+func checkCancelled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("操作已取消: %w", err)
+	}
+	return nil
 }
 
 func isSymbolicLink(path string) bool {
@@ -158,40 +187,91 @@ func isSubPath(parent string, sub string) bool {
 	return true
 }
 
-// Write a file with a given string
-func writeFile(path string, contents string) error {
-	CryoUtils.InfoLog.Println("正在写入", path)
+// Get the user who ran sudo, when running as root under it.
+func getSudoUser() (*user.User, error) {
+	uid := os.Getenv("SUDO_UID")
+	if uid == "" || os.Geteuid() != 0 {
+		return nil, errors.New("未通过 sudo 运行")
+	}
+	return user.LookupId(uid)
+}
+
+// Get the home of the user CryoUtilities runs for, the one who ran sudo when running under it.
+func getUserHomeDirectory() string {
+	if account, err := getSudoUser(); err == nil {
+		return account.HomeDir
+	}
+	home, _ := os.UserHomeDir()
+	return home
+}
 
-	tempPath := filepath.Join(InstallDirectory, "temp.txt")
-	// Try to remove tempfile just in case it exists for some reason
-	_ = removeFile(tempPath)
-	// Write to the CU install directory to avoid permissions issues
-	f, err := os.Create(tempPath)
+// Hand something root created to the user who ran sudo, so it can still be changed without root.
+func handToSudoUser(chown func(uid int, gid int) error) error {
+	account, err := getSudoUser()
 	if err != nil {
-		CryoUtils.ErrorLog.Println(err)
-		return err
+		return nil
 	}
+	uid, _ := strconv.Atoi(account.Uid)
+	gid, _ := strconv.Atoi(account.Gid)
+	return chown(uid, gid)
+}
 
-	defer f.Close()
+// Open, creating it if needed, a file in SharedDirectory. One root creates is handed to the user who ran sudo.
+func openSharedFile(path string, flag int) (*os.File, error) {
+	file, err := os.OpenFile(path, flag|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = handToSudoUser(file.Chown)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
 
-	_, err = f.WriteString(contents)
+// Create a directory in SharedDirectory, handing it over like openSharedFile does.
+func makeSharedDirectory(path string) error {
+	err := os.MkdirAll(path, 0755)
 	if err != nil {
-		CryoUtils.ErrorLog.Println(err)
 		return err
 	}
+	return handToSudoUser(func(uid int, gid int) error {
+		return os.Chown(path, uid, gid)
+	})
+}
 
-	// Move the completed file to final location.
-	_, err = exec.Command("sudo", "mv", tempPath, path).Output()
+// Replace the contents of a file in SharedDirectory, creating it and its directory if needed.
+func writeSharedFile(path string, data []byte) error {
+	err := makeSharedDirectory(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	file, err := openSharedFile(path, os.O_WRONLY|os.O_TRUNC)
 	if err != nil {
-		return fmt.Errorf("将临时文件移动到最终位置时出错")
+		return err
 	}
+	_, err = file.Write(data)
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
 
+// Write a file with a given string
+func writeFile(path string, contents string) error {
+	CryoUtils.InfoLog.Println("正在写入", path)
+	err := getExecutor().WriteFile(path, []byte(contents))
+	if err != nil {
+		return withCommandOp("写入文件", err)
+	}
 	return nil
 }
 
 func removeFile(path string) error {
 	CryoUtils.InfoLog.Println("删除中", path)
-	_, err := exec.Command("sudo", "rm", path).Output()
+	err := getExecutor().RemoveFile(path)
 	if err != nil {
 		CryoUtils.ErrorLog.Println("无法删除", path, ", 可能已丢失了。")
 	}
@@ -248,15 +328,20 @@ func removeElementFromStringSlice(str string, slice []string) []string {
 }
 
 func getUnitStatus(param string) (string, error) {
-	var output string
-	cmd, err := exec.Command("sudo", "cat", UnitMatrix[param]).Output()
+	cmd, err := getExecutor().ReadFile(UnitMatrix[param])
 	if err != nil {
 		CryoUtils.ErrorLog.Println(err)
 		return "nil", err
 	}
+	return parseUnitValue(string(cmd)), nil
+}
+
+// Get the value of a unit, picking the selected one in units which present as a list.
+func parseUnitValue(contents string) string {
+	var output string
 	// This is just to get the actual value in units which present as a list.
-	if strings.Contains(string(cmd), "[") {
-		slice := strings.Fields(string(cmd))
+	if strings.Contains(contents, "[") {
+		slice := strings.Fields(contents)
 		for x := range slice {
 			if strings.Contains(slice[x], "[") {
 				output = strings.ReplaceAll(slice[x], "[", "")
@@ -264,17 +349,22 @@ func getUnitStatus(param string) (string, error) {
 			}
 		}
 	} else {
-		output = strings.TrimSpace(string(cmd))
+		output = strings.TrimSpace(contents)
 	}
-	return output, nil
+	return output
+}
+
+// Get the contents of the tmpfiles.d unit file that sets param to value on boot.
+func getUnitFileContents(param string, value string) string {
+	contents := strings.ReplaceAll(TemplateUnitFile, "PARAM", UnitMatrix[param])
+	return strings.ReplaceAll(contents, "VALUE", value)
 }
 
+// Write the unit file that sets param to value on boot, the caller holds LockTunables.
 func writeUnitFile(param string, value string) error {
 	path := filepath.Join(TmpFilesRoot, param+".conf")
 	CryoUtils.InfoLog.Println("正在写入", value, "to", path, "保存", param, "设置中...")
-	contents := strings.ReplaceAll(TemplateUnitFile, "PARAM", UnitMatrix[param])
-	contents = strings.ReplaceAll(contents, "VALUE", value)
-	err := writeFile(path, contents)
+	err := writeFile(path, getUnitFileContents(param, value))
 	if err != nil {
 		CryoUtils.ErrorLog.Println(err)
 		return err
@@ -282,6 +372,7 @@ func writeUnitFile(param string, value string) error {
 	return nil
 }
 
+// Remove param's unit file, the caller holds LockTunables.
 func removeUnitFile(param string) error {
 	path := filepath.Join(TmpFilesRoot, param+".conf")
 	CryoUtils.InfoLog.Println("删除中", path, "恢复", param, "设置中...")
@@ -293,25 +384,24 @@ func removeUnitFile(param string) error {
 	return nil
 }
 
+// Write param's value to memory, the caller holds LockTunables.
 func setUnitValue(param string, value string) error {
 	CryoUtils.InfoLog.Println("正在写入", value, "参数", param, "到内存。")
-	// This mess is the only way I could find to push directly to unit files, without requiring
-	// a sudo password on installation to change capabilities.
-	echoCmd := exec.Command("echo", value)
-	teeCmd := exec.Command("sudo", "tee", UnitMatrix[param])
-	reader, writer := io.Pipe()
-	var buf bytes.Buffer
-	echoCmd.Stdout = writer
-	teeCmd.Stdin = reader
-	teeCmd.Stdout = &buf
-	echoCmd.Start()
-	teeCmd.Start()
-	echoCmd.Wait()
-	writer.Close()
-	teeCmd.Wait()
-	reader.Close()
-	io.Copy(os.Stdout, &buf)
+	err := writeKernelValue(UnitMatrix[param], value)
+	if err != nil {
+		CryoUtils.ErrorLog.Println(err)
+		return err
+	}
+
+	return nil
+}
 
+// Write a value to a root-owned kernel interface in /proc or /sys.
+func writeKernelValue(path string, value string) error {
+	err := getExecutor().WriteFile(path, []byte(value+"\n"))
+	if err != nil {
+		return withCommandOp("写入内核参数", err)
+	}
 	return nil
 }
 
@@ -326,10 +416,32 @@ func getHumanVRAMSize(size int) string {
 	return text
 }
 
-func removeGameData(removeList []string, locations []string) {
+// Converts a size in kB, as found in /proc/meminfo, to a human-readable format.
+func getHumanMemorySize(kb int64) string {
+	switch {
+	case kb >= 1024*1024:
+		return fmt.Sprintf("%.1fGB", float64(kb)/(1024*1024))
+	case kb >= 1024:
+		return fmt.Sprintf("%.0fMB", float64(kb)/1024)
+	default:
+		return fmt.Sprintf("%dKB", kb)
+	}
+}
+
+// Remove the data of each game from every location, stopping between games if cancelled.
+func removeGameData(ctx context.Context, removeList []string, locations []string) error {
+	release, err := acquireLock(LockGameData)
+	if err != nil {
+		return err
+	}
+	defer release()
 
 	CryoUtils.InfoLog.Println("删除以下内容:")
 	for i := range removeList {
+		err = checkCancelled(ctx)
+		if err != nil {
+			return err
+		}
 		for j := range locations {
 			path := filepath.Join(locations[j], removeList[i])
 			CryoUtils.InfoLog.Println(path)
@@ -340,4 +452,5 @@ func removeGameData(removeList []string, locations []string) {
 			}
 		}
 	}
+	return nil
 }
@@ -1,6 +1,20 @@
 package internal
 
-import "testing"
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Handlers log as they go, give them somewhere to write.
+	CryoUtils.InfoLog = log.New(io.Discard, "", 0)
+	CryoUtils.ErrorLog = log.New(io.Discard, "", 0)
+	os.Exit(m.Run())
+}
 
 func TestGetHumanVRAMSize(t *testing.T) {
 	type args struct {
@@ -48,4 +62,25 @@ func TestGetHumanVRAMSize(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestWriteSharedFileUnderSudo(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("only root hands files over")
+	}
+	t.Setenv("SUDO_UID", "65534")
+	path := filepath.Join(t.TempDir(), "bench", "report.json")
+
+	// Written by the CLI under sudo, the GUI must still be able to replace it.
+	if err := writeSharedFile(path, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{filepath.Dir(path), path} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uid := info.Sys().(*syscall.Stat_t).Uid; uid != 65534 {
+			t.Errorf("%s owned by uid %d, want 65534", p, uid)
+		}
+	}
+}
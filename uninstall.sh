@@ -20,6 +20,9 @@ if zenity --question --title="Disclaimer" --text="This script will uninstall Cry
     fi
     # Revert everything to stock
     sudo bash "$HOME"/.cryo_utilities/cryo_utilities stock
+    # Remove the rule that let the helper start without a password, and the copy it pointed at
+    sudo rm -f /etc/sudoers.d/zz-cryoutilities
+    sudo rm -rf /var/lib/cryoutilities
   fi
   # Delete install directory
   rm -rf "$HOME/.cryo_utilities"